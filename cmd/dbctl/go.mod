@@ -0,0 +1,10 @@
+require (
+	github.com/aws/aws-sdk-go v1.34.13
+	shared v0.0.0
+)
+
+replace shared => ../../shared
+
+module dbctl
+
+go 1.14
@@ -0,0 +1,130 @@
+// Command dbctl manages the DynamoDB tables of the functions as defined in
+// schema.go, which mirrors template.yaml.
+//
+//	dbctl [flags] create    creates the tables that do not exist yet
+//	dbctl [flags] migrate   also adds missing indexes, streams and TTLs to existing tables
+//	                        and applies the data migrations of migrations.go
+//	dbctl [flags] drop      deletes the tables
+//	dbctl [flags] seed      puts the fixtures into the tables
+//	dbctl [flags] reset     drops, creates and seeds the tables
+//
+// It talks to DynamoDB Local at -endpoint. With an empty -endpoint it uses
+// DynamoDB in AWS, where drop, seed and reset also need -force.
+//
+// Data migrations are recorded in the migrations table once applied. With
+// -dry-run, migrate leaves the tables alone and only reports the items the
+// pending data migrations would change.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"shared"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: dbctl [flags] create|migrate|drop|seed|reset\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	endpoint := flag.String("endpoint", "http://localhost:8000", "DynamoDB endpoint, empty for DynamoDB in AWS")
+	region := flag.String("region", os.Getenv("AWS_REGION"), "region of DynamoDB in AWS")
+	flag.StringVar(&stage, "stage", os.Getenv("STAGE"), "stage whose tables to work on, such as dev, staging or prod")
+	fixturesDir := flag.String("fixtures", "fixtures", "directory of the fixtures to seed")
+	force := flag.Bool("force", false, "allow drop, seed and reset on DynamoDB in AWS")
+	dryRun := flag.Bool("dry-run", false, "only report what the data migrations of migrate would change")
+	batchSize := flag.Int64("batch", 100, "items the data migrations scan and checkpoint at a time")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 || *batchSize < 1 {
+		usage()
+		os.Exit(2)
+	}
+	command := flag.Arg(0)
+
+	cfg := shared.Config{Env: shared.EnvAWS, Region: *region, Stage: stage, Tables: shared.StageTables(stage)}
+	if *endpoint != "" {
+		cfg.Env = shared.EnvSAMLocal
+		cfg.DBEndpoint = *endpoint
+		if cfg.Region == "" {
+			cfg.Region = shared.LocalRegion
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	db, err := shared.NewDynamoDB(cfg)
+	if err != nil {
+		fmt.Printf("Could not create the DynamoDB client %s\n", err)
+		os.Exit(1)
+	}
+
+	opts := options{
+		FixturesDir: *fixturesDir,
+		Destructive: cfg.Local() || *force,
+		DryRun:      *dryRun,
+		BatchSize:   *batchSize,
+	}
+	if err := run(db, command, opts); err != nil {
+		fmt.Printf("%s failed: %s\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// options are the flags of the commands.
+type options struct {
+	FixturesDir string
+	// Destructive allows the commands that lose or overwrite data.
+	Destructive bool
+	DryRun      bool
+	BatchSize   int64
+}
+
+// run runs a command.
+func run(db dynamodbiface.DynamoDBAPI, command string, opts options) error {
+	switch command {
+	case "create":
+		return createTables(db)
+	case "migrate":
+		if opts.DryRun {
+			return runMigrations(db, opts.BatchSize, true)
+		}
+		if err := migrateTables(db); err != nil {
+			return err
+		}
+		return runMigrations(db, opts.BatchSize, false)
+	}
+
+	if command != "drop" && command != "seed" && command != "reset" {
+		return fmt.Errorf("unknown command, want create, migrate, drop, seed or reset")
+	}
+	if !opts.Destructive {
+		return fmt.Errorf("refusing to %s the tables in AWS without -force", command)
+	}
+	var fixtures []*fixture
+	if command != "drop" {
+		// Broken fixtures should not leave reset with empty tables.
+		var err error
+		if fixtures, err = loadFixtures(opts.FixturesDir); err != nil {
+			return err
+		}
+	}
+	switch command {
+	case "drop":
+		return dropTables(db)
+	case "seed":
+		return seedTables(db, fixtures)
+	}
+	if err := dropTables(db); err != nil {
+		return err
+	}
+	if err := createTables(db); err != nil {
+		return err
+	}
+	return seedTables(db, fixtures)
+}
@@ -0,0 +1,945 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"shared"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// stageTableNamePattern matches the TableName of a table in template.yaml,
+// which names the table as shared.StageTableName does.
+var stageTableNamePattern = regexp.MustCompile(`^!If \[IsProd, (\w+), !Sub "\$\{Stage\}-(\w+)"\]$`)
+
+// templateTables reads the DynamoDB tables of template.yaml and the
+// attributes each of them defines. Tables are named by their prod names.
+func templateTables(t *testing.T) ([]*table, map[string][]string) {
+	t.Helper()
+	file, err := os.Open("../../template.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var parsed []*table
+	definitions := map[string][]string{}
+	var current *table
+	var idx *index
+	resource, section, attribute := "", "", ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "Outputs:" {
+			break
+		}
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "- ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		parts := strings.SplitN(trimmed, ":", 2)
+		key, value := strings.TrimSpace(parts[0]), ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+		switch {
+		case indent == 2:
+			resource, current = key, nil
+		case indent == 4 && key == "Type" && value == "AWS::DynamoDB::Table":
+			current = &table{Resource: resource}
+			parsed = append(parsed, current)
+		case current == nil:
+		case indent == 6:
+			section, idx = key, nil
+			if key == "TableName" {
+				current.Name = value
+				if match := stageTableNamePattern.FindStringSubmatch(value); match != nil && match[1] == match[2] {
+					current.Name = match[1]
+				} else {
+					t.Errorf("%s is named %s, expected !If [IsProd, name, !Sub \"${Stage}-name\"]", resource, value)
+				}
+			}
+		case key == "IndexName":
+			current.Indexes = append(current.Indexes, index{Name: value})
+			idx = &current.Indexes[len(current.Indexes)-1]
+		case key == "AttributeName":
+			attribute = value
+			switch section {
+			case "AttributeDefinitions":
+				definitions[current.Name] = append(definitions[current.Name], value)
+			case "TimeToLiveSpecification":
+				current.TTL = value
+			}
+		case key == "AttributeType" && value != dynamodb.ScalarAttributeTypeS:
+			t.Errorf("%s.%s is of type %s, dbctl only knows string keys", current.Name, attribute, value)
+		case key == "KeyType":
+			hash, rangeKey := &current.Hash, &current.Range
+			if idx != nil {
+				hash, rangeKey = &idx.Hash, &idx.Range
+			}
+			if value == dynamodb.KeyTypeHash {
+				*hash = attribute
+			} else {
+				*rangeKey = attribute
+			}
+		case key == "ProjectionType" && idx != nil:
+			idx.Projection = value
+		case key == "StreamViewType":
+			current.Stream = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return parsed, definitions
+}
+
+func TestTablesMatchTemplate(t *testing.T) {
+	parsed, definitions := templateTables(t)
+	if len(parsed) == 0 {
+		t.Fatal("found no tables in template.yaml")
+	}
+	inTemplate := map[string]bool{}
+	for _, want := range parsed {
+		inTemplate[want.Resource] = true
+		got := findTable(want.Name)
+		if got == nil {
+			t.Errorf("%s of template.yaml is missing", want.Name)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s is %+v, template.yaml has %+v", want.Name, got, want)
+		}
+		attributes := got.attributes()
+		sort.Strings(attributes)
+		sort.Strings(definitions[want.Name])
+		if !reflect.DeepEqual(attributes, definitions[want.Name]) {
+			t.Errorf("attributes of %s are %v, template.yaml defines %v", want.Name, attributes, definitions[want.Name])
+		}
+	}
+	for _, table := range tables {
+		if table.Resource != "" && !inTemplate[table.Resource] {
+			t.Errorf("%s is not in template.yaml", table.Resource)
+		}
+	}
+}
+
+// TestTableVariables checks that template.yaml passes every table to the
+// functions as <NAME>_TABLE, and that the names the functions default to
+// outside AWS are the ones dbctl creates.
+func TestTableVariables(t *testing.T) {
+	data, err := ioutil.ReadFile("../../template.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variables := map[string]string{}
+	for _, match := range regexp.MustCompile(`(?m)^ +([A-Z]+_TABLE): !Ref (\w+)$`).FindAllStringSubmatch(string(data), -1) {
+		variables[match[1]] = match[2]
+	}
+	defaults := map[string]bool{}
+	value := reflect.ValueOf(shared.DefaultTables)
+	for i := 0; i < value.NumField(); i++ {
+		defaults[value.Field(i).String()] = true
+	}
+	deployed := 0
+	for _, table := range tables {
+		if table.Resource == "" {
+			continue
+		}
+		deployed++
+		if variable := strings.ToUpper(table.Name) + "_TABLE"; variables[variable] != table.Resource {
+			t.Errorf("%s is %q in template.yaml, want !Ref %s", variable, variables[variable], table.Resource)
+		}
+		if !defaults[table.Name] {
+			t.Errorf("%s is not in shared.DefaultTables", table.Name)
+		}
+	}
+	if len(variables) != deployed || len(defaults) != deployed {
+		t.Errorf("template.yaml passes %d tables and shared has %d, want %d", len(variables), len(defaults), deployed)
+	}
+
+	// sam local resolves !Ref to the logical ID, so env.local.json passes
+	// the names dbctl creates instead.
+	var local struct{ Parameters map[string]string }
+	data, err = ioutil.ReadFile("../../env.local.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &local); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if variable := strings.ToUpper(table.Name) + "_TABLE"; table.Resource != "" && local.Parameters[variable] != table.Name {
+			t.Errorf("%s is %q in env.local.json, want %s", variable, local.Parameters[variable], table.Name)
+		}
+	}
+}
+
+func TestCreateInput(t *testing.T) {
+	input := findTable("posts").createInput()
+	if err := input.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var attributes []string
+	for _, definition := range input.AttributeDefinitions {
+		attributes = append(attributes, aws.StringValue(definition.AttributeName))
+	}
+	if want := []string{"Id", "UserId", "PostTime", "FeedKey", "IssueId"}; !reflect.DeepEqual(attributes, want) {
+		t.Errorf("attributes = %v, want %v", attributes, want)
+	}
+	if len(input.GlobalSecondaryIndexes) != 3 || !aws.BoolValue(input.StreamSpecification.StreamEnabled) {
+		t.Errorf("indexes = %v, stream = %v", input.GlobalSecondaryIndexes, input.StreamSpecification)
+	}
+	if input := findTable("users").createInput(); input.GlobalSecondaryIndexes != nil || input.StreamSpecification != nil {
+		t.Errorf("users = %v", input)
+	}
+}
+
+// fakeDB keeps tables and items in memory. Waiting always succeeds at once,
+// as tables and indexes are active as soon as they are created.
+type fakeDB struct {
+	dynamodbiface.DynamoDBAPI
+	tables map[string]*dynamodb.TableDescription
+	ttl    map[string]string
+	items  map[string][]map[string]*dynamodb.AttributeValue
+	// calls are the changing calls made, as "Method table".
+	calls []string
+	// unprocessed is the number of items the next BatchWriteItem leaves
+	// unprocessed.
+	unprocessed int
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		tables: map[string]*dynamodb.TableDescription{},
+		ttl:    map[string]string{},
+		items:  map[string][]map[string]*dynamodb.AttributeValue{},
+	}
+}
+
+func notFound() error {
+	return awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found", nil)
+}
+
+func (f *fakeDB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	description, ok := f.tables[aws.StringValue(input.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	return &dynamodb.DescribeTableOutput{Table: description}, nil
+}
+
+func activeIndex(name *string, keys []*dynamodb.KeySchemaElement) *dynamodb.GlobalSecondaryIndexDescription {
+	return &dynamodb.GlobalSecondaryIndexDescription{
+		IndexName:   name,
+		KeySchema:   keys,
+		IndexStatus: aws.String(dynamodb.IndexStatusActive),
+	}
+}
+
+func (f *fakeDB) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	name := aws.StringValue(input.TableName)
+	f.calls = append(f.calls, "CreateTable "+name)
+	description := &dynamodb.TableDescription{
+		TableName:           input.TableName,
+		KeySchema:           input.KeySchema,
+		StreamSpecification: input.StreamSpecification,
+		TableStatus:         aws.String(dynamodb.TableStatusActive),
+	}
+	for _, i := range input.GlobalSecondaryIndexes {
+		description.GlobalSecondaryIndexes = append(description.GlobalSecondaryIndexes, activeIndex(i.IndexName, i.KeySchema))
+	}
+	f.tables[name] = description
+	return &dynamodb.CreateTableOutput{TableDescription: description}, nil
+}
+
+func (f *fakeDB) UpdateTable(input *dynamodb.UpdateTableInput) (*dynamodb.UpdateTableOutput, error) {
+	name := aws.StringValue(input.TableName)
+	f.calls = append(f.calls, "UpdateTable "+name)
+	description, ok := f.tables[name]
+	if !ok {
+		return nil, notFound()
+	}
+	for _, update := range input.GlobalSecondaryIndexUpdates {
+		description.GlobalSecondaryIndexes = append(description.GlobalSecondaryIndexes, activeIndex(update.Create.IndexName, update.Create.KeySchema))
+	}
+	if input.StreamSpecification != nil {
+		description.StreamSpecification = input.StreamSpecification
+	}
+	return &dynamodb.UpdateTableOutput{TableDescription: description}, nil
+}
+
+func (f *fakeDB) DeleteTable(input *dynamodb.DeleteTableInput) (*dynamodb.DeleteTableOutput, error) {
+	name := aws.StringValue(input.TableName)
+	f.calls = append(f.calls, "DeleteTable "+name)
+	delete(f.tables, name)
+	delete(f.ttl, name)
+	delete(f.items, name)
+	return &dynamodb.DeleteTableOutput{}, nil
+}
+
+func (f *fakeDB) WaitUntilTableExists(*dynamodb.DescribeTableInput) error {
+	return nil
+}
+
+func (f *fakeDB) WaitUntilTableNotExists(*dynamodb.DescribeTableInput) error {
+	return nil
+}
+
+func (f *fakeDB) DescribeTimeToLive(input *dynamodb.DescribeTimeToLiveInput) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	description := &dynamodb.TimeToLiveDescription{TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusDisabled)}
+	if attribute, ok := f.ttl[aws.StringValue(input.TableName)]; ok {
+		description = &dynamodb.TimeToLiveDescription{
+			AttributeName:    aws.String(attribute),
+			TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusEnabled),
+		}
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: description}, nil
+}
+
+func (f *fakeDB) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	name := aws.StringValue(input.TableName)
+	f.calls = append(f.calls, "UpdateTimeToLive "+name)
+	f.ttl[name] = aws.StringValue(input.TimeToLiveSpecification.AttributeName)
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func (f *fakeDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for name, requests := range input.RequestItems {
+		if len(requests) > batchSize {
+			return nil, awserr.New("ValidationException", "Too many items requested for the BatchWriteItem call", nil)
+		}
+		if _, ok := f.tables[name]; !ok {
+			return nil, notFound()
+		}
+		for _, request := range requests {
+			if f.unprocessed > 0 {
+				f.unprocessed--
+				output.UnprocessedItems[name] = append(output.UnprocessedItems[name], request)
+				continue
+			}
+			f.put(name, request.PutRequest.Item)
+		}
+	}
+	return output, nil
+}
+
+// keys returns the key attributes of a table.
+func (f *fakeDB) keys(name string) (string, string) {
+	hash, rangeKey := "", ""
+	for _, key := range f.tables[name].KeySchema {
+		if aws.StringValue(key.KeyType) == dynamodb.KeyTypeHash {
+			hash = aws.StringValue(key.AttributeName)
+		} else {
+			rangeKey = aws.StringValue(key.AttributeName)
+		}
+	}
+	return hash, rangeKey
+}
+
+// put replaces the item with the same key, like PutItem.
+func (f *fakeDB) put(name string, item map[string]*dynamodb.AttributeValue) {
+	hash, rangeKey := f.keys(name)
+	for i, stored := range f.items[name] {
+		if itemString(stored, hash) == itemString(item, hash) && itemString(stored, rangeKey) == itemString(item, rangeKey) {
+			f.items[name][i] = item
+			return
+		}
+	}
+	f.items[name] = append(f.items[name], item)
+}
+
+func (f *fakeDB) find(name string, key map[string]*dynamodb.AttributeValue) int {
+	hash, rangeKey := f.keys(name)
+	for i, item := range f.items[name] {
+		if itemString(item, hash) == itemString(key, hash) && itemString(item, rangeKey) == itemString(key, rangeKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	name := aws.StringValue(input.TableName)
+	if _, ok := f.tables[name]; !ok {
+		return nil, notFound()
+	}
+	output := &dynamodb.GetItemOutput{}
+	if i := f.find(name, input.Key); i >= 0 {
+		output.Item = f.items[name][i]
+	}
+	return output, nil
+}
+
+func (f *fakeDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	name := aws.StringValue(input.TableName)
+	f.calls = append(f.calls, "PutItem "+name)
+	if _, ok := f.tables[name]; !ok {
+		return nil, notFound()
+	}
+	f.put(name, input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// UpdateItem understands the "SET #a = :v, ..." updates and the conditions
+// joined by AND that applyChanges writes.
+func (f *fakeDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	name := aws.StringValue(input.TableName)
+	f.calls = append(f.calls, "UpdateItem "+name)
+	i := f.find(name, input.Key)
+	if i < 0 {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	item := f.items[name][i]
+	attribute := func(placeholder string) string {
+		return aws.StringValue(input.ExpressionAttributeNames[strings.TrimSpace(placeholder)])
+	}
+	for _, condition := range strings.Split(aws.StringValue(input.ConditionExpression), " AND ") {
+		var ok bool
+		switch {
+		case strings.HasPrefix(condition, "attribute_not_exists("):
+			_, exists := item[attribute(strings.TrimSuffix(strings.TrimPrefix(condition, "attribute_not_exists("), ")"))]
+			ok = !exists
+		case strings.HasPrefix(condition, "attribute_type("):
+			args := strings.Split(strings.TrimSuffix(strings.TrimPrefix(condition, "attribute_type("), ")"), ",")
+			value, exists := item[attribute(args[0])]
+			ok = exists && aws.BoolValue(value.NULL) && itemString(input.ExpressionAttributeValues, strings.TrimSpace(args[1])) == "NULL"
+		default:
+			sides := strings.Split(condition, " = ")
+			ok = reflect.DeepEqual(item[attribute(sides[0])], input.ExpressionAttributeValues[sides[1]])
+		}
+		if !ok {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+	updated := map[string]*dynamodb.AttributeValue{}
+	for name, value := range item {
+		updated[name] = value
+	}
+	for _, set := range strings.Split(strings.TrimPrefix(aws.StringValue(input.UpdateExpression), "SET "), ", ") {
+		sides := strings.Split(set, " = ")
+		updated[attribute(sides[0])] = input.ExpressionAttributeValues[sides[1]]
+	}
+	f.items[name][i] = updated
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	name := aws.StringValue(input.TableName)
+	if _, ok := f.tables[name]; !ok {
+		return nil, notFound()
+	}
+	items := f.items[name]
+	start := 0
+	if input.ExclusiveStartKey != nil {
+		start = f.find(name, input.ExclusiveStartKey) + 1
+	}
+	end := start + int(aws.Int64Value(input.Limit))
+	if end > len(items) {
+		end = len(items)
+	}
+	output := &dynamodb.ScanOutput{Items: append([]map[string]*dynamodb.AttributeValue(nil), items[start:end]...)}
+	if end < len(items) {
+		hash, rangeKey := f.keys(name)
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{hash: items[end-1][hash]}
+		if rangeKey != "" {
+			output.LastEvaluatedKey[rangeKey] = items[end-1][rangeKey]
+		}
+	}
+	return output, nil
+}
+
+// itemString returns a string attribute of an item, empty if it has none.
+func itemString(item map[string]*dynamodb.AttributeValue, name string) string {
+	if value, ok := item[name]; ok {
+		return aws.StringValue(value.S)
+	}
+	return ""
+}
+
+func itemNumber(item map[string]*dynamodb.AttributeValue, name string) string {
+	if value, ok := item[name]; ok {
+		return aws.StringValue(value.N)
+	}
+	return "0"
+}
+
+func TestCreateTables(t *testing.T) {
+	db := newFakeDB()
+	db.CreateTable(findTable("users").createInput())
+	db.calls = nil
+	if err := createTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.tables) != len(tables) {
+		t.Errorf("%d tables, want %d", len(db.tables), len(tables))
+	}
+	for _, call := range db.calls {
+		if call == "CreateTable users" {
+			t.Error("created users again")
+		}
+	}
+	for _, name := range []string{"notifications", "processedevents", "connections", "webhookdeliveries"} {
+		if db.ttl[name] != "ExpiresAt" {
+			t.Errorf("TTL of %s = %q", name, db.ttl[name])
+		}
+	}
+}
+
+func TestMigrateTables(t *testing.T) {
+	db := newFakeDB()
+	if err := createTables(db); err != nil {
+		t.Fatal(err)
+	}
+	// A posts table from before the feed and the stories, without a stream,
+	// and a notifications table without its TTL.
+	posts := db.tables["posts"]
+	posts.GlobalSecondaryIndexes = posts.GlobalSecondaryIndexes[:1]
+	posts.StreamSpecification = nil
+	delete(db.ttl, "notifications")
+	delete(db.tables, "webhooks")
+	db.calls = nil
+
+	if err := migrateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"UpdateTable posts",
+		"UpdateTable posts",
+		"UpdateTable posts",
+		"UpdateTimeToLive notifications",
+		"CreateTable webhooks",
+	}
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("calls = %v, want %v", db.calls, want)
+	}
+	var indexes []string
+	for _, i := range db.tables["posts"].GlobalSecondaryIndexes {
+		indexes = append(indexes, aws.StringValue(i.IndexName))
+	}
+	if want := []string{"UserPostsIndex", "FeedIndex", "IssueStoriesIndex"}; !reflect.DeepEqual(indexes, want) {
+		t.Errorf("indexes of posts = %v, want %v", indexes, want)
+	}
+	if stream := db.tables["posts"].StreamSpecification; stream == nil || !aws.BoolValue(stream.StreamEnabled) {
+		t.Errorf("stream of posts = %v", stream)
+	}
+
+	db.calls = nil
+	if err := migrateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.calls) != 0 {
+		t.Errorf("migrating again made the calls %v", db.calls)
+	}
+}
+
+func TestMigrateTablesRejectsChangedKeys(t *testing.T) {
+	db := newFakeDB()
+	input := findTable("users").createInput()
+	input.KeySchema = keySchema("Email", "")
+	db.CreateTable(input)
+	if err := migrateTables(db); err == nil || !strings.Contains(err.Error(), "users") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := newFakeDB()
+	if err := run(db, "reset", options{FixturesDir: "fixtures", Destructive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.tables) != len(tables) || len(db.items["issues"]) == 0 {
+		t.Errorf("%d tables, %d issues after reset", len(db.tables), len(db.items["issues"]))
+	}
+
+	for _, command := range []string{"drop", "seed", "reset"} {
+		if err := run(db, command, options{FixturesDir: "fixtures"}); err == nil {
+			t.Errorf("%s ran without -force in AWS", command)
+		}
+	}
+	if err := run(db, "seed", options{FixturesDir: "nowhere", Destructive: true}); err == nil {
+		t.Error("seeded without fixtures")
+	}
+	if err := run(db, "truncate", options{FixturesDir: "fixtures", Destructive: true}); err == nil {
+		t.Error("ran an unknown command")
+	}
+	if len(db.tables) != len(tables) {
+		t.Errorf("%d tables left, want %d", len(db.tables), len(tables))
+	}
+
+	if err := run(db, "drop", options{FixturesDir: "fixtures", Destructive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.tables) != 0 {
+		t.Errorf("%d tables left after drop", len(db.tables))
+	}
+}
+
+func TestRunStage(t *testing.T) {
+	stage = "staging"
+	defer func() { stage = "" }()
+	db := newFakeDB()
+	if err := run(db, "reset", options{FixturesDir: "fixtures", Destructive: true, BatchSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(db, "migrate", options{BatchSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	for name := range db.tables {
+		if !strings.HasPrefix(name, "staging-") {
+			t.Errorf("created %s", name)
+		}
+	}
+	if len(db.items["staging-issues"]) == 0 || len(db.items["staging-migrations"]) != len(migrations) {
+		t.Errorf("%d issues and %d migration records in staging", len(db.items["staging-issues"]), len(db.items["staging-migrations"]))
+	}
+}
+
+func TestSeedTablesRetriesUnprocessedItems(t *testing.T) {
+	db := newFakeDB()
+	createTables(db)
+	fixtures, err := loadFixtures("fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.unprocessed = 3
+	if err := seedTables(db, fixtures); err != nil {
+		t.Fatal(err)
+	}
+	// Seeding again replaces the items instead of adding to them.
+	if err := seedTables(db, fixtures); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		if len(db.items[f.Table]) != len(f.Items) {
+			t.Errorf("%d items in %s, want %d", len(db.items[f.Table]), f.Table, len(f.Items))
+		}
+	}
+
+	db.unprocessed = batchSize * writeAttempts
+	if err := seedTables(db, fixtures); err == nil {
+		t.Error("seeding succeeded with items left unprocessed")
+	}
+}
+
+// TestFixtures checks that the fixtures are what the functions would have
+// stored: every item has its keys and the counters match the items counted.
+func TestFixtures(t *testing.T) {
+	fixtures, err := loadFixtures("fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := map[string][]map[string]*dynamodb.AttributeValue{}
+	for _, f := range fixtures {
+		table := findTable(f.Table)
+		for _, item := range f.Items {
+			for _, key := range table.attributes() {
+				if value, ok := item[key]; ok && value.S == nil {
+					t.Errorf("%s of %s is not a string: %v", key, f.Table, value)
+				}
+			}
+			if itemString(item, table.Hash) == "" || (table.Range != "" && itemString(item, table.Range) == "") {
+				t.Errorf("item of %s without its key: %v", f.Table, item)
+			}
+		}
+		items[f.Table] = f.Items
+	}
+	for _, name := range []string{"users", "issues", "posts", "postcomments"} {
+		if len(items[name]) == 0 {
+			t.Errorf("no fixtures for %s", name)
+		}
+	}
+
+	users := map[string]bool{}
+	for _, user := range items["users"] {
+		users[itemString(user, "Id")] = true
+	}
+	count := func(table string, attribute string, value string) int {
+		n := 0
+		for _, item := range items[table] {
+			if itemString(item, attribute) == value {
+				n++
+			}
+		}
+		return n
+	}
+	issues := map[string]map[string]*dynamodb.AttributeValue{}
+	for _, issue := range items["issues"] {
+		id := itemString(issue, "Id")
+		issues[id] = issue
+		if !users[itemString(issue, "UserID")] {
+			t.Errorf("issue %s by an unknown user", id)
+		}
+		if geoHash := itemString(issue, "GeoHash"); !strings.HasPrefix(geoHash, itemString(issue, "GeoKey")) {
+			t.Errorf("issue %s has the GeoKey %s for the GeoHash %s", id, itemString(issue, "GeoKey"), geoHash)
+		}
+		if got := itemNumber(issue, "SupportCount"); got != strconv.Itoa(count("issuesupport", "IssueId", id)) {
+			t.Errorf("issue %s has the SupportCount %s, issuesupport has %d", id, got, count("issuesupport", "IssueId", id))
+		}
+		if helpers, ok := issue["Helpers"]; ok {
+			for helper := range helpers.M {
+				if !users[helper] {
+					t.Errorf("issue %s helped by an unknown user %s", id, helper)
+				}
+			}
+		}
+		if accepted, ok := issue["Accepted"]; ok {
+			for _, helper := range accepted.SS {
+				if _, ok := issue["Helpers"].M[aws.StringValue(helper)]; !ok {
+					t.Errorf("issue %s accepted %s, who did not offer help", id, aws.StringValue(helper))
+				}
+			}
+		}
+	}
+	for _, support := range append(items["issuesupport"], items["subscriptions"]...) {
+		if issues[itemString(support, "IssueId")] == nil || !users[itemString(support, "UserId")] {
+			t.Errorf("support or subscription of an unknown issue or user: %v", support)
+		}
+	}
+	for _, post := range items["posts"] {
+		id := itemString(post, "Id")
+		if !users[itemString(post, "UserId")] || itemString(post, "FeedKey") != "post" {
+			t.Errorf("post %s by an unknown user or without the FeedKey", id)
+		}
+		if issueID := itemString(post, "IssueId"); issueID != "" && itemString(issues[issueID], "StatusMsg") != "Resolved" {
+			t.Errorf("post %s is a story about %s, which is not a resolved issue", id, issueID)
+		}
+		if got, want := itemNumber(post, "LikeCount"), strconv.Itoa(count("postlikes", "PostId", id)); got != want {
+			t.Errorf("post %s has the LikeCount %s, postlikes has %s", id, got, want)
+		}
+		if got, want := itemNumber(post, "CommentCount"), strconv.Itoa(count("postcomments", "PostId", id)); got != want {
+			t.Errorf("post %s has the CommentCount %s, postcomments has %s", id, got, want)
+		}
+	}
+	for _, comment := range items["postcomments"] {
+		if !strings.HasPrefix(itemString(comment, "CommentId"), itemString(comment, "Created")+"#") {
+			t.Errorf("comment %s does not start with its time", itemString(comment, "CommentId"))
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		migrate func(map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error)
+		item    map[string]*dynamodb.AttributeValue
+		changes map[string]*dynamodb.AttributeValue
+		fails   bool
+	}{
+		{"legacy time", normalizeTimes("JoinedDate", "LastLogin"),
+			map[string]*dynamodb.AttributeValue{
+				"JoinedDate": {S: aws.String("2020-09-07 12:00:00.123456 +0530 IST m=+0.000100001")},
+				"LastLogin":  {S: aws.String("2020-09-08T01:02:03.000Z")},
+			},
+			map[string]*dynamodb.AttributeValue{"JoinedDate": {S: aws.String("2020-09-07T06:30:00.123Z")}}, false},
+		{"rfc 3339 with an offset", normalizeTimes("Created"),
+			map[string]*dynamodb.AttributeValue{"Created": {S: aws.String("2020-09-07T12:00:00+05:30")}},
+			map[string]*dynamodb.AttributeValue{"Created": {S: aws.String("2020-09-07T06:30:00.000Z")}}, false},
+		{"normalized time", normalizeTimes("Created"),
+			map[string]*dynamodb.AttributeValue{"Created": {S: aws.String("2020-09-07T06:30:00.123Z")}}, nil, false},
+		{"missing time", normalizeTimes("Created"), map[string]*dynamodb.AttributeValue{}, nil, false},
+		{"broken time", normalizeTimes("Created"),
+			map[string]*dynamodb.AttributeValue{"Created": {S: aws.String("yesterday")}}, nil, true},
+		{"missing helpers", backfillHelpers, map[string]*dynamodb.AttributeValue{},
+			map[string]*dynamodb.AttributeValue{"Helpers": {M: map[string]*dynamodb.AttributeValue{}}}, false},
+		{"null helpers", backfillHelpers,
+			map[string]*dynamodb.AttributeValue{"Helpers": {NULL: aws.Bool(true)}},
+			map[string]*dynamodb.AttributeValue{"Helpers": {M: map[string]*dynamodb.AttributeValue{}}}, false},
+		{"helpers", backfillHelpers,
+			map[string]*dynamodb.AttributeValue{"Helpers": {M: map[string]*dynamodb.AttributeValue{"user-1": {S: aws.String("Asha")}}}}, nil, false},
+		{"missing feed key", backfillFeedKey, map[string]*dynamodb.AttributeValue{},
+			map[string]*dynamodb.AttributeValue{"FeedKey": {S: aws.String("post")}}, false},
+		{"feed key", backfillFeedKey,
+			map[string]*dynamodb.AttributeValue{"FeedKey": {S: aws.String("post")}}, nil, false},
+	}
+	for _, test := range tests {
+		changes, err := test.migrate(test.item)
+		if (err != nil) != test.fails || !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: changes = %v, %v, want %v", test.name, changes, err, test.changes)
+		}
+	}
+}
+
+func TestMigrationIDsAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, m := range migrations {
+		if seen[m.ID] || findTable(m.Table) == nil {
+			t.Errorf("%s is a duplicate or migrates an unknown table %s", m.ID, m.Table)
+		}
+		seen[m.ID] = true
+	}
+}
+
+// legacyTimes are the time attributes the functions stored as
+// time.Time.String() before they stored RFC 3339.
+var legacyTimes = map[string][]string{
+	"issues":        {"Created"},
+	"users":         {"JoinedDate", "LastLogin"},
+	"issuesupport":  {"Created"},
+	"subscriptions": {"Created"},
+	"posts":         {"PostTime", "Edited"},
+}
+
+// seededDB returns a database seeded with the fixtures, with the times
+// migrations rewrite stored as they were before RFC 3339 and the posts
+// without the FeedKey they had before FeedIndex.
+func seededDB(t *testing.T) *fakeDB {
+	t.Helper()
+	db := newFakeDB()
+	if err := run(db, "reset", options{FixturesDir: "fixtures", Destructive: true}); err != nil {
+		t.Fatal(err)
+	}
+	ist := time.FixedZone("IST", 19800)
+	for table, attributes := range legacyTimes {
+		for _, item := range db.items[table] {
+			for _, attribute := range attributes {
+				if value, ok := item[attribute]; ok {
+					stored, err := shared.ParseTime(aws.StringValue(value.S))
+					if err != nil {
+						t.Fatal(err)
+					}
+					item[attribute] = &dynamodb.AttributeValue{S: aws.String(stored.In(ist).String())}
+				}
+			}
+		}
+	}
+	for _, post := range db.items["posts"] {
+		delete(post, "FeedKey")
+	}
+	db.calls = nil
+	return db
+}
+
+func recordStatus(db *fakeDB, id string) string {
+	if i := db.find(migrationsTable, map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(id)}}); i >= 0 {
+		return itemString(db.items[migrationsTable][i], "Status")
+	}
+	return ""
+}
+
+func TestRunMigrations(t *testing.T) {
+	db := seededDB(t)
+	if err := run(db, "migrate", options{BatchSize: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range db.items["issues"] {
+		created := itemString(issue, "Created")
+		if parsed, err := shared.ParseTime(created); err != nil || shared.FormatTime(parsed) != created {
+			t.Errorf("issue %s was created %q", itemString(issue, "Id"), created)
+		}
+		if helpers, ok := issue["Helpers"]; !ok || helpers.M == nil {
+			t.Errorf("issue %s has the Helpers %v", itemString(issue, "Id"), helpers)
+		}
+	}
+	for _, user := range db.items["users"] {
+		if joined := itemString(user, "JoinedDate"); !strings.HasSuffix(joined, "Z") {
+			t.Errorf("user %s joined %q", itemString(user, "Id"), joined)
+		}
+	}
+	for _, post := range db.items["posts"] {
+		if posted := itemString(post, "PostTime"); !strings.HasSuffix(posted, "Z") || itemString(post, "FeedKey") != feedKey {
+			t.Errorf("post %s was posted %q with the FeedKey %q", itemString(post, "Id"), posted, itemString(post, "FeedKey"))
+		}
+	}
+	for _, m := range migrations {
+		if status := recordStatus(db, m.ID); status != statusApplied {
+			t.Errorf("%s is %q", m.ID, status)
+		}
+	}
+
+	db.calls = nil
+	if err := run(db, "migrate", options{BatchSize: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.calls) != 0 {
+		t.Errorf("migrating again made the calls %v", db.calls)
+	}
+}
+
+func TestRunMigrationsResumesFromCheckpoint(t *testing.T) {
+	db := seededDB(t)
+	issues := db.items["issues"]
+	before := itemString(issues[0], "Created")
+	// The first run stopped after a batch of two issues, whose Created the
+	// resumed run must not see again.
+	putRecord(db, &record{
+		ID:         migrations[0].ID,
+		Status:     statusRunning,
+		Started:    "2020-09-25T10:00:00.000Z",
+		Checkpoint: map[string]*dynamodb.AttributeValue{"Id": issues[1]["Id"]},
+		Scanned:    2,
+	})
+	if err := runMigrations(db, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	issues = db.items["issues"]
+	if got := itemString(issues[0], "Created"); got != before {
+		t.Errorf("first issue was migrated again: %q", got)
+	}
+	for _, issue := range issues[2:] {
+		if created := itemString(issue, "Created"); !strings.HasSuffix(created, "Z") {
+			t.Errorf("issue %s was created %q", itemString(issue, "Id"), created)
+		}
+	}
+	record, err := getRecord(db, migrations[0].ID)
+	if err != nil || record.Status != statusApplied || record.Scanned != len(issues) || record.Checkpoint != nil {
+		t.Errorf("record = %+v, %v", record, err)
+	}
+}
+
+func TestRunMigrationsDryRun(t *testing.T) {
+	db := seededDB(t)
+	dropped := db.tables[migrationsTable]
+	delete(db.tables, migrationsTable)
+	issues := append([]map[string]*dynamodb.AttributeValue(nil), db.items["issues"]...)
+	if err := run(db, "migrate", options{BatchSize: 3, DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.calls) != 0 || !reflect.DeepEqual(db.items["issues"], issues) {
+		t.Errorf("dry run made the calls %v", db.calls)
+	}
+
+	db.tables[migrationsTable] = dropped
+	if err := run(db, "migrate", options{BatchSize: 3, DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.calls) != 0 {
+		t.Errorf("dry run made the calls %v", db.calls)
+	}
+}
+
+func TestApplyChangesSkipsChangedItems(t *testing.T) {
+	db := seededDB(t)
+	issues := findTable("issues")
+	stale := db.items["issues"][0]
+	changed := map[string]*dynamodb.AttributeValue{}
+	for name, value := range stale {
+		changed[name] = value
+	}
+	changed["Created"] = &dynamodb.AttributeValue{S: aws.String("2020-09-30T00:00:00.000Z")}
+	db.items["issues"][0] = changed
+
+	applied, err := applyChanges(db, issues, stale, map[string]*dynamodb.AttributeValue{"Created": {S: aws.String("2020-09-14T13:12:07.512Z")}})
+	if err != nil || applied {
+		t.Errorf("applied = %v, %v", applied, err)
+	}
+	if got := itemString(db.items["issues"][0], "Created"); got != "2020-09-30T00:00:00.000Z" {
+		t.Errorf("Created = %q", got)
+	}
+	if _, err := applyChanges(db, issues, stale, map[string]*dynamodb.AttributeValue{"Id": {S: aws.String("other")}}); err == nil {
+		t.Error("changed the key")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"shared"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// migration changes the stored items of a table one at a time.
+type migration struct {
+	// ID orders the migrations and records them in the migrations table,
+	// so it must never change.
+	ID          string
+	Description string
+	Table       string
+	// Migrate returns the attributes of an item to change, with their new
+	// values, or nil to leave the item as it is. A run resuming from a
+	// checkpoint may pass it items it has already changed, so it has to
+	// leave those alone.
+	Migrate func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error)
+}
+
+// migrations are applied in order, each once. New migrations go at the end.
+var migrations = []*migration{
+	{
+		ID:          "0001-issues-created-rfc3339",
+		Description: "store the creation time of issues as RFC 3339 in UTC",
+		Table:       "issues",
+		Migrate:     normalizeTimes("Created"),
+	},
+	{
+		ID:          "0002-users-dates-rfc3339",
+		Description: "store the join and last login times of users as RFC 3339 in UTC",
+		Table:       "users",
+		Migrate:     normalizeTimes("JoinedDate", "LastLogin"),
+	},
+	{
+		ID:          "0003-issuesupport-created-rfc3339",
+		Description: "store the time issues were supported as RFC 3339 in UTC",
+		Table:       "issuesupport",
+		Migrate:     normalizeTimes("Created"),
+	},
+	{
+		ID:          "0004-subscriptions-created-rfc3339",
+		Description: "store the time users subscribed to issues as RFC 3339 in UTC",
+		Table:       "subscriptions",
+		Migrate:     normalizeTimes("Created"),
+	},
+	{
+		ID:          "0005-issues-helpers-backfill",
+		Description: "give issues nobody offered help on an empty Helpers map",
+		Table:       "issues",
+		Migrate:     backfillHelpers,
+	},
+	{
+		ID:          "0006-posts-times-rfc3339",
+		Description: "store the post and edit times of posts as RFC 3339 in UTC",
+		Table:       "posts",
+		Migrate:     normalizeTimes("PostTime", "Edited"),
+	},
+	{
+		ID:          "0007-posts-feedkey-backfill",
+		Description: "give posts stored before the FeedIndex their FeedKey",
+		Table:       "posts",
+		Migrate:     backfillFeedKey,
+	},
+}
+
+// feedKey is the FeedKey the users function stores on every post, the
+// partition key of FeedIndex.
+const feedKey = "post"
+
+// normalizeTimes rewrites the given time attributes in shared.TimeLayout.
+// A time that cannot be parsed stops the migration, to be fixed by hand.
+func normalizeTimes(attributes ...string) func(map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	return func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+		var changes map[string]*dynamodb.AttributeValue
+		for _, attribute := range attributes {
+			value, ok := item[attribute]
+			if !ok || value.S == nil || *value.S == "" {
+				continue
+			}
+			t, err := shared.ParseTime(*value.S)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a time: %s", attribute, err)
+			}
+			if normalized := shared.FormatTime(t); normalized != *value.S {
+				if changes == nil {
+					changes = map[string]*dynamodb.AttributeValue{}
+				}
+				changes[attribute] = &dynamodb.AttributeValue{S: aws.String(normalized)}
+			}
+		}
+		return changes, nil
+	}
+}
+
+// backfillHelpers sets Helpers to an empty map where it is missing or null,
+// as PutIssue does not store it, so that every issue has the map AddHelper
+// adds to.
+func backfillHelpers(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	if helpers, ok := item["Helpers"]; ok && !aws.BoolValue(helpers.NULL) {
+		return nil, nil
+	}
+	return map[string]*dynamodb.AttributeValue{
+		"Helpers": {M: map[string]*dynamodb.AttributeValue{}},
+	}, nil
+}
+
+// backfillFeedKey sets FeedKey on posts stored before FeedIndex existed,
+// which are not in the feed without it.
+func backfillFeedKey(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	if key, ok := item["FeedKey"]; ok && aws.StringValue(key.S) == feedKey {
+		return nil, nil
+	}
+	return map[string]*dynamodb.AttributeValue{
+		"FeedKey": {S: aws.String(feedKey)},
+	}, nil
+}
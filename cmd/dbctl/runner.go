@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"shared"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	migrationsTable = "migrations"
+	statusRunning   = "running"
+	statusApplied   = "applied"
+	// dryRunExamples is how many changes a dry run prints per migration.
+	dryRunExamples = 5
+)
+
+// record is the item of a migration in the migrations table. Checkpoint is
+// the key of the last item of the last batch a running migration finished.
+type record struct {
+	ID          string
+	Description string
+	Status      string
+	Started     string
+	Applied     string
+	Checkpoint  map[string]*dynamodb.AttributeValue
+	Scanned     int
+	Changed     int
+	Skipped     int
+}
+
+func (r *record) item() map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"Id":          {S: aws.String(r.ID)},
+		"Description": {S: aws.String(r.Description)},
+		"Status":      {S: aws.String(r.Status)},
+		"Started":     {S: aws.String(r.Started)},
+		"Scanned":     {N: aws.String(strconv.Itoa(r.Scanned))},
+		"Changed":     {N: aws.String(strconv.Itoa(r.Changed))},
+		"Skipped":     {N: aws.String(strconv.Itoa(r.Skipped))},
+	}
+	if r.Applied != "" {
+		item["Applied"] = &dynamodb.AttributeValue{S: aws.String(r.Applied)}
+	}
+	if len(r.Checkpoint) > 0 {
+		item["Checkpoint"] = &dynamodb.AttributeValue{M: r.Checkpoint}
+	}
+	return item
+}
+
+func number(value *dynamodb.AttributeValue) int {
+	if value == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(aws.StringValue(value.N))
+	return n
+}
+
+// getRecord returns the record of a migration, nil if it never ran. A
+// missing migrations table, as before the first migrate, counts as no
+// records.
+func getRecord(db dynamodbiface.DynamoDBAPI, id string) (*record, error) {
+	output, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(tableName(migrationsTable)),
+		Key:            map[string]*dynamodb.AttributeValue{"Id": {S: aws.String(id)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+	item := output.Item
+	r := &record{
+		ID:      id,
+		Scanned: number(item["Scanned"]),
+		Changed: number(item["Changed"]),
+		Skipped: number(item["Skipped"]),
+	}
+	for name, field := range map[string]*string{"Description": &r.Description, "Status": &r.Status, "Started": &r.Started, "Applied": &r.Applied} {
+		if value, ok := item[name]; ok {
+			*field = aws.StringValue(value.S)
+		}
+	}
+	if checkpoint, ok := item["Checkpoint"]; ok {
+		r.Checkpoint = checkpoint.M
+	}
+	return r, nil
+}
+
+func putRecord(db dynamodbiface.DynamoDBAPI, r *record) error {
+	_, err := db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName(migrationsTable)),
+		Item:      r.item(),
+	})
+	return err
+}
+
+func sortedNames(changes map[string]*dynamodb.AttributeValue) []string {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyChanges updates the changed attributes of an item, provided they
+// still have the values the migration saw. It reports false if the item was
+// changed in between, in which case it is left alone.
+func applyChanges(db dynamodbiface.DynamoDBAPI, t *table, item map[string]*dynamodb.AttributeValue, changes map[string]*dynamodb.AttributeValue) (bool, error) {
+	key := map[string]*dynamodb.AttributeValue{t.Hash: item[t.Hash]}
+	if t.Range != "" {
+		key[t.Range] = item[t.Range]
+	}
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	var sets, conditions []string
+	for i, name := range sortedNames(changes) {
+		if _, ok := key[name]; ok {
+			return false, fmt.Errorf("migrations cannot change the key attribute %s", name)
+		}
+		placeholder := "#a" + strconv.Itoa(i)
+		names[placeholder] = aws.String(name)
+		values[":new"+strconv.Itoa(i)] = changes[name]
+		sets = append(sets, placeholder+" = :new"+strconv.Itoa(i))
+		old, ok := item[name]
+		switch {
+		case !ok:
+			conditions = append(conditions, "attribute_not_exists("+placeholder+")")
+		case aws.BoolValue(old.NULL):
+			values[":old"+strconv.Itoa(i)] = &dynamodb.AttributeValue{S: aws.String("NULL")}
+			conditions = append(conditions, "attribute_type("+placeholder+", :old"+strconv.Itoa(i)+")")
+		default:
+			values[":old"+strconv.Itoa(i)] = old
+			conditions = append(conditions, placeholder+" = :old"+strconv.Itoa(i))
+		}
+	}
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName(t.Name)),
+		Key:                       key,
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// formatValue formats an attribute value on one line.
+func formatValue(value *dynamodb.AttributeValue) string {
+	switch {
+	case value == nil:
+		return "missing"
+	case value.S != nil:
+		return strconv.Quote(*value.S)
+	case value.N != nil:
+		return *value.N
+	case aws.BoolValue(value.NULL):
+		return "null"
+	}
+	return strings.Join(strings.Fields(value.String()), " ")
+}
+
+func describeChanges(item map[string]*dynamodb.AttributeValue, t *table, changes map[string]*dynamodb.AttributeValue) string {
+	var described []string
+	for _, name := range sortedNames(changes) {
+		described = append(described, fmt.Sprintf("%s %s -> %s", name, formatValue(item[name]), formatValue(changes[name])))
+	}
+	return itemKey(item, t) + ": " + strings.Join(described, ", ")
+}
+
+func itemKey(item map[string]*dynamodb.AttributeValue, t *table) string {
+	key := aws.StringValue(item[t.Hash].S)
+	if t.Range != "" {
+		key += "/" + aws.StringValue(item[t.Range].S)
+	}
+	return key
+}
+
+// runMigrations applies the migrations that have not been applied yet. Each
+// scans its table in batches of batchSize items and records a checkpoint
+// after every batch, so an interrupted migration resumes where it stopped.
+// A dry run only reports what would change and writes nothing.
+func runMigrations(db dynamodbiface.DynamoDBAPI, batchSize int64, dryRun bool) error {
+	for _, m := range migrations {
+		r, err := getRecord(db, m.ID)
+		if err != nil {
+			return fmt.Errorf("could not read the record of %s: %s", m.ID, err)
+		}
+		if r != nil && r.Status == statusApplied {
+			continue
+		}
+		if r == nil {
+			r = &record{ID: m.ID, Description: m.Description, Status: statusRunning, Started: shared.FormatTime(time.Now())}
+		}
+		if err := runMigration(db, m, r, batchSize, dryRun); err != nil {
+			return fmt.Errorf("%s failed: %s", m.ID, err)
+		}
+	}
+	return nil
+}
+
+func runMigration(db dynamodbiface.DynamoDBAPI, m *migration, r *record, batchSize int64, dryRun bool) error {
+	t := findTable(m.Table)
+	if t == nil {
+		return fmt.Errorf("there is no table %s", m.Table)
+	}
+	if dryRun {
+		fmt.Printf("%s (dry run): %s\n", m.ID, m.Description)
+	} else {
+		fmt.Printf("%s: %s\n", m.ID, m.Description)
+		if err := putRecord(db, r); err != nil {
+			return err
+		}
+	}
+	if len(r.Checkpoint) > 0 {
+		fmt.Printf("  resuming after %d items\n", r.Scanned)
+	}
+	scanned, changed, skipped := 0, 0, 0
+	start := r.Checkpoint
+	for {
+		output, err := db.Scan(&dynamodb.ScanInput{
+			TableName:         aws.String(tableName(t.Name)),
+			Limit:             aws.Int64(batchSize),
+			ExclusiveStartKey: start,
+			ConsistentRead:    aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range output.Items {
+			scanned++
+			changes, err := m.Migrate(item)
+			if err != nil {
+				return fmt.Errorf("%s of %s: %s", itemKey(item, t), tableName(t.Name), err)
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			if dryRun {
+				if changed < dryRunExamples {
+					fmt.Printf("  would change %s\n", describeChanges(item, t, changes))
+				}
+				changed++
+				continue
+			}
+			applied, err := applyChanges(db, t, item, changes)
+			if err != nil {
+				return fmt.Errorf("%s of %s: %s", itemKey(item, t), tableName(t.Name), err)
+			}
+			if applied {
+				changed++
+			} else {
+				fmt.Printf("  skipped %s of %s, which changed while migrating\n", itemKey(item, t), tableName(t.Name))
+				skipped++
+			}
+		}
+		start = output.LastEvaluatedKey
+		if dryRun {
+			if len(start) == 0 {
+				fmt.Printf("%s (dry run): would change %d of %d items\n", m.ID, changed, scanned)
+				return nil
+			}
+			continue
+		}
+
+		r.Scanned += len(output.Items)
+		r.Changed += changed
+		r.Skipped += skipped
+		scanned, changed, skipped = 0, 0, 0
+		r.Checkpoint = start
+		if len(start) == 0 {
+			r.Status = statusApplied
+			r.Applied = shared.FormatTime(time.Now())
+		}
+		if err := putRecord(db, r); err != nil {
+			return err
+		}
+		if r.Status == statusApplied {
+			fmt.Printf("%s: changed %d of %d items, skipped %d\n", m.ID, r.Changed, r.Scanned, r.Skipped)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"shared"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// capacityUnits are the read and write capacity units of every table and
+// index in template.yaml.
+const capacityUnits = 5
+
+// index is a global secondary index of a table.
+type index struct {
+	Name       string
+	Hash       string
+	Range      string
+	Projection string
+}
+
+// table is a DynamoDB table the functions use. All key attributes are strings.
+type table struct {
+	// Resource is the logical ID of the table in template.yaml.
+	Resource string
+	Name     string
+	Hash     string
+	Range    string
+	Indexes  []index
+	// Stream is the StreamViewType of the table stream, empty without one.
+	Stream string
+	// TTL is the attribute items expire by, empty without one.
+	TTL string
+}
+
+// tables mirrors the tables of template.yaml; TestTablesMatchTemplate keeps
+// them in sync.
+var tables = []*table{
+	{Resource: "IssuesTable", Name: "issues", Hash: "Id",
+		Indexes: []index{
+			{"GeoIndex", "GeoKey", "GeoHash", dynamodb.ProjectionTypeAll},
+		},
+		Stream: dynamodb.StreamViewTypeNewAndOldImages},
+	{Resource: "UsersTable", Name: "users", Hash: "Id"},
+	{Resource: "PostsTable", Name: "posts", Hash: "Id",
+		Indexes: []index{
+			{"UserPostsIndex", "UserId", "PostTime", dynamodb.ProjectionTypeAll},
+			{"FeedIndex", "FeedKey", "PostTime", dynamodb.ProjectionTypeAll},
+			{"IssueStoriesIndex", "IssueId", "PostTime", dynamodb.ProjectionTypeAll},
+		},
+		Stream: dynamodb.StreamViewTypeNewAndOldImages},
+	{Resource: "PostLikesTable", Name: "postlikes", Hash: "PostId", Range: "UserId",
+		Stream: dynamodb.StreamViewTypeNewAndOldImages},
+	{Resource: "PostCommentsTable", Name: "postcomments", Hash: "PostId", Range: "CommentId",
+		Stream: dynamodb.StreamViewTypeNewAndOldImages},
+	{Resource: "IssueSupportTable", Name: "issuesupport", Hash: "IssueId", Range: "UserId"},
+	{Resource: "SubscriptionsTable", Name: "subscriptions", Hash: "UserId", Range: "IssueId",
+		Indexes: []index{
+			{"IssueSubscribersIndex", "IssueId", "UserId", dynamodb.ProjectionTypeKeysOnly},
+		}},
+	{Resource: "NotificationsTable", Name: "notifications", Hash: "UserId", Range: "NotificationId",
+		TTL: "ExpiresAt"},
+	{Resource: "ProcessedEventsTable", Name: "processedevents", Hash: "EventKey",
+		TTL: "ExpiresAt"},
+	{Resource: "ConnectionsTable", Name: "connections", Hash: "ConnectionId", Range: "Topic",
+		Indexes: []index{
+			{"TopicIndex", "Topic", "ConnectionId", dynamodb.ProjectionTypeKeysOnly},
+		},
+		TTL: "ExpiresAt"},
+	{Resource: "WebhooksTable", Name: "webhooks", Hash: "Id"},
+	{Resource: "WebhookDeliveriesTable", Name: "webhookdeliveries", Hash: "WebhookId", Range: "DeliveryId",
+		Indexes: []index{
+			{"RetryIndex", "RetryKey", "NextAttempt", dynamodb.ProjectionTypeAll},
+		},
+		TTL: "ExpiresAt"},
+	{Resource: "SearchIndexTable", Name: "searchindex", Hash: "Prefix", Range: "TermKey"},
+	// The hello-world function reads TestTable, which template.yaml does not
+	// define, so it only exists where dbctl created it.
+	{Name: "TestTable", Hash: "Id"},
+	// migrations records the data migrations dbctl applied. No function
+	// uses it.
+	{Name: migrationsTable, Hash: "Id"},
+}
+
+// stage is the stage whose tables dbctl works on. Its tables are named
+// as shared.StageTableName names them.
+var stage string
+
+// tableName is the name of a table in the stage.
+func tableName(name string) string {
+	return shared.StageTableName(stage, name)
+}
+
+// findTable returns the table of the given name, nil if there is none.
+func findTable(name string) *table {
+	for _, t := range tables {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func keySchema(hash string, rangeKey string) []*dynamodb.KeySchemaElement {
+	keys := []*dynamodb.KeySchemaElement{
+		{AttributeName: aws.String(hash), KeyType: aws.String(dynamodb.KeyTypeHash)},
+	}
+	if rangeKey != "" {
+		keys = append(keys, &dynamodb.KeySchemaElement{AttributeName: aws.String(rangeKey), KeyType: aws.String(dynamodb.KeyTypeRange)})
+	}
+	return keys
+}
+
+func throughput() *dynamodb.ProvisionedThroughput {
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(capacityUnits),
+		WriteCapacityUnits: aws.Int64(capacityUnits),
+	}
+}
+
+// attributes are the key attributes of the table and its indexes, each
+// once.
+func (t *table) attributes() []string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(t.Hash)
+	add(t.Range)
+	for _, i := range t.Indexes {
+		add(i.Hash)
+		add(i.Range)
+	}
+	return names
+}
+
+func (t *table) attributeDefinitions() []*dynamodb.AttributeDefinition {
+	var definitions []*dynamodb.AttributeDefinition
+	for _, name := range t.attributes() {
+		definitions = append(definitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+	}
+	return definitions
+}
+
+func (i index) createAction() *dynamodb.CreateGlobalSecondaryIndexAction {
+	return &dynamodb.CreateGlobalSecondaryIndexAction{
+		IndexName:             aws.String(i.Name),
+		KeySchema:             keySchema(i.Hash, i.Range),
+		Projection:            &dynamodb.Projection{ProjectionType: aws.String(i.Projection)},
+		ProvisionedThroughput: throughput(),
+	}
+}
+
+// createInput is the CreateTable request of the table. The TTL is enabled
+// separately once the table exists.
+func (t *table) createInput() *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		TableName:             aws.String(tableName(t.Name)),
+		AttributeDefinitions:  t.attributeDefinitions(),
+		KeySchema:             keySchema(t.Hash, t.Range),
+		ProvisionedThroughput: throughput(),
+	}
+	for _, i := range t.Indexes {
+		action := i.createAction()
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName:             action.IndexName,
+			KeySchema:             action.KeySchema,
+			Projection:            action.Projection,
+			ProvisionedThroughput: action.ProvisionedThroughput,
+		})
+	}
+	if t.Stream != "" {
+		input.StreamSpecification = &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(t.Stream),
+		}
+	}
+	return input
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// batchSize is the most items BatchWriteItem takes.
+	batchSize = 25
+	// writeAttempts bounds the retries of items DynamoDB left unprocessed.
+	writeAttempts = 5
+)
+
+// fixture is the items seeded into a table.
+type fixture struct {
+	Table string
+	Items []map[string]*dynamodb.AttributeValue
+}
+
+// loadFixtures reads the fixtures of dir. Each <table>.json holds a list of
+// items in DynamoDB JSON, as the AWS CLI prints them.
+func loadFixtures(dir string) ([]*fixture, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no fixtures in %s", dir)
+	}
+	var fixtures []*fixture
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		if findTable(name) == nil {
+			return nil, fmt.Errorf("%s: there is no table %s", file, name)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		f := &fixture{Table: name}
+		if err := json.Unmarshal(data, &f.Items); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+// seedTables puts the items of the fixtures, replacing items with the same
+// keys, so seeding twice leaves the same data.
+func seedTables(db dynamodbiface.DynamoDBAPI, fixtures []*fixture) error {
+	for _, f := range fixtures {
+		for start := 0; start < len(f.Items); start += batchSize {
+			end := start + batchSize
+			if end > len(f.Items) {
+				end = len(f.Items)
+			}
+			if err := writeBatch(db, tableName(f.Table), f.Items[start:end]); err != nil {
+				return fmt.Errorf("could not seed %s: %s", tableName(f.Table), err)
+			}
+		}
+		fmt.Printf("Seeded %d items into %s\n", len(f.Items), tableName(f.Table))
+	}
+	return nil
+}
+
+func writeBatch(db dynamodbiface.DynamoDBAPI, table string, items []map[string]*dynamodb.AttributeValue) error {
+	requests := make([]*dynamodb.WriteRequest, 0, len(items))
+	for _, item := range items {
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	pending := map[string][]*dynamodb.WriteRequest{table: requests}
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		output, err := db.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+		if err != nil {
+			return err
+		}
+		pending = output.UnprocessedItems
+		if len(pending) == 0 {
+			return nil
+		}
+		if attempt == writeAttempts {
+			return fmt.Errorf("%d items still unprocessed after %d attempts", len(pending[table]), attempt)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	indexPollInterval = 2 * time.Second
+	indexTimeout      = 10 * time.Minute
+)
+
+// describe returns the description of a table, nil if it does not exist.
+func describe(db dynamodbiface.DynamoDBAPI, name string) (*dynamodb.TableDescription, error) {
+	output, err := db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(name)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output.Table, nil
+}
+
+func waitUntilActive(db dynamodbiface.DynamoDBAPI, name string) error {
+	return db.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(name)})
+}
+
+// waitForIndex waits until DynamoDB has built an index added to a table.
+func waitForIndex(db dynamodbiface.DynamoDBAPI, tableName string, indexName string) error {
+	for deadline := time.Now().Add(indexTimeout); time.Now().Before(deadline); time.Sleep(indexPollInterval) {
+		described, err := describe(db, tableName)
+		if err != nil {
+			return err
+		}
+		if described == nil {
+			return fmt.Errorf("%s is gone", tableName)
+		}
+		for _, i := range described.GlobalSecondaryIndexes {
+			if aws.StringValue(i.IndexName) == indexName && aws.StringValue(i.IndexStatus) == dynamodb.IndexStatusActive {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s of %s is not active after %s", indexName, tableName, indexTimeout)
+}
+
+func createTable(db dynamodbiface.DynamoDBAPI, t *table) error {
+	if _, err := db.CreateTable(t.createInput()); err != nil {
+		return err
+	}
+	if err := waitUntilActive(db, tableName(t.Name)); err != nil {
+		return err
+	}
+	return enableTTL(db, t)
+}
+
+// enableTTL turns on the TTL of the table if the schema has one.
+func enableTTL(db dynamodbiface.DynamoDBAPI, t *table) error {
+	if t.TTL == "" {
+		return nil
+	}
+	output, err := db.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName(t.Name))})
+	if err != nil {
+		return err
+	}
+	if ttl := output.TimeToLiveDescription; ttl != nil {
+		switch aws.StringValue(ttl.TimeToLiveStatus) {
+		case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+			if attribute := aws.StringValue(ttl.AttributeName); attribute != t.TTL {
+				return fmt.Errorf("items of %s expire by %s, not %s", tableName(t.Name), attribute, t.TTL)
+			}
+			return nil
+		}
+	}
+	_, err = db.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName(t.Name)),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(t.TTL),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Enabled the TTL of %s on %s\n", tableName(t.Name), t.TTL)
+	return nil
+}
+
+// createTables creates the tables that do not exist yet and leaves the
+// others as they are.
+func createTables(db dynamodbiface.DynamoDBAPI) error {
+	for _, t := range tables {
+		described, err := describe(db, tableName(t.Name))
+		if err != nil {
+			return err
+		}
+		if described != nil {
+			fmt.Printf("%s exists\n", tableName(t.Name))
+			continue
+		}
+		if err := createTable(db, t); err != nil {
+			return fmt.Errorf("could not create %s: %s", tableName(t.Name), err)
+		}
+		fmt.Printf("Created %s\n", tableName(t.Name))
+	}
+	return nil
+}
+
+// migrateTables creates the tables that do not exist yet and brings the
+// others up to the schema.
+func migrateTables(db dynamodbiface.DynamoDBAPI) error {
+	for _, t := range tables {
+		described, err := describe(db, tableName(t.Name))
+		if err != nil {
+			return err
+		}
+		if described == nil {
+			if err := createTable(db, t); err != nil {
+				return fmt.Errorf("could not create %s: %s", tableName(t.Name), err)
+			}
+			fmt.Printf("Created %s\n", tableName(t.Name))
+			continue
+		}
+		if err := updateTable(db, t, described); err != nil {
+			return fmt.Errorf("could not migrate %s: %s", tableName(t.Name), err)
+		}
+	}
+	return nil
+}
+
+func sameKeys(keys []*dynamodb.KeySchemaElement, hash string, rangeKey string) bool {
+	want := keySchema(hash, rangeKey)
+	if len(keys) != len(want) {
+		return false
+	}
+	for i, key := range keys {
+		if aws.StringValue(key.AttributeName) != aws.StringValue(want[i].AttributeName) ||
+			aws.StringValue(key.KeyType) != aws.StringValue(want[i].KeyType) {
+			return false
+		}
+	}
+	return true
+}
+
+// updateTable adds the missing indexes, stream and TTL of an existing table.
+// Keys cannot be changed in place and indexes the schema does not know are
+// only reported, as dropping them could break a function still using them.
+func updateTable(db dynamodbiface.DynamoDBAPI, t *table, described *dynamodb.TableDescription) error {
+	if !sameKeys(described.KeySchema, t.Hash, t.Range) {
+		return fmt.Errorf("its key differs from the schema, it has to be dropped and created again")
+	}
+	existing := map[string]bool{}
+	for _, i := range described.GlobalSecondaryIndexes {
+		existing[aws.StringValue(i.IndexName)] = true
+	}
+	known := map[string]bool{}
+	for _, i := range t.Indexes {
+		known[i.Name] = true
+		if existing[i.Name] {
+			continue
+		}
+		// DynamoDB builds one new index of a table at a time.
+		_, err := db.UpdateTable(&dynamodb.UpdateTableInput{
+			TableName:            aws.String(tableName(t.Name)),
+			AttributeDefinitions: t.attributeDefinitions(),
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+				{Create: i.createAction()},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := waitForIndex(db, tableName(t.Name), i.Name); err != nil {
+			return err
+		}
+		fmt.Printf("Added %s to %s\n", i.Name, tableName(t.Name))
+	}
+	for name := range existing {
+		if !known[name] {
+			fmt.Printf("%s has the index %s, which is not in the schema\n", tableName(t.Name), name)
+		}
+	}
+
+	if t.Stream != "" {
+		stream := described.StreamSpecification
+		if stream == nil || !aws.BoolValue(stream.StreamEnabled) {
+			_, err := db.UpdateTable(&dynamodb.UpdateTableInput{
+				TableName: aws.String(tableName(t.Name)),
+				StreamSpecification: &dynamodb.StreamSpecification{
+					StreamEnabled:  aws.Bool(true),
+					StreamViewType: aws.String(t.Stream),
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if err := waitUntilActive(db, tableName(t.Name)); err != nil {
+				return err
+			}
+			fmt.Printf("Enabled the stream of %s\n", tableName(t.Name))
+		} else if view := aws.StringValue(stream.StreamViewType); view != t.Stream {
+			return fmt.Errorf("its stream has the view type %s, not %s", view, t.Stream)
+		}
+	}
+	return enableTTL(db, t)
+}
+
+// dropTables deletes the tables that exist.
+func dropTables(db dynamodbiface.DynamoDBAPI) error {
+	for _, t := range tables {
+		described, err := describe(db, tableName(t.Name))
+		if err != nil {
+			return err
+		}
+		if described == nil {
+			continue
+		}
+		if _, err := db.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(tableName(t.Name))}); err != nil {
+			return fmt.Errorf("could not drop %s: %s", tableName(t.Name), err)
+		}
+		if err := db.WaitUntilTableNotExists(&dynamodb.DescribeTableInput{TableName: aws.String(tableName(t.Name))}); err != nil {
+			return err
+		}
+		fmt.Printf("Dropped %s\n", tableName(t.Name))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+)
+
+const (
+	// invokeTimeout is the Timeout of the functions in template.yaml.
+	invokeTimeout = 5 * time.Second
+	startTimeout  = 10 * time.Second
+)
+
+// process is a function binary serving the Lambda Go runtime protocol, as
+// the go1.x runtime runs it. Like a Lambda instance, it handles one
+// invocation at a time, which the request fields of shared.Log rely on.
+type process struct {
+	cmd    *exec.Cmd
+	client *rpc.Client
+	mu     sync.Mutex
+}
+
+// build compiles the function in dir of the repository at root into binDir.
+func build(root string, fn *function, binDir string) (string, error) {
+	binary := filepath.Join(binDir, fn.Dir)
+	cmd := exec.Command("go", "build", "-o", binary, ".")
+	cmd.Dir = filepath.Join(root, fn.Dir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%s\n%s", err, output)
+	}
+	return binary, nil
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// start runs a function binary with the given environment on top of ours and
+// waits until it accepts invocations.
+func start(binary string, env []string) (*process, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(binary)
+	cmd.Env = append(append(os.Environ(), env...), "_LAMBDA_SERVER_PORT="+strconv.Itoa(port))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	address := "localhost:" + strconv.Itoa(port)
+	for deadline := time.Now().Add(startTimeout); ; {
+		client, err := rpc.Dial("tcp", address)
+		if err == nil {
+			return &process{cmd: cmd, client: client}, nil
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("%s did not start listening on %s: %s", binary, address, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Invoke sends a request to the function. Errors returned or panics raised by
+// the handler are reported as errors.
+func (p *process) Invoke(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var response events.APIGatewayProxyResponse
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+	deadline := time.Now().Add(invokeTimeout)
+	invokeRequest := &messages.InvokeRequest{
+		Payload:   payload,
+		RequestId: request.RequestContext.RequestID,
+		Deadline: messages.InvokeRequest_Timestamp{
+			Seconds: deadline.Unix(),
+			Nanos:   int64(deadline.Nanosecond()),
+		},
+	}
+	invokeResponse := new(messages.InvokeResponse)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.client.Call("Function.Invoke", invokeRequest, invokeResponse); err != nil {
+		return response, err
+	}
+	if invokeResponse.Error != nil {
+		return response, errors.New(invokeResponse.Error.Message)
+	}
+	err = json.Unmarshal(invokeResponse.Payload, &response)
+	return response, err
+}
+
+func (p *process) Stop() {
+	p.client.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
@@ -0,0 +1,5 @@
+require github.com/aws/aws-lambda-go v1.13.3
+
+module devserver
+
+go 1.14
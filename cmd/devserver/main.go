@@ -0,0 +1,130 @@
+// Command devserver serves the REST API of template.yaml on one address
+// without sam local or Docker. It builds the functions, runs them the way the
+// go1.x Lambda runtime does and invokes them with the API Gateway event of
+// each request.
+//
+// By default the functions keep their data in memory, each on its own. With
+// -dynamodb they use the DynamoDB endpoint instead, e.g. DynamoDB Local.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// invoker runs a function on an API Gateway event.
+type invoker interface {
+	Invoke(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+}
+
+// server routes requests to the functions by the routes of template.yaml.
+type server struct {
+	invokers map[string]invoker
+	// stage is the API Gateway stage requests are made to.
+	stage string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn, resource, params := findRoute(r.Method, r.URL.Path)
+	if fn == nil {
+		// What API Gateway answers for paths without a route.
+		writeGatewayError(w, http.StatusForbidden, "Missing Authentication Token")
+		return
+	}
+	invoker, ok := s.invokers[fn.Name]
+	if !ok {
+		fmt.Printf("%s %s: %s is not running\n", r.Method, r.URL.Path, fn.Name)
+		writeGatewayError(w, http.StatusBadGateway, "Internal server error")
+		return
+	}
+	request, err := toProxyRequest(r, s.stage, resource, params)
+	if err != nil {
+		writeGatewayError(w, http.StatusBadRequest, "Could not read the request body")
+		return
+	}
+	response, err := invoker.Invoke(request)
+	if err != nil {
+		fmt.Printf("%s %s: %s failed %s\n", r.Method, r.URL.Path, fn.Name, err)
+		writeGatewayError(w, http.StatusBadGateway, "Internal server error")
+		return
+	}
+	fmt.Printf("%s %s -> %s %d\n", r.Method, r.URL.Path, fn.Name, response.StatusCode)
+	writeProxyResponse(w, response)
+}
+
+// functionEnv is the environment the functions run with on top of ours. The
+// stage names the tables they use in DynamoDB, as it does when deployed.
+func functionEnv(dbEndpoint string, stage string) []string {
+	if dbEndpoint == "" {
+		return []string{"AWSENV=MEMORY", "STAGE=" + stage}
+	}
+	return []string{"AWSENV=AWS_SAM_LOCAL", "DBENDPOINT=" + dbEndpoint, "STAGE=" + stage}
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:3000", "address to serve the API on")
+	root := flag.String("root", "../..", "directory of template.yaml and the functions")
+	dbEndpoint := flag.String("dynamodb", "", "DynamoDB endpoint to use instead of keeping data in memory, e.g. http://localhost:8000")
+	stage := flag.String("stage", os.Getenv("STAGE"), "stage to serve, such as dev, staging or prod (default dev)")
+	flag.Parse()
+	if *stage == "" {
+		*stage = defaultStage
+	}
+
+	binDir, err := ioutil.TempDir("", "devserver")
+	if err != nil {
+		fmt.Printf("Failed to create a directory for the binaries %s\n", err)
+		os.Exit(1)
+	}
+
+	s := &server{invokers: map[string]invoker{}, stage: *stage}
+	processes := make([]*process, 0, len(functions))
+	stop := func() {
+		for _, p := range processes {
+			p.Stop()
+		}
+	}
+	for _, fn := range functions {
+		binary, err := build(*root, fn, binDir)
+		if err != nil {
+			// One broken function should not keep the others from being served.
+			fmt.Printf("Failed to build %s, its routes answer 502: %s\n", fn.Name, err)
+			continue
+		}
+		p, err := start(binary, functionEnv(*dbEndpoint, *stage))
+		if err != nil {
+			fmt.Printf("Failed to start %s, its routes answer 502: %s\n", fn.Name, err)
+			continue
+		}
+		processes = append(processes, p)
+		s.invokers[fn.Name] = p
+		for _, r := range fn.Routes {
+			fmt.Printf("%-4s http://%s%s -> %s\n", r.Method, *addr, r.Path, fn.Name)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stop()
+		os.RemoveAll(binDir)
+		os.Exit(0)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", s)
+	fmt.Printf("Serving the API on http://%s\n", *addr)
+	err = http.ListenAndServe(*addr, mux)
+	stop()
+	os.RemoveAll(binDir)
+	fmt.Printf("Failed to serve %s\n", err)
+	os.Exit(1)
+}
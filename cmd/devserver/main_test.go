@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// templateRoutes reads the Api events of the functions in template.yaml.
+func templateRoutes(t *testing.T) map[string][]route {
+	t.Helper()
+	file, err := os.Open("../../template.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	routes := map[string][]route{}
+	current := ""
+	path := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case line == "Outputs:":
+			return routes
+		case strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   ") && strings.HasSuffix(trimmed, ":"):
+			current = strings.TrimSuffix(trimmed, ":")
+		case strings.HasPrefix(trimmed, "Path: "):
+			path = strings.TrimPrefix(trimmed, "Path: ")
+		case strings.HasPrefix(trimmed, "Method: ") && path != "":
+			routes[current] = append(routes[current], route{strings.TrimPrefix(trimmed, "Method: "), path})
+			path = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return routes
+}
+
+func sortedRoutes(routes []route) []route {
+	sorted := append([]route(nil), routes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	return sorted
+}
+
+func TestFunctionsMatchTemplate(t *testing.T) {
+	template := templateRoutes(t)
+	for _, fn := range functions {
+		if got, want := sortedRoutes(fn.Routes), sortedRoutes(template[fn.Name]); !reflect.DeepEqual(got, want) {
+			t.Errorf("routes of %s = %v, template.yaml has %v", fn.Name, got, want)
+		}
+		if _, err := os.Stat("../../" + fn.Dir + "/main.go"); err != nil {
+			t.Errorf("%s: %s", fn.Name, err)
+		}
+	}
+}
+
+func TestFindRoute(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		function string
+		resource string
+		params   map[string]string
+	}{
+		{"GET", "/issues", "IssuesFunction", "/issues", nil},
+		{"GET", "/issues/nearby", "IssuesFunction", "/issues/nearby", nil},
+		{"OPTIONS", "/issues/nearby", "IssuesFunction", "/issues/nearby", nil},
+		{"DELETE", "/issues/nearby", "IssuesFunction", "/issues/nearby", nil},
+		{"PUT", "/issues/issue-1/comment", "IssuesFunction", "/issues/{issueId}/{field}", map[string]string{"issueId": "issue-1", "field": "comment"}},
+		{"GET", "/users/user-1/", "UsersFunction", "/users/{userId}", map[string]string{"userId": "user-1"}},
+		{"POST", "/users/user-1/notifications/read", "UsersFunction", "/users/{userId}/notifications/read", map[string]string{"userId": "user-1"}},
+		{"GET", "/posts/user-1", "UsersFunction", "/posts/{userId}", map[string]string{"userId": "user-1"}},
+		{"PUT", "/posts/item/post-1/like", "UsersFunction", "/posts/item/{postId}/like", map[string]string{"postId": "post-1"}},
+		{"POST", "/userlogin", "UserloginFunction", "/userlogin", nil},
+		{"GET", "/hello", "HelloWorldFunction", "/hello", nil},
+		{"GET", "/", "", "", nil},
+		{"GET", "/issues/issue-1/comment/extra", "", "", nil},
+	}
+	for _, test := range tests {
+		fn, resource, params := findRoute(test.method, test.path)
+		name := ""
+		if fn != nil {
+			name = fn.Name
+		}
+		if name != test.function || resource.Path != test.resource || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("findRoute(%s %s) = %s %s %v, want %s %s %v", test.method, test.path,
+				name, resource.Path, params, test.function, test.resource, test.params)
+		}
+	}
+}
+
+type stubInvoker struct {
+	request  events.APIGatewayProxyRequest
+	response events.APIGatewayProxyResponse
+	err      error
+}
+
+func (s *stubInvoker) Invoke(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	s.request = request
+	return s.response, s.err
+}
+
+func TestServeHTTP(t *testing.T) {
+	stub := &stubInvoker{response: events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       `{"id":"issue-1"}`,
+	}}
+	s := &server{invokers: map[string]invoker{"IssuesFunction": stub}, stage: "staging"}
+
+	request := httptest.NewRequest("PUT", "/issues/issue-1/support?userid=user-1&userid=user-2", strings.NewReader(`{"userid":"user-1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, request)
+
+	got := stub.request
+	if got.HTTPMethod != "PUT" || got.Path != "/issues/issue-1/support" || got.Resource != "/issues/{issueId}/{field}" {
+		t.Errorf("request = %s %s %s", got.HTTPMethod, got.Path, got.Resource)
+	}
+	if want := map[string]string{"issueId": "issue-1", "field": "support"}; !reflect.DeepEqual(got.PathParameters, want) {
+		t.Errorf("path parameters = %v, want %v", got.PathParameters, want)
+	}
+	if got.QueryStringParameters["userid"] != "user-2" || len(got.MultiValueQueryStringParameters["userid"]) != 2 {
+		t.Errorf("query = %v %v", got.QueryStringParameters, got.MultiValueQueryStringParameters)
+	}
+	if got.Headers["Content-Type"] != "application/json" || got.Body != `{"userid":"user-1"}` || got.IsBase64Encoded {
+		t.Errorf("headers = %v, body = %q", got.Headers, got.Body)
+	}
+	if got.RequestContext.RequestID == "" || got.RequestContext.Stage != "staging" {
+		t.Errorf("request context = %+v", got.RequestContext)
+	}
+
+	if recorder.Code != http.StatusCreated || recorder.Header().Get("Content-Type") != "application/json" || recorder.Body.String() != `{"id":"issue-1"}` {
+		t.Errorf("response = %d %v %s", recorder.Code, recorder.Header(), recorder.Body)
+	}
+}
+
+func TestServeHTTPErrors(t *testing.T) {
+	s := &server{invokers: map[string]invoker{"IssuesFunction": &stubInvoker{err: errors.New("boom")}}}
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/nowhere", http.StatusForbidden},
+		{"GET", "/issues", http.StatusBadGateway},
+		// The users function is not running.
+		{"GET", "/posts", http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+		message := map[string]string{}
+		if recorder.Code != test.status || json.Unmarshal(recorder.Body.Bytes(), &message) != nil || message["message"] == "" {
+			t.Errorf("%s %s = %d %s, want %d", test.method, test.path, recorder.Code, recorder.Body, test.status)
+		}
+	}
+}
+
+// TestUserloginInMemory builds and runs the userlogin function like main does.
+func TestUserloginInMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a function")
+	}
+	binDir, err := ioutil.TempDir("", "devserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(binDir)
+	var userlogin *function
+	for _, fn := range functions {
+		if fn.Name == "UserloginFunction" {
+			userlogin = fn
+		}
+	}
+	binary, err := build("../..", userlogin, binDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := start(binary, functionEnv("", defaultStage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	api := httptest.NewServer(&server{invokers: map[string]invoker{userlogin.Name: p}, stage: defaultStage})
+	defer api.Close()
+
+	response, err := http.Post(api.URL+"/userlogin", "application/json", strings.NewReader(`{"name": "Asha", "email": "asha@example.org"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated || response.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("sign up = %d %v", response.StatusCode, response.Header)
+	}
+	response, err = http.Get(api.URL + "/userlogin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var users []map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&users); err != nil || len(users) != 1 || users[0]["email"] != "asha@example.org" {
+		t.Errorf("users = %v %v", users, err)
+	}
+}
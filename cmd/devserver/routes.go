@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// defaultStage is the stage served without -stage or STAGE.
+const defaultStage = "dev"
+
+// route is an API event of a function in template.yaml.
+type route struct {
+	Method string
+	Path   string
+}
+
+// function is a function of template.yaml with the API events that invoke it.
+type function struct {
+	Name   string
+	Dir    string
+	Routes []route
+}
+
+// functions mirrors the Api events of template.yaml; TestFunctionsMatchTemplate
+// keeps them in sync.
+var functions = []*function{
+	{Name: "HelloWorldFunction", Dir: "hello-world", Routes: []route{
+		{"ANY", "/hello"},
+	}},
+	{Name: "IssuesFunction", Dir: "issues", Routes: []route{
+		{"ANY", "/issues/{issueId}/{field}"},
+		{"ANY", "/issues/{issueId}"},
+		{"ANY", "/issues"},
+		{"ANY", "/issues/nearby"},
+	}},
+	{Name: "UsersFunction", Dir: "users", Routes: []route{
+		{"ANY", "/users/{userId}"},
+		{"ANY", "/userPosts/{userId}"},
+		{"ANY", "/posts/{userId}"},
+		{"ANY", "/posts"},
+		{"ANY", "/posts/item/{postId}"},
+		{"ANY", "/posts/item/{postId}/like"},
+		{"ANY", "/posts/item/{postId}/comments"},
+		{"ANY", "/users/{userId}/subscriptions"},
+		{"ANY", "/users/{userId}/notifications"},
+		{"ANY", "/users/{userId}/notifications/read"},
+		{"ANY", "/users/{userId}/emailpreferences"},
+		{"ANY", "/users/{userId}/digest"},
+	}},
+	{Name: "UserloginFunction", Dir: "userlogin", Routes: []route{
+		{"ANY", "/userlogin"},
+	}},
+}
+
+// match returns the path parameters of path if it matches the route, and the
+// number of literal segments that matched.
+func (r route) match(method string, path string) (map[string]string, int, bool) {
+	if r.Method != "ANY" && r.Method != method {
+		return nil, 0, false
+	}
+	want := strings.Split(strings.Trim(r.Path, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return nil, 0, false
+	}
+	var params map[string]string
+	literals := 0
+	for i, segment := range want {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if got[i] == "" {
+				return nil, 0, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[strings.Trim(segment, "{}")] = got[i]
+			continue
+		}
+		if segment != got[i] {
+			return nil, 0, false
+		}
+		literals++
+	}
+	return params, literals, true
+}
+
+// findRoute picks the function and route of a request. Like API Gateway, it
+// prefers literal segments to path parameters, so /issues/nearby is not taken
+// for an issue id.
+func findRoute(method string, path string) (*function, route, map[string]string) {
+	var found *function
+	var foundRoute route
+	var foundParams map[string]string
+	best := -1
+	for _, fn := range functions {
+		for _, r := range fn.Routes {
+			params, literals, ok := r.match(method, path)
+			if ok && literals > best {
+				found, foundRoute, foundParams, best = fn, r, params, literals
+			}
+		}
+	}
+	return found, foundRoute, foundParams
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// toProxyRequest translates an HTTP request into the event API Gateway sends
+// to the function of the route in a stage.
+func toProxyRequest(r *http.Request, stage string, resource route, params map[string]string) (events.APIGatewayProxyRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+	request := events.APIGatewayProxyRequest{
+		Resource:       resource.Path,
+		Path:           r.URL.Path,
+		HTTPMethod:     r.Method,
+		PathParameters: params,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:    newRequestID(),
+			Stage:        stage,
+			ResourcePath: resource.Path,
+			HTTPMethod:   r.Method,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP(r),
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}
+	// API Gateway sends null rather than empty maps.
+	if len(r.Header) > 0 {
+		request.Headers = map[string]string{}
+		request.MultiValueHeaders = map[string][]string{}
+		for name, values := range r.Header {
+			request.Headers[name] = values[len(values)-1]
+			request.MultiValueHeaders[name] = values
+		}
+	}
+	if query := r.URL.Query(); len(query) > 0 {
+		request.QueryStringParameters = map[string]string{}
+		request.MultiValueQueryStringParameters = map[string][]string{}
+		for name, values := range query {
+			request.QueryStringParameters[name] = values[len(values)-1]
+			request.MultiValueQueryStringParameters[name] = values
+		}
+	}
+	if utf8.Valid(body) {
+		request.Body = string(body)
+	} else {
+		request.Body = base64.StdEncoding.EncodeToString(body)
+		request.IsBase64Encoded = true
+	}
+	return request, nil
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeProxyResponse writes the response of a function the way API Gateway
+// does.
+func writeProxyResponse(w http.ResponseWriter, response events.APIGatewayProxyResponse) {
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			writeGatewayError(w, http.StatusBadGateway, "Internal server error")
+			return
+		}
+		body = decoded
+	}
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	if response.StatusCode < 100 || response.StatusCode > 599 {
+		writeGatewayError(w, http.StatusBadGateway, "Internal server error")
+		return
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write(body)
+}
+
+// writeGatewayError answers with one of API Gateway's own errors.
+func writeGatewayError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(`{"message":"` + message + `"}`))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"shared"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+var db *dynamodb.DynamoDB
+
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
+
+const statusResolved = "Resolved"
+
+func scanAll(table string, filt expression.ConditionBuilder, unmarshal func(map[string]*dynamodb.AttributeValue) error) error {
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		TableName:                 aws.String(table),
+	}
+	var unmarshalErr error
+	err = db.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			if unmarshalErr = unmarshal(i); unmarshalErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return unmarshalErr
+}
+
+// getDigestUsers returns the users who opted into digests of the given frequency.
+func getDigestUsers(frequency string) ([]*User, error) {
+	users := make([]*User, 0)
+	filt := expression.Name("DigestFrequency").Equal(expression.Value(frequency))
+	err := scanAll(tables.Users, filt, func(item map[string]*dynamodb.AttributeValue) error {
+		user := new(User)
+		if err := dynamodbattribute.UnmarshalMap(item, user); err != nil {
+			return err
+		}
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+// getOpenIssues returns every public issue that has not been resolved yet.
+func getOpenIssues() ([]*Issue, error) {
+	issues := make([]*Issue, 0)
+	filt := expression.Name("StatusMsg").NotEqual(expression.Value(statusResolved)).
+		And(expression.Name("Private").Equal(expression.Value(0)))
+	err := scanAll(tables.Issues, filt, func(item map[string]*dynamodb.AttributeValue) error {
+		issue := new(Issue)
+		if err := dynamodbattribute.UnmarshalMap(item, issue); err != nil {
+			return err
+		}
+		issues = append(issues, issue)
+		return nil
+	})
+	return issues, err
+}
+
+// claimDigest moves the user's digest watermark to now. It returns false if
+// another run moved it first.
+func claimDigest(user *User, now time.Time) (bool, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tables.Users),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(user.ID),
+			},
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+			},
+			":prev": {
+				N: aws.String(strconv.FormatInt(user.DigestSentAt, 10)),
+			},
+			":p": {
+				N: aws.String(strconv.Itoa(user.SamaritanPoints)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(DigestSentAt) or DigestSentAt = :prev"),
+		UpdateExpression:    aws.String("set DigestSentAt = :now, DigestPoints = :p"),
+	}
+	_, err := db.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	return err == nil, err
+}
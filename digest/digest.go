@@ -0,0 +1,98 @@
+package main
+
+import (
+	"mailer"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxNewIssues = 10
+	maxTopIssues = 3
+)
+
+// periodLength is how far back the first digest of a user looks.
+func periodLength(frequency string) time.Duration {
+	if frequency == frequencyWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func words(text string) map[string]bool {
+	found := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		found[word] = true
+	}
+	return found
+}
+
+// matches reports whether an open issue is one the user wants to hear about:
+// in their location and, if they listed interests, mentioning one of them.
+func matches(user *User, issue *Issue) bool {
+	if issue.Private != 0 || !strings.EqualFold(strings.TrimSpace(issue.Location), strings.TrimSpace(user.Location)) {
+		return false
+	}
+	if len(user.Interests) == 0 {
+		return true
+	}
+	issueWords := words(issue.Title + " " + issue.Body)
+	for _, interest := range user.Interests {
+		if issueWords[strings.ToLower(strings.TrimSpace(interest))] {
+			return true
+		}
+	}
+	return false
+}
+
+func summarize(issue *Issue) *mailer.IssueSummary {
+	return &mailer.IssueSummary{Title: issue.Title, Location: issue.Location, StatusMsg: issue.StatusMsg, SupportCount: issue.SupportCount}
+}
+
+// buildDigest collects what a user should hear about since their last digest.
+// It returns nil when there is nothing to tell.
+func buildDigest(user *User, openIssues []*Issue, now time.Time) *mailer.Data {
+	since := now.Add(-periodLength(user.DigestFrequency))
+	if user.DigestSentAt > 0 {
+		since = time.Unix(user.DigestSentAt, 0)
+	}
+
+	type created struct {
+		issue *Issue
+		at    time.Time
+	}
+	newIssues := make([]created, 0)
+	top := make([]*Issue, 0)
+	for _, issue := range openIssues {
+		if !matches(user, issue) {
+			continue
+		}
+		top = append(top, issue)
+		if issue.Created.After(since) && issue.UserID != user.ID {
+			newIssues = append(newIssues, created{issue, issue.Created.Time})
+		}
+	}
+	sort.Slice(newIssues, func(i, j int) bool { return newIssues[i].at.After(newIssues[j].at) })
+	sort.SliceStable(top, func(i, j int) bool { return top[i].SupportCount > top[j].SupportCount })
+
+	data := &mailer.Data{Name: user.Name, Period: user.DigestFrequency}
+	for i := 0; i < len(newIssues) && i < maxNewIssues; i++ {
+		data.NewIssues = append(data.NewIssues, summarize(newIssues[i].issue))
+	}
+	for i := 0; i < len(top) && i < maxTopIssues; i++ {
+		if top[i].SupportCount > 0 {
+			data.TopIssues = append(data.TopIssues, summarize(top[i]))
+		}
+	}
+	if user.DigestSentAt > 0 {
+		data.PointsChange = user.SamaritanPoints - user.DigestPoints
+	}
+	if len(data.NewIssues) == 0 && len(data.TopIssues) == 0 && data.PointsChange == 0 {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mailer"
+	"os"
+	"shared"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+const (
+	frequencyDaily  = "daily"
+	frequencyWeekly = "weekly"
+)
+
+type Issue struct {
+	ID           string      `json:"id"`
+	Created      shared.Time `json:"created"`
+	Title        string      `json:"title"`
+	Body         string      `json:"body"`
+	Private      int         `json:"private"`
+	UserID       string      `json:"userid"`
+	Location     string      `json:"location"`
+	SupportCount int         `json:"supportcount"`
+	StatusMsg    string      `json:"statusmsg"`
+}
+
+type User struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Email           string   `json:"email"`
+	SamaritanPoints int      `json:"samaritanpoints"`
+	Location        string   `json:"location"`
+	Interests       []string `json:"interests"`
+	DigestFrequency string   `json:"digestfrequency"`
+	// DigestSentAt (unix seconds) and DigestPoints are the watermark of the
+	// last digest sent to the user.
+	DigestSentAt int64 `json:"digestsentat"`
+	DigestPoints int   `json:"digestpoints"`
+}
+
+// DigestRequest is the input of the scheduled events in template.yaml.
+type DigestRequest struct {
+	Frequency string `json:"frequency"`
+}
+
+var mail mailer.Mailer
+
+func handler(ctx context.Context, request DigestRequest) error {
+	defer shared.LogInvocation(ctx)()
+	if request.Frequency != frequencyDaily && request.Frequency != frequencyWeekly {
+		return fmt.Errorf("unknown digest frequency %q", request.Frequency)
+	}
+	users, err := getDigestUsers(request.Frequency)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	openIssues, err := getOpenIssues()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sent := 0
+	for _, user := range users {
+		if user.Email == "" {
+			continue
+		}
+		data := buildDigest(user, openIssues, now)
+		// The watermark moves before the email is sent, so a retried run can
+		// never send the same digest twice.
+		claimed, err := claimDigest(user, now)
+		if err != nil {
+			return err
+		}
+		if !claimed || data == nil {
+			continue
+		}
+		message, err := mailer.Render(mailer.CategoryDigest, user.Email, data)
+		if err != nil {
+			return err
+		}
+		if err := mail.Send(message); err != nil {
+			shared.Log.WithError(err).With("userId", user.ID).Errorf("Failed to send %s digest", request.Frequency)
+			continue
+		}
+		sent++
+	}
+	shared.Log.Infof("Sent %d %s digests", sent, request.Frequency)
+	return nil
+}
+
+func main() {
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	db = shared.MustNewDynamoDB(cfg)
+	var err error
+	mail, err = mailer.FromEnv(cfg.Region)
+	if err != nil || mail == nil {
+		shared.Log.WithError(err).Errorf("The digest needs a mailer, set MAILER to smtp or ses")
+		os.Exit(1)
+	}
+	lambda.Start(handler)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"mailer"
+	"shared"
+	"strings"
+	"testing"
+	"time"
+)
+
+// at parses a stored creation time for the test issues.
+func at(value string) shared.Time {
+	t, err := shared.ParseTime(value)
+	if err != nil {
+		panic(err)
+	}
+	return shared.Time{Time: t}
+}
+
+func TestBuildDigest(t *testing.T) {
+	now := time.Date(2020, 9, 8, 0, 0, 0, 0, time.UTC)
+	user := &User{
+		ID:              "user-1",
+		Name:            "Kiran",
+		Location:        "Bangalore",
+		Interests:       []string{"streetlight", "water"},
+		DigestFrequency: frequencyDaily,
+		DigestSentAt:    now.Add(-24 * time.Hour).Unix(),
+		DigestPoints:    10,
+		SamaritanPoints: 30,
+	}
+	openIssues := []*Issue{
+		{ID: "new", Title: "Streetlight broken", Location: "bangalore", Created: at("2020-09-07 17:30:00.123 +0530 IST m=+0.000100001"), StatusMsg: "Need Help"},
+		{ID: "old", Title: "Water logging near school", Location: "Bangalore", Created: at("2020-09-01T12:00:00Z"), SupportCount: 7},
+		{ID: "elsewhere", Title: "Streetlight broken", Location: "Mysore", Created: at("2020-09-07T12:00:00Z")},
+		{ID: "uninteresting", Title: "Lost cat", Location: "Bangalore", Created: at("2020-09-07T12:00:00Z"), SupportCount: 20},
+		{ID: "own", Title: "Water tanker needed", Location: "Bangalore", Created: at("2020-09-07T13:00:00Z"), UserID: "user-1", SupportCount: 2},
+	}
+
+	data := buildDigest(user, openIssues, now)
+	if data == nil {
+		t.Fatal("expected a digest")
+	}
+	if len(data.NewIssues) != 1 || data.NewIssues[0].Title != "Streetlight broken" {
+		t.Errorf("new issues = %+v", data.NewIssues)
+	}
+	if len(data.TopIssues) != 2 || data.TopIssues[0].SupportCount != 7 || data.TopIssues[1].SupportCount != 2 {
+		t.Errorf("top issues = %+v", data.TopIssues)
+	}
+	if data.PointsChange != 20 {
+		t.Errorf("points change = %d, want 20", data.PointsChange)
+	}
+
+	message, err := mailer.Render(mailer.CategoryDigest, "kiran@example.com", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Your daily huManUnited digest", "Streetlight broken (bangalore, Need Help)", "(7 people affected)", "changed by 20"} {
+		if !strings.Contains(message.Subject+message.Body, want) {
+			t.Errorf("digest does not contain %q:\n%s", want, message.Body)
+		}
+	}
+}
+
+func TestBuildDigestNothingNew(t *testing.T) {
+	now := time.Date(2020, 9, 8, 0, 0, 0, 0, time.UTC)
+	user := &User{ID: "user-1", Location: "Bangalore", DigestFrequency: frequencyWeekly, DigestSentAt: now.Unix(), DigestPoints: 10, SamaritanPoints: 10}
+	openIssues := []*Issue{{ID: "old", Title: "Pothole", Location: "Bangalore", Created: at("2020-09-01T12:00:00Z")}}
+	if data := buildDigest(user, openIssues, now); data != nil {
+		t.Errorf("expected no digest, got %+v", data)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"shared"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var db *dynamodb.DynamoDB
+
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
+
+const (
+	subscribersIndex = "IssueSubscribersIndex"
+	retryIndex       = "RetryIndex"
+	batchWriteLimit  = 25
+	// processedEventTTL outlives the 24 hour retention of DynamoDB streams.
+	processedEventTTL = 48 * time.Hour
+)
+
+// DynamoProcessedStore keeps processed keys in the processedevents table.
+type DynamoProcessedStore struct{}
+
+func (s *DynamoProcessedStore) IsProcessed(key string) (bool, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(tables.ProcessedEvents),
+		Key: map[string]*dynamodb.AttributeValue{
+			"EventKey": {
+				S: aws.String(key),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+	result, err := db.GetItem(input)
+	if err != nil {
+		return false, err
+	}
+	return len(result.Item) > 0, nil
+}
+
+func (s *DynamoProcessedStore) MarkProcessed(key string) error {
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(tables.ProcessedEvents),
+		Item: map[string]*dynamodb.AttributeValue{
+			"EventKey": {
+				S: aws.String(key),
+			},
+			"ExpiresAt": {
+				N: aws.String(strconv.FormatInt(time.Now().Add(processedEventTTL).Unix(), 10)),
+			},
+		},
+	}
+	_, err := db.PutItem(input)
+	return err
+}
+
+// getSubscribersForIssue returns the ids of the users following an issue.
+func getSubscribersForIssue(issueId string) ([]string, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.Subscriptions),
+		IndexName:              aws.String(subscribersIndex),
+		KeyConditionExpression: aws.String("IssueId = :i"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":i": {
+				S: aws.String(issueId),
+			},
+		},
+	}
+	userIds := make([]string, 0)
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			if userId := i["UserId"]; userId != nil && userId.S != nil {
+				userIds = append(userIds, *userId.S)
+			}
+		}
+		return true
+	})
+	return userIds, err
+}
+
+// batchWrite writes the requests in batches of 25, retrying unprocessed items.
+func batchWrite(table string, requests []*dynamodb.WriteRequest) error {
+	for start := 0; start < len(requests); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(requests) {
+			end = len(requests)
+		}
+		pending := map[string][]*dynamodb.WriteRequest{table: requests[start:end]}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == 5 {
+				return fmt.Errorf("unprocessed items remain for table %s", table)
+			}
+			result, err := db.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = result.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+func getUserById(userId string) (*User, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(userId),
+			},
+		},
+		TableName: aws.String(tables.Users),
+	}
+	result, err := db.GetItem(input)
+	if err != nil {
+		shared.Log.WithError(err).Errorf("Failed to get Item from table %s for %s", tables.Users, userId)
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	user := new(User)
+	err = dynamodbattribute.UnmarshalMap(result.Item, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DynamoWebhookStore keeps webhooks in the webhooks table and their delivery
+// log in the webhookdeliveries table.
+type DynamoWebhookStore struct{}
+
+func (s *DynamoWebhookStore) Webhooks() ([]*Webhook, error) {
+	webhooks := make([]*Webhook, 0)
+	var unmarshalErr error
+	err := db.ScanPages(&dynamodb.ScanInput{TableName: aws.String(tables.Webhooks)},
+		func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			pageWebhooks := make([]*Webhook, 0, len(page.Items))
+			unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageWebhooks)
+			webhooks = append(webhooks, pageWebhooks...)
+			return unmarshalErr == nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, unmarshalErr
+}
+
+func (s *DynamoWebhookStore) Delivery(webhookID string, deliveryID string) (*Delivery, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(tables.WebhookDeliveries),
+		Key: map[string]*dynamodb.AttributeValue{
+			"WebhookId": {
+				S: aws.String(webhookID),
+			},
+			"DeliveryId": {
+				S: aws.String(deliveryID),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+	result, err := db.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	delivery := new(Delivery)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (s *DynamoWebhookStore) SaveDelivery(delivery *Delivery) error {
+	item, err := dynamodbattribute.MarshalMap(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tables.WebhookDeliveries),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoWebhookStore) Webhook(webhookID string) (*Webhook, error) {
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tables.Webhooks),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(webhookID),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	webhook := new(Webhook)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// DueDeliveries queries RetryIndex, which only holds pending deliveries.
+func (s *DynamoWebhookStore) DueDeliveries(now time.Time) ([]*Delivery, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.WebhookDeliveries),
+		IndexName:              aws.String(retryIndex),
+		KeyConditionExpression: aws.String("RetryKey = :r AND NextAttempt <= :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": {
+				S: aws.String(deliveryRetryKey),
+			},
+			":now": {
+				S: aws.String(shared.FormatTime(now)),
+			},
+		},
+	}
+	deliveries := make([]*Delivery, 0)
+	var unmarshalErr error
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		pageDeliveries := make([]*Delivery, 0, len(page.Items))
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageDeliveries)
+		deliveries = append(deliveries, pageDeliveries...)
+		return unmarshalErr == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, unmarshalErr
+}
+
+func (s *DynamoWebhookStore) ClaimDelivery(delivery *Delivery, until time.Time) (bool, error) {
+	claimed := shared.Time{Time: until}
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tables.WebhookDeliveries),
+		Key: map[string]*dynamodb.AttributeValue{
+			"WebhookId": {
+				S: aws.String(delivery.WebhookID),
+			},
+			"DeliveryId": {
+				S: aws.String(delivery.DeliveryID),
+			},
+		},
+		UpdateExpression:    aws.String("SET NextAttempt = :until"),
+		ConditionExpression: aws.String("NextAttempt = :next"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {
+				S: aws.String(claimed.String()),
+			},
+			":next": {
+				S: aws.String(delivery.NextAttempt.String()),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	delivery.NextAttempt = claimed
+	return true, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// EventType names a change to an item that handlers can subscribe to.
+type EventType string
+
+const (
+	IssueCreated        EventType = "IssueCreated"
+	IssueDeleted        EventType = "IssueDeleted"
+	IssueStatusChanged  EventType = "IssueStatusChanged"
+	IssueResolved       EventType = "IssueResolved"
+	IssueCommentAdded   EventType = "IssueCommentAdded"
+	IssueHelperAdded    EventType = "IssueHelperAdded"
+	IssueHelperAccepted EventType = "IssueHelperAccepted"
+	IssueSupportChanged EventType = "IssueSupportChanged"
+	IssueUpdated        EventType = "IssueUpdated" // follows the more specific events of any change
+	PostCreated         EventType = "PostCreated"
+	PostUpdated         EventType = "PostUpdated"
+	PostDeleted         EventType = "PostDeleted"
+	PostLiked           EventType = "PostLiked"
+	PostCommentAdded    EventType = "PostCommentAdded"
+)
+
+const statusResolved = "Resolved"
+
+// Event is a typed domain event derived from one stream record. A single
+// record can yield several events, e.g. a status change and a new comment.
+type Event struct {
+	Type EventType
+	// Key identifies the stream record the event came from.
+	Key string
+	// Time is when the change was made.
+	Time     time.Time
+	Issue    *Issue
+	OldIssue *Issue
+	Post     *Post
+	OldPost  *Post
+	// Comment is set for IssueCommentAdded and PostCommentAdded.
+	Comment *Comment
+	// UserID and UserName are the user the event is about: the helper for
+	// helper events, the commenter for comments and the liker for likes.
+	UserID   string
+	UserName string
+}
+
+// recordKey returns the idempotency key of a stream record. Sequence numbers
+// are only unique within a stream, so the table name is part of the key.
+func recordKey(record events.DynamoDBEventRecord) string {
+	return tableName(record.EventSourceArn) + "#" + record.Change.SequenceNumber
+}
+
+// tableName extracts the table from an ARN like
+// arn:aws:dynamodb:region:account:table/issues/stream/2020-09-07T00:00:00.000
+func tableName(arn string) string {
+	parts := strings.Split(arn, "/")
+	if len(parts) < 2 {
+		return arn
+	}
+	return parts[1]
+}
+
+// toEvents turns a stream record into domain events by diffing its images.
+func toEvents(record events.DynamoDBEventRecord) ([]*Event, error) {
+	recordEvents, err := diffRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	changed := record.Change.ApproximateCreationDateTime.Time
+	if changed.IsZero() {
+		changed = time.Now()
+	}
+	for _, event := range recordEvents {
+		event.Time = changed
+	}
+	return recordEvents, nil
+}
+
+func diffRecord(record events.DynamoDBEventRecord) ([]*Event, error) {
+	key := recordKey(record)
+	switch table := tableName(record.EventSourceArn); table {
+	case tables.Issues:
+		newIssue, err := decodeIssue(record.Change.NewImage)
+		if err != nil {
+			return nil, err
+		}
+		oldIssue, err := decodeIssue(record.Change.OldImage)
+		if err != nil {
+			return nil, err
+		}
+		return diffIssue(key, record.EventName, oldIssue, newIssue), nil
+	case tables.Posts:
+		newPost, err := decodePost(record.Change.NewImage)
+		if err != nil {
+			return nil, err
+		}
+		oldPost, err := decodePost(record.Change.OldImage)
+		if err != nil {
+			return nil, err
+		}
+		return diffPost(key, record.EventName, oldPost, newPost), nil
+	case tables.PostLikes, tables.PostComments:
+		// Likes and comments are only ever added or removed, and only
+		// additions are of interest.
+		if record.EventName != "INSERT" {
+			return nil, nil
+		}
+		activity := new(PostActivity)
+		if err := dynamodbattribute.UnmarshalMap(toAttributeValues(record.Change.NewImage), activity); err != nil {
+			return nil, err
+		}
+		post := &Post{ID: activity.PostID, Title: activity.PostTitle, UserId: activity.AuthorID}
+		if table == tables.PostLikes {
+			return []*Event{{Type: PostLiked, Key: key, Post: post, UserID: activity.UserID}}, nil
+		}
+		comment := &Comment{UserID: activity.UserID, UserName: activity.UserName, Comment: activity.Comment}
+		return []*Event{{Type: PostCommentAdded, Key: key, Post: post, Comment: comment,
+			UserID: activity.UserID, UserName: activity.UserName}}, nil
+	default:
+		return nil, fmt.Errorf("unexpected stream record from table %s", table)
+	}
+}
+
+func diffIssue(key string, eventName string, oldIssue *Issue, newIssue *Issue) []*Event {
+	switch eventName {
+	case "INSERT":
+		return []*Event{{Type: IssueCreated, Key: key, Issue: newIssue}}
+	case "REMOVE":
+		return []*Event{{Type: IssueDeleted, Key: key, OldIssue: oldIssue}}
+	case "MODIFY":
+	default:
+		return nil
+	}
+	if oldIssue == nil || newIssue == nil {
+		return nil
+	}
+
+	issueEvents := make([]*Event, 0)
+	if oldIssue.StatusMsg != newIssue.StatusMsg {
+		issueEvents = append(issueEvents, &Event{Type: IssueStatusChanged, Key: key, Issue: newIssue, OldIssue: oldIssue, UserID: newIssue.StatusBy})
+		if newIssue.StatusMsg == statusResolved {
+			issueEvents = append(issueEvents, &Event{Type: IssueResolved, Key: key, Issue: newIssue, OldIssue: oldIssue, UserID: newIssue.StatusBy})
+		}
+	}
+	for i := len(oldIssue.Comments); i < len(newIssue.Comments); i++ {
+		comment := newIssue.Comments[i]
+		issueEvents = append(issueEvents, &Event{Type: IssueCommentAdded, Key: key, Issue: newIssue, OldIssue: oldIssue,
+			Comment: &comment, UserID: comment.UserID, UserName: comment.UserName})
+	}
+	helperIds := make([]string, 0)
+	for helperId := range newIssue.Helpers {
+		if _, ok := oldIssue.Helpers[helperId]; !ok {
+			helperIds = append(helperIds, helperId)
+		}
+	}
+	sort.Strings(helperIds)
+	for _, helperId := range helperIds {
+		issueEvents = append(issueEvents, &Event{Type: IssueHelperAdded, Key: key, Issue: newIssue, OldIssue: oldIssue,
+			UserID: helperId, UserName: newIssue.Helpers[helperId]})
+	}
+	wasAccepted := map[string]bool{}
+	for _, helperId := range oldIssue.Accepted {
+		wasAccepted[helperId] = true
+	}
+	for _, helperId := range newIssue.Accepted {
+		if !wasAccepted[helperId] {
+			issueEvents = append(issueEvents, &Event{Type: IssueHelperAccepted, Key: key, Issue: newIssue, OldIssue: oldIssue,
+				UserID: helperId, UserName: newIssue.Helpers[helperId]})
+		}
+	}
+	if oldIssue.SupportCount != newIssue.SupportCount {
+		issueEvents = append(issueEvents, &Event{Type: IssueSupportChanged, Key: key, Issue: newIssue, OldIssue: oldIssue})
+	}
+	if !reflect.DeepEqual(oldIssue, newIssue) {
+		issueEvents = append(issueEvents, &Event{Type: IssueUpdated, Key: key, Issue: newIssue, OldIssue: oldIssue})
+	}
+	return issueEvents
+}
+
+func diffPost(key string, eventName string, oldPost *Post, newPost *Post) []*Event {
+	switch eventName {
+	case "INSERT":
+		return []*Event{{Type: PostCreated, Key: key, Post: newPost}}
+	case "MODIFY":
+		return []*Event{{Type: PostUpdated, Key: key, Post: newPost, OldPost: oldPost}}
+	case "REMOVE":
+		return []*Event{{Type: PostDeleted, Key: key, OldPost: oldPost}}
+	}
+	return nil
+}
+
+func decodeIssue(image map[string]events.DynamoDBAttributeValue) (*Issue, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+	issue := new(Issue)
+	if err := dynamodbattribute.UnmarshalMap(toAttributeValues(image), issue); err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+func decodePost(image map[string]events.DynamoDBAttributeValue) (*Post, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+	post := new(Post)
+	if err := dynamodbattribute.UnmarshalMap(toAttributeValues(image), post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// toAttributeValues converts a stream image into the SDK's attribute values
+// so that it can be unmarshalled like any item read from a table.
+func toAttributeValues(image map[string]events.DynamoDBAttributeValue) map[string]*dynamodb.AttributeValue {
+	item := make(map[string]*dynamodb.AttributeValue, len(image))
+	for name, value := range image {
+		item[name] = toAttributeValue(value)
+	}
+	return item
+}
+
+func toAttributeValue(value events.DynamoDBAttributeValue) *dynamodb.AttributeValue {
+	switch value.DataType() {
+	case events.DataTypeString:
+		return &dynamodb.AttributeValue{S: aws.String(value.String())}
+	case events.DataTypeNumber:
+		return &dynamodb.AttributeValue{N: aws.String(value.Number())}
+	case events.DataTypeBinary:
+		return &dynamodb.AttributeValue{B: value.Binary()}
+	case events.DataTypeBoolean:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(value.Boolean())}
+	case events.DataTypeStringSet:
+		return &dynamodb.AttributeValue{SS: aws.StringSlice(value.StringSet())}
+	case events.DataTypeNumberSet:
+		return &dynamodb.AttributeValue{NS: aws.StringSlice(value.NumberSet())}
+	case events.DataTypeBinarySet:
+		return &dynamodb.AttributeValue{BS: value.BinarySet()}
+	case events.DataTypeList:
+		list := make([]*dynamodb.AttributeValue, 0, len(value.List()))
+		for _, element := range value.List() {
+			list = append(list, toAttributeValue(element))
+		}
+		return &dynamodb.AttributeValue{L: list}
+	case events.DataTypeMap:
+		return &dynamodb.AttributeValue{M: toAttributeValues(value.Map())}
+	}
+	return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+}
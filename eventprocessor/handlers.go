@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"mailer"
+	"realtime"
+	"shared"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// notificationTTL is how long a notification is kept before DynamoDB expires it.
+const notificationTTL = 90 * 24 * time.Hour
+
+// acceptedHelpPoints must match the points the issues function awards when help is accepted.
+const acceptedHelpPoints = 10
+
+const (
+	notificationComment      = "comment"
+	notificationHelp         = "help"
+	notificationHelpAccepted = "helpaccepted"
+	notificationStatus       = "status"
+	notificationPoints       = "points"
+	notificationPostLike     = "postlike"
+	notificationPostComment  = "postcomment"
+	notificationStoryTag     = "storytag"
+)
+
+// NotificationHandler writes in-app notifications for issue activity to the
+// owner and the followers of the issue, for likes and comments on a post to
+// its author, and for stories to the helpers tagged in them.
+type NotificationHandler struct{}
+
+func (h *NotificationHandler) Handle(event *Event) error {
+	issue := event.Issue
+	switch event.Type {
+	case IssueCommentAdded:
+		return notifyIssue(event, notificationComment, event.UserID,
+			fmt.Sprintf("%s commented: %s", event.UserName, event.Comment.Comment))
+	case IssueHelperAdded:
+		return notifyIssue(event, notificationHelp, event.UserID,
+			fmt.Sprintf("%s offered to help", event.UserName))
+	case IssueHelperAccepted:
+		err := notifyIssue(event, notificationHelpAccepted, issue.UserID,
+			fmt.Sprintf("%s accepted the help of %s", issue.UserName, event.UserName))
+		if err != nil {
+			return err
+		}
+		return notifyUsers(event, []string{event.UserID}, notificationPoints, issue.UserID,
+			fmt.Sprintf("You earned %d Samaritan Points", acceptedHelpPoints))
+	case IssueStatusChanged:
+		return notifyIssue(event, notificationStatus, issue.StatusBy,
+			fmt.Sprintf("Status changed to %s", issue.StatusMsg))
+	case PostCreated:
+		return notifyUsers(event, event.Post.Tagged, notificationStoryTag, event.Post.UserId,
+			fmt.Sprintf("You were tagged in the story %q", event.Post.Title))
+	case PostLiked:
+		return notifyUsers(event, []string{event.Post.UserId}, notificationPostLike, event.UserID,
+			"Someone liked your post")
+	case PostCommentAdded:
+		return notifyUsers(event, []string{event.Post.UserId}, notificationPostComment, event.UserID,
+			fmt.Sprintf("%s commented: %s", event.UserName, event.Comment.Comment))
+	}
+	return nil
+}
+
+// notifyIssue tells the owner and the followers of an issue about an event,
+// except the user who caused it.
+func notifyIssue(event *Event, kind string, actorId string, message string) error {
+	subscribers, err := getSubscribersForIssue(event.Issue.ID)
+	if err != nil {
+		return err
+	}
+	recipients := append([]string{event.Issue.UserID}, subscribers...)
+	return notifyUsers(event, recipients, kind, actorId, message)
+}
+
+// notifyUsers writes one notification to each recipient, skipping duplicates
+// and the actor. Notification ids are derived from the event, so handling
+// the same event twice overwrites rather than duplicates them.
+func notifyUsers(event *Event, recipients []string, kind string, actorId string, message string) error {
+	notificationId := event.Time.UTC().Format("20060102150405.000000000") + "-" + event.Key + "-" + kind + "-" + event.UserID
+	created := shared.FormatTime(event.Time)
+	expiresAt := strconv.FormatInt(event.Time.Add(notificationTTL).Unix(), 10)
+	seen := map[string]bool{actorId: true, "": true}
+	requests := make([]*dynamodb.WriteRequest, 0, len(recipients))
+	for _, userId := range recipients {
+		if seen[userId] {
+			continue
+		}
+		seen[userId] = true
+		item := map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+			"NotificationId": {
+				S: aws.String(notificationId),
+			},
+			"Kind": {
+				S: aws.String(kind),
+			},
+			"ActorId": {
+				S: aws.String(actorId),
+			},
+			"Message": {
+				S: aws.String(message),
+			},
+			"Read": {
+				BOOL: aws.Bool(false),
+			},
+			"Created": {
+				S: aws.String(created),
+			},
+			"ExpiresAt": {
+				N: aws.String(expiresAt),
+			},
+		}
+		if event.Issue != nil {
+			item["IssueId"] = &dynamodb.AttributeValue{S: aws.String(event.Issue.ID)}
+			item["IssueTitle"] = &dynamodb.AttributeValue{S: aws.String(event.Issue.Title)}
+		}
+		if event.Post != nil {
+			item["PostId"] = &dynamodb.AttributeValue{S: aws.String(event.Post.ID)}
+			item["PostTitle"] = &dynamodb.AttributeValue{S: aws.String(event.Post.Title)}
+		}
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: item},
+		})
+	}
+	return batchWrite(tables.Notifications, requests)
+}
+
+// EmailHandler emails issue owners and helpers about activity that concerns
+// them, unless they opted out of that category of email.
+type EmailHandler struct {
+	Mailer mailer.Mailer
+}
+
+func (h *EmailHandler) Handle(event *Event) error {
+	issue := event.Issue
+	data := &mailer.Data{IssueID: issue.ID, IssueTitle: issue.Title, ActorName: event.UserName}
+	switch event.Type {
+	case IssueHelperAdded:
+		return h.email(issue.UserID, event.UserID, mailer.CategoryHelpOffered, data)
+	case IssueCommentAdded:
+		data.Comment = event.Comment.Comment
+		return h.email(issue.UserID, event.UserID, mailer.CategoryComment, data)
+	case IssueHelperAccepted:
+		data.ActorName = issue.UserName
+		data.Points = acceptedHelpPoints
+		return h.email(event.UserID, issue.UserID, mailer.CategoryPoints, data)
+	}
+	return nil
+}
+
+func (h *EmailHandler) email(recipientId string, actorId string, category mailer.Category, data *mailer.Data) error {
+	if recipientId == "" || recipientId == actorId {
+		return nil
+	}
+	user, err := getUserById(recipientId)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Email == "" || user.optedOut(category) {
+		return nil
+	}
+	data.Name = user.Name
+	message, err := mailer.Render(category, user.Email, data)
+	if err != nil {
+		return err
+	}
+	// Emails are best effort: a mail server that is down must not hold up the
+	// stream and the notifications behind it.
+	if err := h.Mailer.Send(message); err != nil {
+		shared.Log.WithError(err).With("userId", recipientId).Warnf("Failed to send %s email", category)
+	}
+	return nil
+}
+
+// helperUpdate is the data pushed to WebSocket clients when a helper offers
+// help or is accepted.
+type helperUpdate struct {
+	UserID   string `json:"userid"`
+	UserName string `json:"username"`
+}
+
+// statusUpdate is the data pushed to WebSocket clients when the status changes.
+type statusUpdate struct {
+	StatusMsg string `json:"statusmsg"`
+	StatusBy  string `json:"statusby"`
+}
+
+// BroadcastHandler pushes issue updates to the WebSocket connections
+// subscribed to the issue. Anyone can subscribe to any issue, so updates of
+// private issues are not pushed.
+type BroadcastHandler struct {
+	Broadcaster *realtime.Broadcaster
+}
+
+func (h *BroadcastHandler) Handle(event *Event) error {
+	var data interface{}
+	switch event.Type {
+	case IssueCommentAdded:
+		data = event.Comment
+	case IssueHelperAdded, IssueHelperAccepted:
+		data = &helperUpdate{UserID: event.UserID, UserName: event.UserName}
+	case IssueStatusChanged:
+		data = &statusUpdate{StatusMsg: event.Issue.StatusMsg, StatusBy: event.Issue.StatusBy}
+	default:
+		return nil
+	}
+	if event.Issue.Private != 0 {
+		return nil
+	}
+	message := &realtime.Message{Type: string(event.Type), IssueID: event.Issue.ID, Data: data}
+	// Updates are best effort like emails: clients that missed one still see
+	// the change the next time they load the issue.
+	if err := h.Broadcaster.Broadcast(message); err != nil {
+		shared.Log.WithError(err).Warnf("Failed to broadcast %s for issue %s", event.Type, event.Issue.ID)
+	}
+	return nil
+}
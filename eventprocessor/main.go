@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"mailer"
+	"os"
+	"realtime"
+	"shared"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Comment struct {
+	UserID   string `json:"userid"`
+	UserName string `json:"username"`
+	Comment  string `json:"comment"`
+}
+
+type Issue struct {
+	ID           string            `json:"id"`
+	Created      shared.Time       `json:"created"`
+	Title        string            `json:"title"`
+	Body         string            `json:"body"`
+	Private      int               `json:"private"`
+	UserID       string            `json:"userid"`
+	UserName     string            `json:"username"`
+	Location     string            `json:"location"`
+	Category     string            `json:"category"`
+	Personal     int               `json:"personal"`
+	Helpers      map[string]string `json:"helpers"`
+	Accepted     []string          `json:"accepted"`
+	SupportCount int               `json:"supportcount"`
+	Comments     []Comment         `json:"comments"`
+	StatusMsg    string            `json:"statusmsg"`
+	StatusBy     string            `json:"statusby"`
+}
+
+type User struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	EmailOptOut []string `json:"emailoptout"`
+}
+
+func (u *User) optedOut(category mailer.Category) bool {
+	for _, optOut := range u.EmailOptOut {
+		if optOut == string(category) {
+			return true
+		}
+	}
+	return false
+}
+
+type Post struct {
+	ID           string      `json:"id"`
+	Title        string      `json:"title"`
+	Description  string      `json:"description"`
+	PostTime     shared.Time `json:"posttime"`
+	Edited       shared.Time `json:"edited"`
+	UserId       string      `json:"userid"`
+	LikeCount    int         `json:"likecount"`
+	CommentCount int         `json:"commentcount"`
+	// IssueID and Tagged are set on stories, posts about a resolved issue.
+	IssueID string   `json:"issueid" dynamodbav:"IssueId"`
+	Tagged  []string `json:"tagged"`
+}
+
+// PostActivity is an item of the postlikes or postcomments table. Both carry
+// the author and title of the post so that the author can be notified
+// without reading the post.
+type PostActivity struct {
+	PostID    string `dynamodbav:"PostId"`
+	UserID    string `dynamodbav:"UserId"`
+	UserName  string
+	Comment   string
+	AuthorID  string `dynamodbav:"AuthorId"`
+	PostTitle string
+}
+
+// newProcessor wires the handlers that run for every stream event. Emails are
+// only sent when a mailer is configured, and WebSocket updates only when a
+// broadcaster is.
+func newProcessor(store ProcessedStore, webhooks *WebhookHandler, mail mailer.Mailer, broadcaster *realtime.Broadcaster) *Processor {
+	processor := NewProcessor(store)
+	notifications := &NotificationHandler{}
+	processor.Register(notifications, IssueCommentAdded, IssueHelperAdded, IssueHelperAccepted, IssueStatusChanged,
+		PostCreated, PostLiked, PostCommentAdded)
+	if mail != nil {
+		processor.Register(&EmailHandler{Mailer: mail}, IssueCommentAdded, IssueHelperAdded, IssueHelperAccepted)
+	}
+	processor.Register(webhooks, IssueCreated, IssueUpdated, IssueResolved)
+	if broadcaster != nil {
+		processor.Register(&BroadcastHandler{Broadcaster: broadcaster}, IssueCommentAdded, IssueHelperAdded, IssueHelperAccepted, IssueStatusChanged)
+	}
+	return processor
+}
+
+// replay feeds a recorded stream event, as delivered to the lambda, through
+// the processor. Processed sequence numbers are only remembered in memory.
+// Webhook retries are left to the schedule.
+func replay(path string, mail mailer.Mailer, broadcaster *realtime.Broadcaster) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	streamEvent := events.DynamoDBEvent{}
+	if err := json.Unmarshal(data, &streamEvent); err != nil {
+		return err
+	}
+	webhooks := NewWebhookHandler(&DynamoWebhookStore{})
+	return newProcessor(NewMemoryProcessedStore(), webhooks, mail, broadcaster).Process(streamEvent)
+}
+
+// invocation is the input of the function: the records of the issues and
+// posts streams, or {"retrywebhooks": true} from the schedule that retries
+// failed webhook deliveries.
+type invocation struct {
+	events.DynamoDBEvent
+	RetryWebhooks bool `json:"retrywebhooks"`
+}
+
+// newBroadcaster returns a broadcaster posting to the connections of the
+// WebSocket API at endpoint in region, or nil when no endpoint is
+// configured. Locally the endpoint can be the address of
+// "go run ./websocket -local".
+func newBroadcaster(endpoint string, region string) (*realtime.Broadcaster, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	sender, err := realtime.NewAPIGatewaySender(endpoint, region)
+	if err != nil {
+		return nil, err
+	}
+	store := &realtime.DynamoConnectionStore{DB: db, Table: tables.Connections}
+	return &realtime.Broadcaster{Store: store, Sender: sender}, nil
+}
+
+func main() {
+	replayPath := flag.String("replay", "", "process a recorded DynamoDB stream event from this file and exit")
+	flag.Parse()
+
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	db = shared.MustNewDynamoDB(cfg)
+	mail, err := mailer.FromEnv(cfg.Region)
+	if err != nil {
+		shared.Log.WithError(err).Errorf("Failed to configure the mailer")
+		os.Exit(1)
+	}
+	broadcaster, err := newBroadcaster(os.Getenv("WEBSOCKET_ENDPOINT"), cfg.Region)
+	if err != nil {
+		shared.Log.WithError(err).Errorf("Failed to configure the broadcaster")
+		os.Exit(1)
+	}
+	if *replayPath != "" {
+		if err := replay(*replayPath, mail, broadcaster); err != nil {
+			shared.Log.WithError(err).Errorf("Failed to replay %s", *replayPath)
+			os.Exit(1)
+		}
+		return
+	}
+	webhooks := NewWebhookHandler(&DynamoWebhookStore{})
+	processor := newProcessor(&DynamoProcessedStore{}, webhooks, mail, broadcaster)
+	lambda.Start(func(ctx context.Context, input invocation) error {
+		defer shared.LogInvocation(ctx)()
+		if input.RetryWebhooks {
+			return webhooks.RetryDeliveries()
+		}
+		return processor.Process(input.DynamoDBEvent)
+	})
+}
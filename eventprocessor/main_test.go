@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"realtime"
+	"reflect"
+	"shared"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func loadStreamEvent(t *testing.T, path string) events.DynamoDBEvent {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamEvent := events.DynamoDBEvent{}
+	if err := json.Unmarshal(data, &streamEvent); err != nil {
+		t.Fatal(err)
+	}
+	return streamEvent
+}
+
+type recorder struct {
+	events []*Event
+}
+
+func (r *recorder) Handle(event *Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+var allEventTypes = []EventType{IssueCreated, IssueDeleted, IssueStatusChanged, IssueResolved, IssueCommentAdded,
+	IssueHelperAdded, IssueHelperAccepted, IssueSupportChanged, IssueUpdated, PostCreated, PostUpdated, PostDeleted, PostLiked, PostCommentAdded}
+
+func TestProcessRecordedStream(t *testing.T) {
+	streamEvent := loadStreamEvent(t, "testdata/issues_stream.json")
+	processor := NewProcessor(NewMemoryProcessedStore())
+	recorded := &recorder{}
+	processor.Register(recorded, allEventTypes...)
+
+	if err := processor.Process(streamEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]EventType, 0)
+	for _, event := range recorded.events {
+		got = append(got, event.Type)
+	}
+	want := []EventType{IssueCreated, IssueHelperAdded, IssueUpdated, IssueStatusChanged, IssueResolved, IssueCommentAdded,
+		IssueHelperAccepted, IssueUpdated, PostCreated}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("event types = %v, want %v", got, want)
+	}
+
+	helper := recorded.events[1]
+	if helper.UserID != "helper-1" || helper.UserName != "Kiran" || helper.Issue.ID != "issue-1" {
+		t.Errorf("helper event = %+v", helper)
+	}
+	status := recorded.events[3]
+	if status.OldIssue.StatusMsg != "Need Help" || status.Issue.StatusMsg != "Resolved" || status.UserID != "owner-1" {
+		t.Errorf("status event = %+v", status)
+	}
+	comment := recorded.events[5]
+	if comment.Comment == nil || comment.Comment.Comment != "Fixed it with the BBMP" || comment.UserID != "helper-1" {
+		t.Errorf("comment event = %+v", comment)
+	}
+	if post := recorded.events[8]; post.Post.Title != "The streetlight is back" || post.Key != "posts#111" ||
+		post.Post.IssueID != "issue-1" || !reflect.DeepEqual(post.Post.Tagged, []string{"helper-1"}) {
+		t.Errorf("post event = %+v", post)
+	}
+
+	// Redelivering the same batch must not dispatch anything again.
+	if err := processor.Process(streamEvent); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded.events) != len(want) {
+		t.Errorf("redelivered batch dispatched %d more events", len(recorded.events)-len(want))
+	}
+}
+
+func TestProcessRetriesFailedRecords(t *testing.T) {
+	streamEvent := loadStreamEvent(t, "testdata/issues_stream.json")
+	processor := NewProcessor(NewMemoryProcessedStore())
+	recorded := &recorder{}
+	processor.Register(recorded, IssueCreated, IssueHelperAdded, PostCreated)
+	failures := 1
+	processor.Register(HandlerFunc(func(event *Event) error {
+		if failures > 0 {
+			failures--
+			return errors.New("handler unavailable")
+		}
+		return nil
+	}), IssueHelperAdded)
+
+	if err := processor.Process(streamEvent); err == nil {
+		t.Fatal("expected the failing handler to fail the batch")
+	}
+	if err := processor.Process(streamEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]EventType, 0)
+	for _, event := range recorded.events {
+		got = append(got, event.Type)
+	}
+	// The created record was done before the failure; the helper record is
+	// retried, so its first handler sees it twice.
+	want := []EventType{IssueCreated, IssueHelperAdded, IssueHelperAdded, PostCreated}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("event types = %v, want %v", got, want)
+	}
+}
+
+func TestProcessPostActivity(t *testing.T) {
+	streamEvent := loadStreamEvent(t, "testdata/post_activity_stream.json")
+	processor := NewProcessor(NewMemoryProcessedStore())
+	recorded := &recorder{}
+	processor.Register(recorded, allEventTypes...)
+
+	if err := processor.Process(streamEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing a like is not an event.
+	if len(recorded.events) != 2 {
+		t.Fatalf("got %d events, want 2", len(recorded.events))
+	}
+	wantPost := &Post{ID: "post-1", Title: "Cleanup drive this Sunday", UserId: "owner-1"}
+	liked := recorded.events[0]
+	if liked.Type != PostLiked || liked.UserID != "reader-1" || !reflect.DeepEqual(liked.Post, wantPost) {
+		t.Errorf("like event = %+v, want PostLiked by reader-1 on %+v", liked, wantPost)
+	}
+	commented := recorded.events[1]
+	wantComment := &Comment{UserID: "reader-2", UserName: "Asha", Comment: "Count me in"}
+	if commented.Type != PostCommentAdded || commented.UserName != "Asha" || !reflect.DeepEqual(commented.Post, wantPost) ||
+		!reflect.DeepEqual(commented.Comment, wantComment) {
+		t.Errorf("comment event = %+v, want PostCommentAdded %+v on %+v", commented, wantComment, wantPost)
+	}
+}
+
+type sentMessages map[string][]string
+
+func (s sentMessages) Send(connectionID string, data []byte) error {
+	s[connectionID] = append(s[connectionID], string(data))
+	return nil
+}
+
+func TestBroadcastHandler(t *testing.T) {
+	store := realtime.NewMemoryConnectionStore()
+	store.Subscribe("c1", "issue-1")
+	store.Subscribe("c2", "issue-2")
+	sent := sentMessages{}
+	processor := NewProcessor(NewMemoryProcessedStore())
+	processor.Register(&BroadcastHandler{Broadcaster: &realtime.Broadcaster{Store: store, Sender: sent}}, allEventTypes...)
+
+	if err := processor.Process(loadStreamEvent(t, "testdata/issues_stream.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"type":"IssueHelperAdded","issueId":"issue-1","data":{"userid":"helper-1","username":"Kiran"}}`,
+		`{"type":"IssueStatusChanged","issueId":"issue-1","data":{"statusmsg":"Resolved","statusby":"owner-1"}}`,
+		`{"type":"IssueCommentAdded","issueId":"issue-1","data":{"userid":"helper-1","username":"Kiran","comment":"Fixed it with the BBMP"}}`,
+		`{"type":"IssueHelperAccepted","issueId":"issue-1","data":{"userid":"helper-1","username":"Kiran"}}`,
+	}
+	if !reflect.DeepEqual(sent["c1"], want) {
+		t.Errorf("sent to c1:\n%s\nwant:\n%s", strings.Join(sent["c1"], "\n"), strings.Join(want, "\n"))
+	}
+	if len(sent["c2"]) != 0 {
+		t.Errorf("sent to c2, subscribed to another issue: %v", sent["c2"])
+	}
+
+	// Updates of private issues are not pushed to the subscribers.
+	store.Subscribe("c3", "private-1")
+	handler := &BroadcastHandler{Broadcaster: &realtime.Broadcaster{Store: store, Sender: sent}}
+	private := &Event{Type: IssueCommentAdded, Issue: &Issue{ID: "private-1", Private: 1},
+		Comment: &Comment{UserID: "helper-1", Comment: "I know who lives here"}}
+	if err := handler.Handle(private); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent["c3"]) != 0 {
+		t.Errorf("sent an update of a private issue: %v", sent["c3"])
+	}
+}
+
+type memoryWebhookStore struct {
+	webhooks   []*Webhook
+	deliveries map[string]*Delivery
+}
+
+func (s *memoryWebhookStore) Webhooks() ([]*Webhook, error) {
+	return s.webhooks, nil
+}
+
+func (s *memoryWebhookStore) Webhook(webhookID string) (*Webhook, error) {
+	for _, webhook := range s.webhooks {
+		if webhook.ID == webhookID {
+			return webhook, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryWebhookStore) Delivery(webhookID string, deliveryID string) (*Delivery, error) {
+	return s.deliveries[webhookID+"/"+deliveryID], nil
+}
+
+func (s *memoryWebhookStore) SaveDelivery(delivery *Delivery) error {
+	s.deliveries[delivery.WebhookID+"/"+delivery.DeliveryID] = delivery
+	return nil
+}
+
+func (s *memoryWebhookStore) DueDeliveries(now time.Time) ([]*Delivery, error) {
+	due := make([]*Delivery, 0)
+	for _, delivery := range s.deliveries {
+		if delivery.RetryKey == deliveryRetryKey && !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (s *memoryWebhookStore) ClaimDelivery(delivery *Delivery, until time.Time) (bool, error) {
+	stored := s.deliveries[delivery.WebhookID+"/"+delivery.DeliveryID]
+	if stored == nil || !stored.NextAttempt.Equal(delivery.NextAttempt.Time) {
+		return false, nil
+	}
+	stored.NextAttempt = shared.Time{Time: until}
+	delivery.NextAttempt = stored.NextAttempt
+	return true, nil
+}
+
+func (s *memoryWebhookStore) deliveriesOf(webhookID string) []*Delivery {
+	deliveries := make([]*Delivery, 0)
+	for _, delivery := range s.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].DeliveryID < deliveries[j].DeliveryID })
+	return deliveries
+}
+
+// webhookReceiver answers with the given status codes in turn, then 200.
+type webhookReceiver struct {
+	secret   string
+	statuses []int
+	events   []string
+	badSigs  int
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.Header.Get(WebhookSignatureHeader) != sign(r.secret, body) {
+		r.badSigs++
+	}
+	payload := WebhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Event != req.Header.Get(WebhookEventHeader) {
+		r.badSigs++
+	}
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	r.events = append(r.events, payload.Event)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	flaky := &webhookReceiver{secret: "flaky-secret", statuses: []int{http.StatusServiceUnavailable}}
+	down := &webhookReceiver{secret: "down-secret", statuses: []int{500, 500, 500, 500, 500, 500, 500, 500, 500}}
+	rejecting := &webhookReceiver{secret: "rejecting-secret", statuses: []int{http.StatusBadRequest}}
+	other := &webhookReceiver{secret: "other-secret"}
+	servers := make([]*httptest.Server, 0)
+	for _, receiver := range []*webhookReceiver{flaky, down, rejecting, other} {
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	store := &memoryWebhookStore{
+		webhooks: []*Webhook{
+			{ID: "flaky", URL: servers[0].URL, Secret: flaky.secret, Locations: []string{"bangalore"}},
+			{ID: "down", URL: servers[1].URL, Secret: down.secret, Categories: []string{"streetlights"}, Events: []string{webhookIssueResolved}},
+			{ID: "rejecting", URL: servers[2].URL, Secret: rejecting.secret, Events: []string{webhookIssueCreated}},
+			{ID: "other", URL: servers[3].URL, Secret: other.secret, Categories: []string{"water"}},
+		},
+		deliveries: map[string]*Delivery{},
+	}
+	start := time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	handler := NewWebhookHandler(store)
+	handler.now = func() time.Time { return now }
+	processor := NewProcessor(NewMemoryProcessedStore())
+	processor.Register(handler, IssueCreated, IssueUpdated, IssueResolved)
+
+	if err := processor.Process(loadStreamEvent(t, "testdata/issues_stream.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream attempts every delivery once and queues the failed ones;
+	// resolving the issue is delivered as issue.resolved only.
+	if want := []string{webhookIssueUpdated, webhookIssueResolved}; !reflect.DeepEqual(flaky.events, want) {
+		t.Errorf("flaky webhook received %v, want %v", flaky.events, want)
+	}
+	if deliveries := store.deliveriesOf("flaky"); len(deliveries) != 3 || deliveries[0].Status != deliveryPending ||
+		deliveries[0].Attempts != 1 || !deliveries[0].NextAttempt.Equal(start.Add(webhookBaseDelay)) {
+		t.Errorf("flaky deliveries = %+v", deliveries)
+	}
+	// Client errors are not retried.
+	if rejected := store.deliveriesOf("rejecting"); len(rejected) != 1 || rejected[0].Status != deliveryDeadLetter ||
+		rejected[0].Attempts != 1 || rejected[0].RetryKey != "" {
+		t.Errorf("rejecting deliveries = %+v", rejected)
+	}
+	if len(store.deliveriesOf("other")) != 0 || len(other.events) != 0 {
+		t.Error("delivered to a webhook filtered on another category")
+	}
+	if err := handler.RetryDeliveries(); err != nil {
+		t.Fatal(err)
+	}
+	if len(flaky.events) != 2 {
+		t.Error("retried a delivery before its next attempt")
+	}
+
+	// A pending delivery of a deleted webhook is dead-lettered.
+	store.deliveries["deleted/d1"] = &Delivery{WebhookID: "deleted", DeliveryID: "d1", Status: deliveryPending,
+		Attempts: 1, RetryKey: deliveryRetryKey, NextAttempt: shared.Time{Time: start}}
+
+	attempted := make([]time.Duration, 0)
+	for attempts := 1; now.Before(start.Add(time.Hour)); now = now.Add(time.Minute) {
+		if err := handler.RetryDeliveries(); err != nil {
+			t.Fatal(err)
+		}
+		if dead := store.deliveriesOf("down"); len(dead) == 1 && dead[0].Attempts > attempts {
+			attempts = dead[0].Attempts
+			attempted = append(attempted, now.Sub(start))
+		}
+	}
+
+	if want := []string{webhookIssueUpdated, webhookIssueResolved, webhookIssueCreated}; !reflect.DeepEqual(flaky.events, want) {
+		t.Errorf("flaky webhook received %v, want %v", flaky.events, want)
+	}
+	if deliveries := store.deliveriesOf("flaky"); deliveries[0].Attempts != 2 || deliveries[0].Status != deliveryDelivered ||
+		deliveries[0].RetryKey != "" || !deliveries[0].NextAttempt.IsZero() {
+		t.Errorf("flaky deliveries = %+v", deliveries)
+	}
+	dead := store.deliveriesOf("down")
+	if len(dead) != 1 || dead[0].Status != deliveryDeadLetter || dead[0].Attempts != webhookMaxAttempts ||
+		dead[0].ResponseStatus != 500 || dead[0].Event != webhookIssueResolved || dead[0].Payload == "" {
+		t.Errorf("down deliveries = %+v", dead)
+	}
+	if want := []time.Duration{time.Minute, 3 * time.Minute, 7 * time.Minute, 15 * time.Minute}; !reflect.DeepEqual(attempted, want) {
+		t.Errorf("retried at %v, want %v", attempted, want)
+	}
+	if deleted := store.deliveriesOf("deleted"); deleted[0].Status != deliveryDeadLetter || deleted[0].Attempts != 1 {
+		t.Errorf("deleted webhook deliveries = %+v", deleted)
+	}
+	for _, receiver := range []*webhookReceiver{flaky, down, rejecting} {
+		if receiver.badSigs != 0 {
+			t.Errorf("%d requests to %s had a bad signature or event header", receiver.badSigs, receiver.secret)
+		}
+	}
+
+	// Reprocessing the records, e.g. after a failure further down the batch,
+	// does not post the same deliveries again.
+	processor = NewProcessor(NewMemoryProcessedStore())
+	processor.Register(handler, IssueCreated, IssueUpdated, IssueResolved)
+	if err := processor.Process(loadStreamEvent(t, "testdata/issues_stream.json")); err != nil {
+		t.Fatal(err)
+	}
+	if len(flaky.events) != 3 {
+		t.Errorf("redelivered records posted %d more events", len(flaky.events)-3)
+	}
+}
+
+func TestWebhooksSkipPrivateIssues(t *testing.T) {
+	store := &memoryWebhookStore{webhooks: []*Webhook{{ID: "all", URL: "http://127.0.0.1:1"}}, deliveries: map[string]*Delivery{}}
+	handler := NewWebhookHandler(store)
+	// Any non-zero Private marks an issue private.
+	for _, private := range []int{1, 2} {
+		event := &Event{Type: IssueCreated, Key: "issue-1", Time: time.Now(), Issue: &Issue{ID: "issue-1", Private: private}}
+		if err := handler.Handle(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.deliveries) != 0 {
+		t.Errorf("delivered private issues: %v", store.deliveries)
+	}
+}
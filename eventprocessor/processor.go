@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"shared"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Handler reacts to domain events. Handlers must tolerate seeing an event
+// again if processing of its record failed part way and was retried.
+type Handler interface {
+	Handle(event *Event) error
+}
+
+// HandlerFunc adapts a plain function to a Handler.
+type HandlerFunc func(event *Event) error
+
+func (f HandlerFunc) Handle(event *Event) error {
+	return f(event)
+}
+
+// ProcessedStore remembers which stream records were fully processed.
+type ProcessedStore interface {
+	IsProcessed(key string) (bool, error)
+	MarkProcessed(key string) error
+}
+
+// Processor turns stream records into events and dispatches them to the
+// handlers registered for their type.
+type Processor struct {
+	store    ProcessedStore
+	handlers map[EventType][]Handler
+}
+
+func NewProcessor(store ProcessedStore) *Processor {
+	return &Processor{store: store, handlers: map[EventType][]Handler{}}
+}
+
+// Register makes the handler receive every event of the given types.
+func (p *Processor) Register(handler Handler, types ...EventType) {
+	for _, eventType := range types {
+		p.handlers[eventType] = append(p.handlers[eventType], handler)
+	}
+}
+
+// Process handles a batch of stream records in order. Records that were
+// already processed are skipped, so a batch retried by Lambda after a failure
+// only re-runs the records from the failed one onwards.
+func (p *Processor) Process(streamEvent events.DynamoDBEvent) error {
+	for _, record := range streamEvent.Records {
+		key := recordKey(record)
+		processed, err := p.store.IsProcessed(key)
+		if err != nil {
+			return err
+		}
+		if processed {
+			shared.Log.Debugf("Skipping already processed record %s", key)
+			continue
+		}
+		recordEvents, err := toEvents(record)
+		if err != nil {
+			return fmt.Errorf("could not decode record %s: %s", key, err)
+		}
+		for _, event := range recordEvents {
+			for _, handler := range p.handlers[event.Type] {
+				if err := handler.Handle(event); err != nil {
+					return fmt.Errorf("could not handle %s from record %s: %s", event.Type, key, err)
+				}
+			}
+		}
+		if err := p.store.MarkProcessed(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryProcessedStore keeps processed keys in memory, for tests and replays.
+type MemoryProcessedStore struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func NewMemoryProcessedStore() *MemoryProcessedStore {
+	return &MemoryProcessedStore{keys: map[string]bool{}}
+}
+
+func (s *MemoryProcessedStore) IsProcessed(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[key], nil
+}
+
+func (s *MemoryProcessedStore) MarkProcessed(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = true
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"shared"
+	"strings"
+	"time"
+)
+
+// Webhook event names, as registered by partners and sent in the payload.
+const (
+	webhookIssueCreated  = "issue.created"
+	webhookIssueUpdated  = "issue.updated"
+	webhookIssueResolved = "issue.resolved"
+)
+
+const (
+	deliveryPending    = "pending"
+	deliveryDelivered  = "delivered"
+	deliveryDeadLetter = "deadletter"
+	// deliveryRetryKey is the RetryKey of pending deliveries, the partition
+	// key of the index their retries are queued in.
+	deliveryRetryKey = "pending"
+)
+
+const (
+	// WebhookSignatureHeader carries "sha256=" followed by the hex HMAC-SHA256
+	// of the request body, keyed with the secret of the webhook.
+	WebhookSignatureHeader = "X-HumanUnited-Signature"
+	WebhookEventHeader     = "X-HumanUnited-Event"
+	WebhookDeliveryHeader  = "X-HumanUnited-Delivery"
+	webhookTimeout         = 5 * time.Second
+	webhookMaxAttempts     = 5
+	// webhookBaseDelay is the delay before the first retry, which doubles
+	// with every attempt. Retries run once a minute.
+	webhookBaseDelay = time.Minute
+	// webhookRetryLease is how long a retry run holds a delivery it is
+	// attempting, so that an overlapping run skips it.
+	webhookRetryLease = time.Minute
+	// webhookDeliveryTTL is how long the delivery log is kept.
+	webhookDeliveryTTL = 30 * 24 * time.Hour
+)
+
+// Webhook is an endpoint registered by a partner organization. Empty
+// Categories, Locations or Events match everything.
+type Webhook struct {
+	ID         string   `json:"id" dynamodbav:"Id"`
+	URL        string   `json:"url" dynamodbav:"Url"`
+	Secret     string   `json:"secret" dynamodbav:"Secret"`
+	Categories []string `json:"categories" dynamodbav:"Categories"`
+	Locations  []string `json:"locations" dynamodbav:"Locations"`
+	Events     []string `json:"events" dynamodbav:"Events"`
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Webhook) matches(eventName string, issue *Issue) bool {
+	return matchesAny(w.Events, eventName) && matchesAny(w.Categories, issue.Category) && matchesAny(w.Locations, issue.Location)
+}
+
+// WebhookPayload is the JSON body posted to webhooks.
+type WebhookPayload struct {
+	DeliveryID string      `json:"deliveryid"`
+	Event      string      `json:"event"`
+	Time       shared.Time `json:"time"`
+	Issue      *Issue      `json:"issue"`
+}
+
+// Delivery is an entry of the delivery log of a webhook. Failed deliveries
+// stay pending until their next attempt, and those that still failed after
+// the last attempt are kept with the deadletter status.
+type Delivery struct {
+	WebhookID      string      `json:"webhookid" dynamodbav:"WebhookId"`
+	DeliveryID     string      `json:"deliveryid" dynamodbav:"DeliveryId"`
+	Event          string      `json:"event" dynamodbav:"Event"`
+	IssueID        string      `json:"issueid" dynamodbav:"IssueId"`
+	Status         string      `json:"status" dynamodbav:"Status"`
+	Attempts       int         `json:"attempts" dynamodbav:"Attempts"`
+	ResponseStatus int         `json:"responsestatus" dynamodbav:"ResponseStatus"`
+	LastError      string      `json:"lasterror" dynamodbav:"LastError,omitempty"`
+	Payload        string      `json:"payload" dynamodbav:"Payload"`
+	Created        shared.Time `json:"created" dynamodbav:"Created"`
+	// RetryKey and NextAttempt are only set on pending deliveries, which
+	// keeps the others out of RetryIndex.
+	RetryKey    string      `json:"-" dynamodbav:"RetryKey,omitempty"`
+	NextAttempt shared.Time `json:"nextattempt" dynamodbav:"NextAttempt,omitempty"`
+	ExpiresAt   int64       `json:"expiresat" dynamodbav:"ExpiresAt"`
+}
+
+// WebhookStore loads webhooks and records their deliveries.
+type WebhookStore interface {
+	Webhooks() ([]*Webhook, error)
+	// Webhook returns nil if the webhook does not exist.
+	Webhook(webhookID string) (*Webhook, error)
+	Delivery(webhookID string, deliveryID string) (*Delivery, error)
+	SaveDelivery(delivery *Delivery) error
+	// DueDeliveries returns the pending deliveries whose next attempt is at
+	// or before now.
+	DueDeliveries(now time.Time) ([]*Delivery, error)
+	// ClaimDelivery moves the next attempt of a pending delivery to until,
+	// and reports false if another run claimed or saved it since it was read.
+	ClaimDelivery(delivery *Delivery, until time.Time) (bool, error)
+}
+
+// WebhookHandler delivers issue events to the webhooks of partner
+// organizations. Each delivery is attempted once from the stream; failed
+// ones are queued and retried with exponential backoff by RetryDeliveries,
+// and end up in the delivery log as dead letters. They never fail or hold up
+// the stream.
+type WebhookHandler struct {
+	Store       WebhookStore
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+func NewWebhookHandler(store WebhookStore) *WebhookHandler {
+	return &WebhookHandler{
+		Store:       store,
+		Client:      &http.Client{Timeout: webhookTimeout},
+		MaxAttempts: webhookMaxAttempts,
+		BaseDelay:   webhookBaseDelay,
+		now:         time.Now,
+	}
+}
+
+// webhookEventName returns the webhook event an event is delivered as, if any.
+func webhookEventName(event *Event) string {
+	switch event.Type {
+	case IssueCreated:
+		return webhookIssueCreated
+	case IssueResolved:
+		return webhookIssueResolved
+	case IssueUpdated:
+		// Resolving an issue is only delivered as issue.resolved.
+		if event.Issue.StatusMsg == statusResolved && event.OldIssue.StatusMsg != statusResolved {
+			return ""
+		}
+		return webhookIssueUpdated
+	}
+	return ""
+}
+
+func (h *WebhookHandler) Handle(event *Event) error {
+	eventName := webhookEventName(event)
+	if eventName == "" || event.Issue.Private != 0 {
+		return nil
+	}
+	webhooks, err := h.Store.Webhooks()
+	if err != nil {
+		return err
+	}
+	deliveryID := event.Time.UTC().Format("20060102150405.000000000") + "-" + event.Key + "-" + eventName
+	for _, webhook := range webhooks {
+		if !webhook.matches(eventName, event.Issue) {
+			continue
+		}
+		// A redelivered stream record must not be posted again.
+		previous, err := h.Store.Delivery(webhook.ID, deliveryID)
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			continue
+		}
+		payload, err := json.Marshal(&WebhookPayload{
+			DeliveryID: deliveryID,
+			Event:      eventName,
+			Time:       shared.Time{Time: event.Time},
+			Issue:      event.Issue,
+		})
+		if err != nil {
+			return err
+		}
+		delivery := &Delivery{
+			WebhookID:  webhook.ID,
+			DeliveryID: deliveryID,
+			Event:      eventName,
+			IssueID:    event.Issue.ID,
+			Payload:    string(payload),
+			Created:    shared.Time{Time: event.Time},
+			ExpiresAt:  event.Time.Add(webhookDeliveryTTL).Unix(),
+		}
+		h.attempt(webhook, delivery)
+		if err := h.Store.SaveDelivery(delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetryDeliveries attempts the pending deliveries that are due once more. It
+// runs on a schedule, apart from the stream.
+func (h *WebhookHandler) RetryDeliveries() error {
+	now := h.now()
+	deliveries, err := h.Store.DueDeliveries(now)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		claimed, err := h.Store.ClaimDelivery(delivery, now.Add(webhookRetryLease))
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		webhook, err := h.Store.Webhook(delivery.WebhookID)
+		if err != nil {
+			return err
+		}
+		if webhook == nil {
+			delivery.LastError = "the webhook was deleted"
+			h.deadLetter(delivery)
+		} else {
+			h.attempt(webhook, delivery)
+		}
+		if err := h.Store.SaveDelivery(delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sign returns the value of the signature header for a body.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// attempt posts the payload of a delivery once. A failed delivery is queued
+// for a retry after a delay doubling with every attempt, unless the attempts
+// ran out or the webhook answered with a client error other than 408 and 429.
+func (h *WebhookHandler) attempt(webhook *Webhook, delivery *Delivery) {
+	delivery.Attempts++
+	status, err := h.post(webhook, delivery.DeliveryID, delivery.Event, []byte(delivery.Payload))
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status = deliveryDelivered
+		delivery.LastError = ""
+		delivery.RetryKey, delivery.NextAttempt = "", shared.Time{}
+		return
+	}
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= h.MaxAttempts ||
+		status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+		h.deadLetter(delivery)
+		return
+	}
+	delivery.Status = deliveryPending
+	delivery.RetryKey = deliveryRetryKey
+	delivery.NextAttempt = shared.Time{Time: h.now().Add(h.BaseDelay << uint(delivery.Attempts-1))}
+}
+
+func (h *WebhookHandler) deadLetter(delivery *Delivery) {
+	delivery.Status = deliveryDeadLetter
+	delivery.RetryKey, delivery.NextAttempt = "", shared.Time{}
+	shared.Log.With("error", delivery.LastError).Warnf("Dead-lettered %s delivery %s to webhook %s", delivery.Event, delivery.DeliveryID, delivery.WebhookID)
+}
+
+func (h *WebhookHandler) post(webhook *Webhook, deliveryID string, eventName string, payload []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookSignatureHeader, sign(webhook.Secret, payload))
+	request.Header.Set(WebhookEventHeader, eventName)
+	request.Header.Set(WebhookDeliveryHeader, deliveryID)
+	response, err := h.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"fmt"
+	"shared"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
@@ -13,21 +12,6 @@ var db *dynamodb.DynamoDB
 
 const testTable = "TestTable"
 
-func createDBConnection(env string, endpoint string) {
-	if env == "AWS_SAM_LOCAL" {
-		sess, err := session.NewSession(&aws.Config{
-			Region:   aws.String("ap-south-1"),
-			Endpoint: aws.String(endpoint)})
-		if err != nil {
-			fmt.Println("Failed to create dynamodb session")
-
-		}
-		db = dynamodb.New(sess)
-	} else {
-		db = dynamodb.New(session.New(), aws.NewConfig().WithRegion("ap-south-1"))
-	}
-}
-
 func getItems() ([]*Test, error) {
 	input := &dynamodb.ScanInput{
 		TableName: aws.String("TestTable"),
@@ -40,11 +24,10 @@ func getItems() ([]*Test, error) {
 	if len(result.Items) == 0 {
 		return nil, nil
 	}
-	fmt.Println(result)
+	shared.Log.Debugf("Scanned %d items of %s", len(result.Items), testTable)
 	issues := make([]*Test, 0)
 	for _, i := range result.Items {
 		issue := new(Test)
-		fmt.Println(i)
 		err = dynamodbattribute.UnmarshalMap(i, &issue)
 
 		if err != nil {
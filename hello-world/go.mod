@@ -2,8 +2,11 @@ require (
 	github.com/aws/aws-lambda-go v1.13.3
 	github.com/aws/aws-sdk-go v1.34.18
 	github.com/google/uuid v1.1.2
+	shared v0.0.0
 )
 
+replace shared => ../shared
+
 module hello-world
 
 go 1.14
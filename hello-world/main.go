@@ -2,9 +2,8 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
+	"shared"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -23,8 +22,7 @@ func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 	case "POST":
 		return insert(req)
 	default:
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed,
-			Body: http.StatusText(http.StatusMethodNotAllowed)}, nil
+		return shared.Status(http.StatusMethodNotAllowed), nil
 	}
 }
 
@@ -33,23 +31,10 @@ func fetch(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespons
 	if err != nil {
 		//See if we can pass err instead
 
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadGateway,
-			Body: err.Error()}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
 	}
-	fmt.Println(issues)
-	issues_json, err := json.Marshal(issues)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Body:       http.StatusText(http.StatusInternalServerError)}, nil
-	}
-	headers := map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept",
-		"Access-Control-Allow-Methods": "OPTIONS,POST,GET"}
-	return events.APIGatewayProxyResponse{
-		Body:       string(issues_json),
-		Headers:    headers,
-		StatusCode: 201,
-	}, nil
+	shared.Log.Debugf("Fetched %d test items", len(issues))
+	return shared.JSON(http.StatusCreated, issues), nil
 }
 
 func insert(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -63,39 +48,27 @@ func insert(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 	// 	User:     "Viggy",
 	// 	Location: "Bangalore",
 	// }
-	if request.Headers["content-type"] != "application/json" && request.Headers["Content-Type"] != "application/json" {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotAcceptable,
-			Body: http.StatusText(http.StatusNotAcceptable)}, nil
+	if !shared.IsJSON(request) {
+		return shared.Status(http.StatusNotAcceptable), nil
 	}
 	issue := new(Test)
-	fmt.Println(request.Body)
 	err := json.Unmarshal([]byte(request.Body), issue)
 	issue.ID = uuid.New().String()
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
-			Body: http.StatusText(http.StatusBadRequest)}, nil
+		return shared.Status(http.StatusBadRequest), nil
 	}
-	fmt.Println(issue)
+	shared.Log.Debugf("Storing test item %s", issue.ID)
 	err = putItem(issue)
 	if err != nil {
 		//See if we can pass err instead
 
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadGateway,
-			Body: err.Error()}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
 	}
-	headers := map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept",
-		"Access-Control-Allow-Methods": "OPTIONS,POST,GET"}
 
-	return events.APIGatewayProxyResponse{
-		Body:       fmt.Sprintf("Successfully stored the entry"),
-		Headers:    headers,
-		StatusCode: 201,
-	}, nil
+	return shared.Text(http.StatusCreated, "Successfully stored the entry"), nil
 }
 
 func main() {
-	env := os.Getenv("AWSENV")
-	dbEndpoint := os.Getenv("DBENDPOINT")
-	createDBConnection(env, dbEndpoint)
-	lambda.Start(router)
+	db = shared.MustNewDynamoDB(shared.MustLoadConfig())
+	lambda.Start(shared.Logged(shared.CORS(router)))
 }
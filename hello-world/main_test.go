@@ -1,64 +1,38 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
-	"net/http/httptest"
+	"shared"
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
-func TestHandler(t *testing.T) {
-	t.Run("Unable to get IP", func(t *testing.T) {
-		DefaultHTTPGetAddress = "http://127.0.0.1:12345"
-
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid request")
-		}
-	})
-
-	t.Run("Non 200 Response", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
-
-		DefaultHTTPGetAddress = ts.URL
-
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil && err.Error() != ErrNon200Response.Error() {
-			t.Fatalf("Error failed to trigger with an invalid HTTP response: %v", err)
-		}
-	})
-
-	t.Run("Unable decode IP", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
-
-		DefaultHTTPGetAddress = ts.URL
-
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid HTTP response")
-		}
-	})
-
-	t.Run("Successful Request", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(200)
-			fmt.Fprintf(w, "127.0.0.1")
-		}))
-		defer ts.Close()
-
-		DefaultHTTPGetAddress = ts.URL
-
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil {
-			t.Fatal("Everything should be ok")
-		}
-	})
+// TestRouter covers the requests that are answered without the TestTable.
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		status  int
+	}{
+		{"insert without JSON", events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/hello", Body: `{"text": "hi"}`}, http.StatusNotAcceptable},
+		{"insert invalid JSON", events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/hello", Body: `{"text": `,
+			Headers: map[string]string{"Content-Type": "application/json"}}, http.StatusBadRequest},
+		{"unsupported method", events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Path: "/hello"}, http.StatusMethodNotAllowed},
+		{"preflight", events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS", Path: "/hello"}, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := shared.CORS(router)(test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Errorf("status = %d %s, want %d", response.StatusCode, response.Body, test.status)
+			}
+			if response.Headers["Access-Control-Allow-Origin"] != "*" {
+				t.Errorf("missing CORS headers: %v", response.Headers)
+			}
+		})
+	}
 }
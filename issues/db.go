@@ -1,39 +1,46 @@
 package main
 
 import (
-	"fmt"
 	"reflect"
+	"shared"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
 var db *dynamodb.DynamoDB
 
-var IssuesTable = "issues"
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
 
-func createDBConnection(env string, endpoint string) {
-	if env == "AWS_SAM_LOCAL" {
-		sess, err := session.NewSession(&aws.Config{
-			Region:   aws.String("ap-south-1"),
-			Endpoint: aws.String(endpoint)})
-		if err != nil {
-			fmt.Println("Failed to create dynamodb session")
-
-		}
-		db = dynamodb.New(sess)
-	} else {
-		db = dynamodb.New(session.New(), aws.NewConfig().WithRegion("ap-south-1"))
-	}
+// searchIndex is the index the search function finds issues in.
+func searchIndex() *shared.SearchIndex {
+	return &shared.SearchIndex{DB: db, Table: tables.SearchIndex}
 }
 
-func getItems() ([]*Issue, error) {
+// acceptedHelpPoints are the Samaritan Points a helper earns when the owner accepts their help.
+const acceptedHelpPoints = 10
+
+const subscribersIndex = "IssueSubscribersIndex"
+
+const geoIndex = "GeoIndex"
+
+// storiesIndex is the posts index of the posts linked to an issue.
+const storiesIndex = "IssueStoriesIndex"
+
+// DynamoIssueStore keeps issues in the issues table, with their supporters,
+// subscribers and search terms in the tables alongside it.
+type DynamoIssueStore struct{}
+
+func (s *DynamoIssueStore) GetIssues() ([]*Issue, error) {
 	input := &dynamodb.ScanInput{
-		TableName: aws.String(IssuesTable),
+		TableName: aws.String(tables.Issues),
 	}
 	result, err := db.Scan(input)
 	if err != nil {
@@ -56,12 +63,43 @@ func getItems() ([]*Issue, error) {
 	return issues, nil
 }
 
-func updateCommentsForIssue(issueId string, commentData *CommentsRequest) error {
-	fmt.Printf("User %s is provided comment for issue ID %s", commentData.UserID, issueId)
+// GetOpenIssues returns every issue that has not been resolved yet.
+func (s *DynamoIssueStore) GetOpenIssues() ([]*Issue, error) {
+	filt := expression.Name("StatusMsg").NotEqual(expression.Value(statusResolved))
+	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		TableName:                 aws.String(tables.Issues),
+	}
+	issues := make([]*Issue, 0)
+	var unmarshalErr error
+	err = db.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			issue := new(Issue)
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(i, &issue); unmarshalErr != nil {
+				return false
+			}
+			issues = append(issues, issue)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issues, unmarshalErr
+}
+
+func (s *DynamoIssueStore) AddComment(issueId string, commentData *CommentsRequest) error {
+	shared.Log.With("userId", commentData.UserID).Infof("User commented on issue %s", issueId)
 	commentsList := []*CommentsRequest{commentData}
 	commentAVs, err := dynamodbattribute.MarshalList(commentsList)
 	if err != nil {
-		fmt.Printf("Could not Marshal comments list %s", err.Error())
+		shared.Log.WithError(err).Errorf("Could not marshal comments list")
 		return err
 	}
 	input := &dynamodb.UpdateItemInput{
@@ -73,7 +111,7 @@ func updateCommentsForIssue(issueId string, commentData *CommentsRequest) error
 				L: []*dynamodb.AttributeValue{},
 			},
 		},
-		TableName: aws.String(IssuesTable),
+		TableName: aws.String(tables.Issues),
 		Key: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(issueId),
@@ -86,22 +124,25 @@ func updateCommentsForIssue(issueId string, commentData *CommentsRequest) error
 	return err
 }
 
-func updateStatusForIssue(issueId string, statusData *StatusRequest) error {
-	fmt.Printf("Status changed to %s for issue ID %s", statusData.StatusMsg, issueId)
+func (s *DynamoIssueStore) UpdateStatus(issueId string, statusData *StatusRequest) error {
+	shared.Log.Infof("Status changed to %s for issue %s", statusData.StatusMsg, issueId)
 	input := &dynamodb.UpdateItemInput{
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":s": {
 				S: aws.String(statusData.StatusMsg),
 			},
+			":u": {
+				S: aws.String(statusData.UserID),
+			},
 		},
-		TableName: aws.String(IssuesTable),
+		TableName: aws.String(tables.Issues),
 		Key: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(issueId),
 			},
 		},
 		ReturnValues:     aws.String("UPDATED_NEW"),
-		UpdateExpression: aws.String("set StatusMsg = :s"),
+		UpdateExpression: aws.String("set StatusMsg = :s, StatusBy = :u"),
 	}
 
 	_, err := db.UpdateItem(input)
@@ -109,15 +150,15 @@ func updateStatusForIssue(issueId string, statusData *StatusRequest) error {
 
 }
 
-func updateHelpersForIssue(issueId string, helpersData *HelpersRequest) error {
-	fmt.Printf("User %s is providing help for issue ID %s", helpersData.UserName, issueId)
+func (s *DynamoIssueStore) AddHelper(issueId string, helpersData *HelpersRequest) error {
+	shared.Log.Infof("User %s is providing help for issue %s", helpersData.UserName, issueId)
 	input := &dynamodb.UpdateItemInput{
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":h": {
 				S: aws.String(helpersData.UserName),
 			},
 		},
-		TableName: aws.String(IssuesTable),
+		TableName: aws.String(tables.Issues),
 		Key: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(issueId),
@@ -141,7 +182,7 @@ func updateHelpersForIssue(issueId string, helpersData *HelpersRequest) error {
 					M: helperAVs,
 				},
 			},
-			TableName: aws.String(IssuesTable),
+			TableName: aws.String(tables.Issues),
 			Key: map[string]*dynamodb.AttributeValue{
 				"Id": {
 					S: aws.String(issueId),
@@ -155,18 +196,245 @@ func updateHelpersForIssue(issueId string, helpersData *HelpersRequest) error {
 	return err
 }
 
-func getIssueById(issueID string) (*Issue, error) {
+// AddSupport records that a user is affected by an issue and bumps
+// the issue's SupportCount in the same transaction, so each user counts once.
+func (s *DynamoIssueStore) AddSupport(issueId string, userId string) error {
+	shared.Log.With("userId", userId).Infof("User supports issue %s", issueId)
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(tables.IssueSupport),
+					Item: map[string]*dynamodb.AttributeValue{
+						"IssueId": {
+							S: aws.String(issueId),
+						},
+						"UserId": {
+							S: aws.String(userId),
+						},
+						"Created": {
+							S: aws.String(shared.FormatTime(time.Now())),
+						},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(UserId)"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tables.Issues),
+					Key: map[string]*dynamodb.AttributeValue{
+						"Id": {
+							S: aws.String(issueId),
+						},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":one": {
+							N: aws.String("1"),
+						},
+						":zero": {
+							N: aws.String("0"),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(Id) and Personal = :zero"),
+					UpdateExpression:    aws.String("ADD SupportCount :one"),
+				},
+			},
+		},
+	}
+	_, err := db.TransactWriteItems(input)
+	if alreadyDone(err) {
+		// The user already supports the issue.
+		return nil
+	}
+	return err
+}
+
+// RemoveSupport undoes AddSupport.
+func (s *DynamoIssueStore) RemoveSupport(issueId string, userId string) error {
+	shared.Log.With("userId", userId).Infof("User no longer supports issue %s", issueId)
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(tables.IssueSupport),
+					Key: map[string]*dynamodb.AttributeValue{
+						"IssueId": {
+							S: aws.String(issueId),
+						},
+						"UserId": {
+							S: aws.String(userId),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(UserId)"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tables.Issues),
+					Key: map[string]*dynamodb.AttributeValue{
+						"Id": {
+							S: aws.String(issueId),
+						},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":minusone": {
+							N: aws.String("-1"),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(Id)"),
+					UpdateExpression:    aws.String("ADD SupportCount :minusone"),
+				},
+			},
+		},
+	}
+	_, err := db.TransactWriteItems(input)
+	if alreadyDone(err) {
+		// The user did not support the issue.
+		return nil
+	}
+	return err
+}
+
+// AcceptHelper marks a helper's offer as accepted and awards them
+// Samaritan Points in the same transaction. Accepting a helper twice changes
+// nothing.
+func (s *DynamoIssueStore) AcceptHelper(issueId string, helperId string) error {
+	shared.Log.Infof("Help of user %s accepted for issue %s", helperId, issueId)
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tables.Issues),
+					Key: map[string]*dynamodb.AttributeValue{
+						"Id": {
+							S: aws.String(issueId),
+						},
+					},
+					ExpressionAttributeNames: map[string]*string{
+						"#h": aws.String(helperId),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":a": {
+							SS: []*string{aws.String(helperId)},
+						},
+						":h": {
+							S: aws.String(helperId),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(Helpers.#h) and not contains(Accepted, :h)"),
+					UpdateExpression:    aws.String("ADD Accepted :a"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tables.Users),
+					Key: map[string]*dynamodb.AttributeValue{
+						"Id": {
+							S: aws.String(helperId),
+						},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":p": {
+							N: aws.String(strconv.Itoa(acceptedHelpPoints)),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(Id)"),
+					UpdateExpression:    aws.String("ADD SamaritanPoints :p"),
+				},
+			},
+		},
+	}
+	_, err := db.TransactWriteItems(input)
+	if alreadyDone(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *DynamoIssueStore) Subscribe(issueId string, userId string) error {
+	shared.Log.With("userId", userId).Infof("User subscribed to issue %s", issueId)
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(tables.Subscriptions),
+		Item: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+			"IssueId": {
+				S: aws.String(issueId),
+			},
+			"Created": {
+				S: aws.String(shared.FormatTime(time.Now())),
+			},
+		},
+	}
+	_, err := db.PutItem(input)
+	return err
+}
+
+func (s *DynamoIssueStore) Unsubscribe(issueId string, userId string) error {
+	shared.Log.With("userId", userId).Infof("User unsubscribed from issue %s", issueId)
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(tables.Subscriptions),
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+			"IssueId": {
+				S: aws.String(issueId),
+			},
+		},
+	}
+	_, err := db.DeleteItem(input)
+	return err
+}
+
+// Subscribers returns the ids of the users following an issue.
+func (s *DynamoIssueStore) Subscribers(issueId string) ([]string, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.Subscriptions),
+		IndexName:              aws.String(subscribersIndex),
+		KeyConditionExpression: aws.String("IssueId = :i"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":i": {
+				S: aws.String(issueId),
+			},
+		},
+	}
+	userIds := make([]string, 0)
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			if userId := i["UserId"]; userId != nil && userId.S != nil {
+				userIds = append(userIds, *userId.S)
+			}
+		}
+		return true
+	})
+	return userIds, err
+}
+
+// alreadyDone reports whether a transaction was cancelled only because its
+// first item's condition failed, i.e. the change had already been made.
+func alreadyDone(err error) bool {
+	cancelled, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok || len(cancelled.CancellationReasons) == 0 {
+		return false
+	}
+	first := cancelled.CancellationReasons[0]
+	return first.Code != nil && *first.Code == "ConditionalCheckFailed"
+}
+
+func (s *DynamoIssueStore) GetIssue(issueID string) (*Issue, error) {
 	input := &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(issueID),
 			},
 		},
-		TableName: aws.String(IssuesTable),
+		TableName: aws.String(tables.Issues),
 	}
 	result, err := db.GetItem(input)
 	if err != nil {
-		fmt.Printf("Failed to get Item from table %s for %s", IssuesTable, issueID)
+		shared.Log.WithError(err).Errorf("Failed to get Item from table %s for %s", tables.Issues, issueID)
 		return nil, err
 	}
 	if result == nil {
@@ -183,9 +451,40 @@ func getIssueById(issueID string) (*Issue, error) {
 	return issue, nil
 }
 
-func putItem(issue *Issue) error {
+// GetStories returns the posts linked to an issue, newest first.
+func (s *DynamoIssueStore) GetStories(issueId string) ([]*Story, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.Posts),
+		IndexName:              aws.String(storiesIndex),
+		KeyConditionExpression: aws.String("IssueId = :i"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":i": {
+				S: aws.String(issueId),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	stories := make([]*Story, 0)
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			story := new(Story)
+			if err := dynamodbattribute.UnmarshalMap(i, story); err != nil {
+				shared.Log.WithError(err).Warnf("Skipping story that could not be unmarshalled")
+				continue
+			}
+			stories = append(stories, story)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stories, nil
+}
+
+func (s *DynamoIssueStore) PutIssue(issue *Issue) error {
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(IssuesTable),
+		TableName: aws.String(tables.Issues),
 		Item: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(issue.ID),
@@ -194,7 +493,7 @@ func putItem(issue *Issue) error {
 				S: aws.String(issue.Title),
 			},
 			"Created": {
-				S: aws.String(issue.Created),
+				S: aws.String(issue.Created.String()),
 			},
 			"Body": {
 				S: aws.String(issue.Body),
@@ -205,6 +504,9 @@ func putItem(issue *Issue) error {
 			"Location": {
 				S: aws.String(issue.Location),
 			},
+			"Category": {
+				S: aws.String(issue.Category),
+			},
 			"UserID": {
 				S: aws.String(issue.UserID),
 			},
@@ -220,6 +522,58 @@ func putItem(issue *Issue) error {
 		},
 	}
 
+	if issue.Latitude != nil && issue.Longitude != nil {
+		geoHash := encodeGeoHash(*issue.Latitude, *issue.Longitude, geoHashPrecision)
+		input.Item["Latitude"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(*issue.Latitude, 'f', -1, 64))}
+		input.Item["Longitude"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(*issue.Longitude, 'f', -1, 64))}
+		input.Item["GeoHash"] = &dynamodb.AttributeValue{S: aws.String(geoHash)}
+		input.Item["GeoKey"] = &dynamodb.AttributeValue{S: aws.String(geoHash[:geoKeyPrecision])}
+	}
+
 	_, err := db.PutItem(input)
-	return err
+	if err != nil {
+		return err
+	}
+	// Private issues are kept out of search.
+	if issue.Private == 0 {
+		if err := searchIndex().Index("issue", issue.ID, issue.Created, issue.Title, issue.Body); err != nil {
+			shared.Log.WithError(err).Errorf("Failed to index issue %s", issue.ID)
+		}
+	}
+	return nil
+}
+
+// GetIssuesByGeoHashes returns the located issues whose geohash starts with any of the given prefixes.
+func (s *DynamoIssueStore) GetIssuesByGeoHashes(prefixes []string) ([]*Issue, error) {
+	issues := make([]*Issue, 0)
+	for _, prefix := range prefixes {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(tables.Issues),
+			IndexName:              aws.String(geoIndex),
+			KeyConditionExpression: aws.String("GeoKey = :k and begins_with(GeoHash, :p)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":k": {
+					S: aws.String(prefix[:geoKeyPrecision]),
+				},
+				":p": {
+					S: aws.String(prefix),
+				},
+			},
+		}
+		err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, i := range page.Items {
+				issue := new(Issue)
+				if err := dynamodbattribute.UnmarshalMap(i, &issue); err != nil {
+					shared.Log.WithError(err).Warnf("Skipping issue that could not be unmarshalled")
+					continue
+				}
+				issues = append(issues, issue)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return issues, nil
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"shared"
+	"sort"
+	"strings"
+)
+
+const (
+	// duplicateThreshold is the cosine similarity above which an open issue is reported as a likely duplicate.
+	duplicateThreshold = 0.5
+	maxDuplicates      = 5
+)
+
+type DuplicateIssue struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	StatusMsg  string  `json:"statusmsg"`
+	Similarity float64 `json:"similarity"`
+}
+
+// similarity returns the cosine similarity of the weighted term vectors of two issues.
+func similarity(a map[string]int, b map[string]int) float64 {
+	var dot, normA, normB float64
+	for term, weight := range a {
+		dot += float64(weight * b[term])
+		normA += float64(weight * weight)
+	}
+	for _, weight := range b {
+		normB += float64(weight * weight)
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// findDuplicates compares an issue against the open, public issues reported
+// in the same location and returns the most similar ones.
+func findDuplicates(store IssueStore, issue *Issue) ([]*DuplicateIssue, error) {
+	duplicates := make([]*DuplicateIssue, 0)
+	if strings.TrimSpace(issue.Location) == "" {
+		return duplicates, nil
+	}
+	candidates, err := store.GetOpenIssues()
+	if err != nil {
+		return nil, err
+	}
+	weights := shared.TermWeights(issue.Title, issue.Body)
+	for _, candidate := range candidates {
+		if candidate.ID == issue.ID || candidate.Private != 0 ||
+			!strings.EqualFold(strings.TrimSpace(candidate.Location), strings.TrimSpace(issue.Location)) {
+			continue
+		}
+		score := similarity(weights, shared.TermWeights(candidate.Title, candidate.Body))
+		if score >= duplicateThreshold {
+			duplicates = append(duplicates, &DuplicateIssue{
+				ID:         candidate.ID,
+				Title:      candidate.Title,
+				StatusMsg:  candidate.StatusMsg,
+				Similarity: score,
+			})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Similarity > duplicates[j].Similarity })
+	if len(duplicates) > maxDuplicates {
+		duplicates = duplicates[:maxDuplicates]
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// geoHashPrecision is the precision of the geohash stored with every located issue.
+	geoHashPrecision = 9
+	// geoKeyPrecision is the length of the geohash prefix used as the GeoIndex partition key.
+	geoKeyPrecision = 3
+	earthRadiusKm   = 6371.0
+	kmPerDegree     = 111.32
+	defaultRadiusKm = 5.0
+	maxRadiusKm     = 50.0
+)
+
+const geoHashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// encodeGeoHash returns the geohash of the given point with the given precision.
+func encodeGeoHash(lat, lng float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+	var hash strings.Builder
+	bit, ch, even := 0, 0, true
+	for hash.Len() < precision {
+		if even {
+			mid := (lngRange[0] + lngRange[1]) / 2
+			if lng >= mid {
+				ch |= 1 << (4 - bit)
+				lngRange[0] = mid
+			} else {
+				lngRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		even = !even
+		if bit < 4 {
+			bit++
+		} else {
+			hash.WriteByte(geoHashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// geoCellSize returns the height and width in degrees of a geohash cell of the given precision.
+func geoCellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	lngBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lngBits))
+}
+
+// coveringGeoHashes returns the geohash prefixes whose cells together cover
+// every point within radiusKm of the given point. It picks the finest
+// precision whose cells are still at least radiusKm on each side, and returns
+// the cell containing the point along with its eight neighbours. Far north or
+// south even cells of geoKeyPrecision, the coarsest that can be queried, are
+// narrower than the radius, and as many more neighbours as it takes are
+// returned.
+func coveringGeoHashes(lat, lng, radiusKm float64) []string {
+	// Cells narrow towards the poles, so they are measured where the circle
+	// comes closest to the pole.
+	edgeLat := math.Min(90, math.Abs(lat)+radiusKm/kmPerDegree)
+	cellKm := func(precision int) (float64, float64) {
+		latDeg, lngDeg := geoCellSize(precision)
+		return latDeg * kmPerDegree, lngDeg * kmPerDegree * math.Cos(edgeLat*math.Pi/180)
+	}
+	precision := geoKeyPrecision
+	for p := geoHashPrecision; p > geoKeyPrecision; p-- {
+		heightKm, widthKm := cellKm(p)
+		if heightKm >= radiusKm && widthKm >= radiusKm {
+			precision = p
+			break
+		}
+	}
+	latDeg, lngDeg := geoCellSize(precision)
+	heightKm, widthKm := cellKm(precision)
+	latRings := int(math.Ceil(radiusKm / heightKm))
+	// Half of the cells around the earth reach all the way round.
+	lngRings := int(math.Round(180 / lngDeg))
+	if rings := math.Ceil(radiusKm / widthKm); rings < float64(lngRings) {
+		lngRings = int(rings)
+	}
+	seen := map[string]bool{}
+	hashes := make([]string, 0, (2*latRings+1)*(2*lngRings+1))
+	for i := -latRings; i <= latRings; i++ {
+		for j := -lngRings; j <= lngRings; j++ {
+			nLat := math.Max(-90, math.Min(90, lat+float64(i)*latDeg))
+			nLng := math.Mod(lng+float64(j)*lngDeg+540, 360) - 180
+			hash := encodeGeoHash(nLat, nLng, precision)
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// distanceKm returns the great-circle distance between two points using the haversine formula.
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+func validCoordinates(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
@@ -2,8 +2,14 @@ require (
 	github.com/aws/aws-lambda-go v1.13.3
 	github.com/aws/aws-sdk-go v1.34.13
 	github.com/google/uuid v1.1.1
+	moderation v0.0.0
+	shared v0.0.0
 )
 
+replace moderation => ../moderation
+
+replace shared => ../shared
+
 module issues
 
 go 1.14
@@ -3,15 +3,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"moderation"
 	"net/http"
-	"os"
-	"time"
+	"shared"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/google/uuid"
 )
 
+const statusResolved = "Resolved"
+
 type CommentsRequest struct {
 	UserID   string `json:"userid"`
 	UserName string `json:"username"`
@@ -24,126 +29,199 @@ type HelpersRequest struct {
 }
 
 type Issue struct {
-	ID        string            `json:"id"`
-	Created   string            `json:"created"`
-	Title     string            `json:"title"`
-	Body      string            `json:"body"`
-	Private   int               `json:"private"`
-	UserID    string            `json:"userid"`
-	UserName  string            `json:"username"`
-	Location  string            `json:"location"`
-	Personal  int               `json:"personal"`
-	Helpers   map[string]string `json:"helpers"`
-	Comments  []CommentsRequest `json:comments`
-	StatusMsg string            `json:"statusmsg"`
+	ID           string            `json:"id"`
+	Created      shared.Time       `json:"created"`
+	Title        string            `json:"title"`
+	Body         string            `json:"body"`
+	Private      int               `json:"private"`
+	UserID       string            `json:"userid"`
+	UserName     string            `json:"username"`
+	Location     string            `json:"location"`
+	Category     string            `json:"category"`
+	Latitude     *float64          `json:"latitude,omitempty"`
+	Longitude    *float64          `json:"longitude,omitempty"`
+	Personal     int               `json:"personal"`
+	Helpers      map[string]string `json:"helpers"`
+	Accepted     []string          `json:"accepted"`
+	SupportCount int               `json:"supportcount"`
+	Comments     []CommentsRequest `json:"Comments"`
+	StatusMsg    string            `json:"statusmsg"`
+	StatusBy     string            `json:"statusby"`
+	Stories      []*Story          `json:"stories,omitempty" dynamodbav:"-"`
 }
 
-type StatusRequest struct {
-	StatusMsg string `json:"statusmsg"`
+// Story is a post about how an issue was resolved.
+type Story struct {
+	ID          string      `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	PostTime    shared.Time `json:"posttime"`
+	UserId      string      `json:"userid"`
+	Tagged      []string    `json:"tagged"`
 }
 
-func getHeaders() map[string]string {
-	return map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept",
-		"Access-Control-Allow-Methods": "OPTIONS,POST,GET"}
+type NearbyIssue struct {
+	*Issue
+	DistanceKm float64 `json:"distancekm"`
 }
 
-func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+type InsertResponse struct {
+	ID         string            `json:"id,omitempty"`
+	Message    string            `json:"message"`
+	Duplicates []*DuplicateIssue `json:"duplicates"`
+}
+
+type IssueUserRequest struct {
+	UserID string `json:"userid"`
+}
+
+type AcceptRequest struct {
+	UserID   string `json:"userid"`
+	HelperID string `json:"helperid"`
+}
+
+type StatusRequest struct {
+	StatusMsg string `json:"statusmsg"`
+	UserID    string `json:"userid"`
+}
+
+func (s *Server) router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// /issues/nearby takes any method so that CORS preflights reach the
+	// function, but is not an issue id to change.
+	if req.Path == "/issues/nearby" && req.HTTPMethod != "GET" {
+		return shared.Status(http.StatusMethodNotAllowed), nil
+	}
 	switch req.HTTPMethod {
 	case "GET":
-		return fetch(req)
+		return s.fetch(req)
 	case "POST":
-		return insert(req)
+		return s.insert(req)
 	case "PUT":
-		return update(req)
-	case "OPTIONS":
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Headers:    getHeaders()}, nil
+		return s.update(req)
+	case "DELETE":
+		return s.remove(req)
 	default:
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed,
-			Headers: getHeaders(),
-			Body:    http.StatusText(http.StatusMethodNotAllowed)}, nil
+		return shared.Status(http.StatusMethodNotAllowed), nil
 	}
 }
-func fetch(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (s *Server) fetch(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.Path == "/issues/nearby" {
+		return s.fetchNearby(request)
+	}
 	if issueID, ok := request.PathParameters["issueId"]; ok {
-		issue, err := getIssueById(issueID)
-		issue_json, err := json.Marshal(issue)
-		if err != nil {
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Headers:    getHeaders(),
-				Body:       http.StatusText(http.StatusInternalServerError)}, nil
-		}
-		return events.APIGatewayProxyResponse{
-			Body:       string(issue_json),
-			Headers:    getHeaders(),
-			StatusCode: 201,
-		}, nil
+		issue, err := s.Store.GetIssue(issueID)
+		if err == nil && issue != nil {
+			issue.Stories, err = s.Store.GetStories(issueID)
+		}
+		if err != nil {
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		if issue == nil {
+			return shared.Status(http.StatusNotFound), nil
+		}
+		return shared.JSON(http.StatusCreated, issue), nil
 	} else {
 
-		issues, err := getItems()
+		sortBy := request.QueryStringParameters["sort"]
+		if sortBy != "" && sortBy != "support" {
+			return shared.Text(http.StatusBadRequest, "sort must be support"), nil
+		}
+		issues, err := s.Store.GetIssues()
 
 		if err != nil {
 			//See if we can pass err instead
-			fmt.Printf("Failed to fetch data %s", err)
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadGateway,
-				Headers: getHeaders(),
-				Body:    err.Error()}, nil
+			shared.Log.WithError(err).Errorf("Failed to fetch issues")
+			return shared.Error(http.StatusBadGateway, err), nil
 		}
-		fmt.Println(issues)
-		issues_json, err := json.Marshal(issues)
-		if err != nil {
+		if sortBy == "support" {
+			sort.SliceStable(issues, func(i, j int) bool { return issues[i].SupportCount > issues[j].SupportCount })
+		}
+		shared.Log.Debugf("Fetched %d issues", len(issues))
+		return shared.JSON(http.StatusCreated, issues), nil
+	}
+
+}
 
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Headers:    getHeaders(),
-				Body:       http.StatusText(http.StatusInternalServerError)}, nil
+func (s *Server) fetchNearby(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	lat, latErr := strconv.ParseFloat(request.QueryStringParameters["lat"], 64)
+	lng, lngErr := strconv.ParseFloat(request.QueryStringParameters["lng"], 64)
+	if latErr != nil || lngErr != nil || !validCoordinates(lat, lng) {
+		return shared.Text(http.StatusBadRequest, "lat and lng must be valid coordinates"), nil
+	}
+	radiusKm := defaultRadiusKm
+	if r, ok := request.QueryStringParameters["radiusKm"]; ok {
+		parsed, err := strconv.ParseFloat(r, 64)
+		if err != nil || parsed <= 0 || parsed > maxRadiusKm {
+			return shared.Text(http.StatusBadRequest, fmt.Sprintf("radiusKm must be between 0 and %v", maxRadiusKm)), nil
+		}
+		radiusKm = parsed
+	}
+
+	candidates, err := s.Store.GetIssuesByGeoHashes(coveringGeoHashes(lat, lng, radiusKm))
+	if err != nil {
+		shared.Log.WithError(err).Errorf("Failed to fetch nearby issues")
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	nearby := make([]*NearbyIssue, 0)
+	for _, issue := range candidates {
+		// Private issues are never shown to people browsing the area.
+		if issue.Private != 0 || issue.Latitude == nil || issue.Longitude == nil {
+			continue
+		}
+		distance := distanceKm(lat, lng, *issue.Latitude, *issue.Longitude)
+		if distance <= radiusKm {
+			nearby = append(nearby, &NearbyIssue{Issue: issue, DistanceKm: distance})
 		}
-		fmt.Println("Success")
-		return events.APIGatewayProxyResponse{
-			Body:       string(issues_json),
-			Headers:    getHeaders(),
-			StatusCode: 201,
-		}, nil
 	}
+	sort.Slice(nearby, func(i, j int) bool { return nearby[i].DistanceKm < nearby[j].DistanceKm })
 
+	return shared.JSON(http.StatusOK, nearby), nil
 }
 
-func insert(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	if request.Headers["content-type"] != "application/json" && request.Headers["Content-Type"] != "application/json" {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotAcceptable,
-			Headers: getHeaders(),
-			Body:    http.StatusText(http.StatusNotAcceptable)}, nil
+func (s *Server) insert(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !shared.IsJSON(request) {
+		return shared.Status(http.StatusNotAcceptable), nil
 	}
 	issue := new(Issue)
 	issue.Personal = 1
 	issue.StatusMsg = "Need Help"
 	err := json.Unmarshal([]byte(request.Body), issue)
 	issue.ID = uuid.New().String()
-	issue.Created = time.Now().Local().String()
+	issue.Created = shared.Now()
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
-			Headers: getHeaders(),
-			Body:    http.StatusText(http.StatusBadRequest)}, nil
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	issue.Category = strings.ToLower(strings.TrimSpace(issue.Category))
+	if (issue.Latitude == nil) != (issue.Longitude == nil) ||
+		(issue.Latitude != nil && !validCoordinates(*issue.Latitude, *issue.Longitude)) {
+		return shared.Text(http.StatusBadRequest, "latitude and longitude must be given together and be valid coordinates"), nil
 	}
-	err = putItem(issue)
+	duplicates, err := findDuplicates(s.Store, issue)
 	if err != nil {
-		//See if we can pass err instead
+		// Duplicate detection is only a hint, so an issue is still stored without it.
+		shared.Log.WithError(err).Warnf("Failed to check for duplicate issues")
+		duplicates = make([]*DuplicateIssue, 0)
+	}
+	response := &InsertResponse{Duplicates: duplicates}
+	statusCode := 201
+	if request.QueryStringParameters["checkDuplicates"] == "true" {
+		response.Message = "Dry run, the entry was not stored"
+		statusCode = 200
+	} else {
+		err = s.Store.PutIssue(issue)
+		if err != nil {
+			//See if we can pass err instead
 
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadGateway,
-			Headers: getHeaders(),
-			Body:    err.Error()}, nil
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		response.ID = issue.ID
+		response.Message = "Successfully stored the entry"
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       fmt.Sprintf("Successfully stored the entry"),
-		Headers:    getHeaders(),
-		StatusCode: 201,
-	}, nil
+	return shared.JSON(statusCode, response), nil
 }
 
-func update(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (s *Server) update(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	issueId := request.PathParameters["issueId"]
 	field := request.PathParameters["field"]
 	switch field {
@@ -151,67 +229,152 @@ func update(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 		commentReq := new(CommentsRequest)
 		err := json.Unmarshal([]byte(request.Body), commentReq)
 		if err != nil {
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
-				Headers: getHeaders(),
-				Body:    http.StatusText(http.StatusBadRequest)}, nil
+			return shared.Status(http.StatusBadRequest), nil
+		}
+		commentReq.Comment, err = moderation.CheckComment(commentReq.UserID, commentReq.Comment)
+		if err != nil {
+			return shared.Error(http.StatusBadRequest, err), nil
 		}
-		err = updateCommentsForIssue(issueId, commentReq)
+		err = s.Store.AddComment(issueId, commentReq)
 		if err != nil {
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Headers:    getHeaders(),
-				Body:       err.Error()}, nil
+			return shared.Error(http.StatusInternalServerError, err), nil
 		}
 
 	case "help":
 		helperReq := new(HelpersRequest)
 		err := json.Unmarshal([]byte(request.Body), helperReq)
 		if err != nil {
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
-				Headers: getHeaders(),
-				Body:    http.StatusText(http.StatusBadRequest)}, nil
+			return shared.Status(http.StatusBadRequest), nil
 		}
-		err = updateHelpersForIssue(issueId, helperReq)
+		err = s.Store.AddHelper(issueId, helperReq)
 		if err != nil {
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Headers:    getHeaders(),
-				Body:       err.Error()}, nil
+			return shared.Error(http.StatusInternalServerError, err), nil
+		}
+	case "support":
+		userReq := new(IssueUserRequest)
+		err := json.Unmarshal([]byte(request.Body), userReq)
+		if err != nil || userReq.UserID == "" {
+			return shared.Status(http.StatusBadRequest), nil
+		}
+		if resp, ok := s.checkSupportable(issueId); !ok {
+			return resp, nil
+		}
+		err = s.Store.AddSupport(issueId, userReq.UserID)
+		if err != nil {
+			return shared.Error(http.StatusInternalServerError, err), nil
+		}
+	case "accept":
+		acceptReq := new(AcceptRequest)
+		err := json.Unmarshal([]byte(request.Body), acceptReq)
+		if err != nil || acceptReq.HelperID == "" {
+			return shared.Status(http.StatusBadRequest), nil
+		}
+		issue, err := s.Store.GetIssue(issueId)
+		if err != nil {
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		if issue == nil {
+			return shared.Status(http.StatusNotFound), nil
+		}
+		if issue.UserID != acceptReq.UserID {
+			return shared.Text(http.StatusForbidden, "Only the owner of the issue can accept help"), nil
+		}
+		if _, ok := issue.Helpers[acceptReq.HelperID]; !ok {
+			return shared.Text(http.StatusBadRequest, "The user has not offered help on this issue"), nil
+		}
+		err = s.Store.AcceptHelper(issueId, acceptReq.HelperID)
+		if err != nil {
+			return shared.Error(http.StatusInternalServerError, err), nil
+		}
+	case "subscription":
+		userReq := new(IssueUserRequest)
+		err := json.Unmarshal([]byte(request.Body), userReq)
+		if err != nil || userReq.UserID == "" {
+			return shared.Status(http.StatusBadRequest), nil
+		}
+		issue, err := s.Store.GetIssue(issueId)
+		if err != nil {
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		if issue == nil {
+			return shared.Status(http.StatusNotFound), nil
+		}
+		err = s.Store.Subscribe(issueId, userReq.UserID)
+		if err != nil {
+			return shared.Error(http.StatusInternalServerError, err), nil
 		}
 	case "status":
 		statusReq := new(StatusRequest)
 		err := json.Unmarshal([]byte(request.Body), statusReq)
 		if err != nil {
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
-				Headers: getHeaders(),
-				Body:    http.StatusText(http.StatusBadRequest)}, nil
+			return shared.Status(http.StatusBadRequest), nil
+		}
+		err = s.Store.UpdateStatus(issueId, statusReq)
+		if err != nil {
+			return shared.Text(http.StatusInternalServerError, "Failed to update status for issue"), nil
 		}
-		err = updateStatusForIssue(issueId, statusReq)
+	default:
+		return shared.Text(http.StatusBadRequest, "Invalid request parameters"), nil
+	}
+
+	return shared.Text(http.StatusCreated, fmt.Sprintf("Successfully updated the Issue")), nil
+}
+
+func (s *Server) remove(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	issueId := request.PathParameters["issueId"]
+	field := request.PathParameters["field"]
+	// DELETE bodies are often dropped by clients, so the user may also be given as a query parameter.
+	userReq := &IssueUserRequest{UserID: request.QueryStringParameters["userid"]}
+	if request.Body != "" {
+		if err := json.Unmarshal([]byte(request.Body), userReq); err != nil {
+			return shared.Status(http.StatusBadRequest), nil
+		}
+	}
+	if userReq.UserID == "" {
+		return shared.Text(http.StatusBadRequest, "userid is required"), nil
+	}
+	switch field {
+	case "support":
+		err := s.Store.RemoveSupport(issueId, userReq.UserID)
+		if err != nil {
+			return shared.Error(http.StatusInternalServerError, err), nil
+		}
+	case "subscription":
+		err := s.Store.Unsubscribe(issueId, userReq.UserID)
 		if err != nil {
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Headers:    getHeaders(),
-				Body:       "Failed to update status for issue"}, nil
+			return shared.Error(http.StatusInternalServerError, err), nil
 		}
 	default:
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusBadRequest,
-			Headers:    getHeaders(),
-			Body:       "Invalid request parameters"}, nil
+		return shared.Text(http.StatusBadRequest, "Invalid request parameters"), nil
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       fmt.Sprintf("Successfully updated the Issue"),
-		Headers:    getHeaders(),
-		StatusCode: 201,
-	}, nil
+	return shared.Text(http.StatusOK, fmt.Sprintf("Successfully updated the Issue")), nil
+}
+
+// checkSupportable makes sure the issue exists and is a community issue,
+// returning the error response to send otherwise.
+func (s *Server) checkSupportable(issueId string) (events.APIGatewayProxyResponse, bool) {
+	issue, err := s.Store.GetIssue(issueId)
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), false
+	}
+	if issue == nil {
+		return shared.Status(http.StatusNotFound), false
+	}
+	if issue.Personal != 0 {
+		return shared.Text(http.StatusBadRequest, "Only community issues can be supported"), false
+	}
+	return events.APIGatewayProxyResponse{}, true
 }
 
 //Add put for discussion and status
 func main() {
-	//get parameters here from environment
-	env := os.Getenv("AWSENV")
-	dbEndpoint := os.Getenv("DBENDPOINT")
-	createDBConnection(env, dbEndpoint)
-	lambda.Start(router)
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	var store IssueStore = NewMemoryIssueStore()
+	if !cfg.Memory() {
+		db = shared.MustNewDynamoDB(cfg)
+		store = &DynamoIssueStore{}
+	}
+	lambda.Start(shared.Logged(shared.CORS(NewServer(store).router)))
 }
@@ -1,64 +1,428 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"math"
 	"net/http"
-	"net/http/httptest"
+	"shared"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
-func TestHandler(t *testing.T) {
-	t.Run("Unable to get IP", func(t *testing.T) {
-		DefaultHTTPGetAddress = "http://127.0.0.1:12345"
+func float(f float64) *float64 {
+	return &f
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid request")
-		}
-	})
+// newTestStore returns a store with a community issue in Bangalore that has
+// a helper, a personal issue, a private one nearby and a resolved issue with
+// a story.
+func newTestStore() *MemoryIssueStore {
+	store := NewMemoryIssueStore()
+	store.PutIssue(&Issue{ID: "community", Title: "Streetlight broken on 5th cross", Body: "The streetlight has been out for a week",
+		UserID: "owner-1", UserName: "Ravi", Location: "Bangalore", Category: "infrastructure", StatusMsg: "Need Help",
+		Latitude: float(12.9716), Longitude: float(77.5946), Helpers: map[string]string{"helper-1": "Asha"}, SupportCount: 3})
+	store.PutIssue(&Issue{ID: "personal", Title: "Need groceries", Body: "I cannot leave the house", UserID: "owner-2",
+		Location: "Bangalore", Personal: 1, StatusMsg: "Need Help", Latitude: float(12.9720), Longitude: float(77.5950)})
+	store.PutIssue(&Issue{ID: "private", Title: "Private matter", UserID: "owner-2", Private: 1, Personal: 1,
+		StatusMsg: "Need Help", Latitude: float(12.9718), Longitude: float(77.5948)})
+	store.PutIssue(&Issue{ID: "resolved", Title: "Blood donors needed", UserID: "owner-1", StatusMsg: statusResolved,
+		Helpers: map[string]string{"helper-1": "Asha"}, Accepted: []string{"helper-1"}})
+	store.AddStory("resolved", &Story{ID: "story-1", Title: "We found donors", UserId: "owner-1", Tagged: []string{"helper-1"}})
+	return store
+}
 
-	t.Run("Non 200 Response", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
+func apiRequest(method string, path string, params map[string]string, query map[string]string, body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod:            method,
+		Path:                  path,
+		PathParameters:        params,
+		QueryStringParameters: query,
+		Headers:               map[string]string{"Content-Type": "application/json"},
+		Body:                  body,
+	}
+}
 
-		DefaultHTTPGetAddress = ts.URL
+func issueParams(issueID string, field string) map[string]string {
+	params := map[string]string{"issueId": issueID}
+	if field != "" {
+		params["field"] = field
+	}
+	return params
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil && err.Error() != ErrNon200Response.Error() {
-			t.Fatalf("Error failed to trigger with an invalid HTTP response: %v", err)
-		}
-	})
+func decode(t *testing.T, response events.APIGatewayProxyResponse, v interface{}) {
+	t.Helper()
+	if response.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", response.Headers["Content-Type"])
+	}
+	if err := json.Unmarshal([]byte(response.Body), v); err != nil {
+		t.Fatalf("decoding %s: %s", response.Body, err)
+	}
+}
 
-	t.Run("Unable decode IP", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
+func issueIDs(issues []*Issue) string {
+	ids := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		ids = append(ids, issue.ID)
+	}
+	return strings.Join(ids, ",")
+}
 
-		DefaultHTTPGetAddress = ts.URL
+func storedIssue(t *testing.T, store *MemoryIssueStore, issueID string) *Issue {
+	t.Helper()
+	issue, _ := store.GetIssue(issueID)
+	if issue == nil {
+		t.Fatalf("issue %s is not stored", issueID)
+	}
+	return issue
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid HTTP response")
-		}
-	})
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		status  int
+		check   func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse)
+	}{
+		{
+			name:    "list issues",
+			request: apiRequest("GET", "/issues", nil, nil, ""),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				var issues []*Issue
+				decode(t, response, &issues)
+				if got := issueIDs(issues); got != "community,personal,private,resolved" {
+					t.Errorf("issues = %s", got)
+				}
+			},
+		},
+		{
+			name:    "list issues by support",
+			request: apiRequest("GET", "/issues", nil, map[string]string{"sort": "support"}, ""),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				var issues []*Issue
+				decode(t, response, &issues)
+				if len(issues) == 0 || issues[0].ID != "community" {
+					t.Errorf("most supported issue = %s, want community", issueIDs(issues))
+				}
+			},
+		},
+		{
+			name:    "list issues by unknown sort",
+			request: apiRequest("GET", "/issues", nil, map[string]string{"sort": "date"}, ""),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "get issue with stories",
+			request: apiRequest("GET", "/issues/resolved", issueParams("resolved", ""), nil, ""),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				issue := new(Issue)
+				decode(t, response, issue)
+				if issue.ID != "resolved" || len(issue.Stories) != 1 || issue.Stories[0].ID != "story-1" {
+					t.Errorf("issue = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "get missing issue",
+			request: apiRequest("GET", "/issues/missing", issueParams("missing", ""), nil, ""),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "nearby issues",
+			request: apiRequest("GET", "/issues/nearby", nil, map[string]string{"lat": "12.9716", "lng": "77.5946", "radiusKm": "1"}, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				var nearby []*NearbyIssue
+				decode(t, response, &nearby)
+				ids := make([]string, 0)
+				for _, issue := range nearby {
+					ids = append(ids, issue.ID)
+				}
+				if got := strings.Join(ids, ","); got != "community,personal" {
+					t.Errorf("nearby issues = %s, want community,personal", got)
+				}
+			},
+		},
+		{
+			name:    "nearby issues without coordinates",
+			request: apiRequest("GET", "/issues/nearby", nil, map[string]string{"lat": "north"}, ""),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "nearby issues beyond the maximum radius",
+			request: apiRequest("GET", "/issues/nearby", nil, map[string]string{"lat": "12.9", "lng": "77.5", "radiusKm": "500"}, ""),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "create issue",
+			request: apiRequest("POST", "/issues", nil, nil, `{"title": "Pothole on MG Road", "body": "A deep pothole", "location": "Mumbai", "category": " Roads ", "userid": "owner-3"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				inserted := new(InsertResponse)
+				decode(t, response, inserted)
+				issue := storedIssue(t, store, inserted.ID)
+				if issue.Title != "Pothole on MG Road" || issue.Category != "roads" || issue.Personal != 1 || issue.StatusMsg != "Need Help" {
+					t.Errorf("stored issue = %+v", issue)
+				}
+				if created := issue.Created.String(); !strings.HasSuffix(created, "Z") || time.Since(issue.Created.Time) > time.Minute {
+					t.Errorf("created = %q, want the current time in UTC", created)
+				}
+			},
+		},
+		{
+			name:    "check for duplicates",
+			request: apiRequest("POST", "/issues", nil, map[string]string{"checkDuplicates": "true"}, `{"title": "Broken streetlight on 5th cross", "body": "The streetlight is out", "location": "bangalore"}`),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				inserted := new(InsertResponse)
+				decode(t, response, inserted)
+				if inserted.ID != "" || len(inserted.Duplicates) == 0 || inserted.Duplicates[0].ID != "community" {
+					t.Errorf("dry run = %s", response.Body)
+				}
+				if issues, _ := store.GetIssues(); len(issues) != 4 {
+					t.Errorf("a dry run stored an issue")
+				}
+			},
+		},
+		{
+			name:    "create issue without JSON",
+			request: events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/issues", Body: `{"title": "Pothole"}`},
+			status:  http.StatusNotAcceptable,
+		},
+		{
+			name:    "create issue with invalid JSON",
+			request: apiRequest("POST", "/issues", nil, nil, `{"title": `),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "create issue with only a latitude",
+			request: apiRequest("POST", "/issues", nil, nil, `{"title": "Pothole", "latitude": 12.9}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "comment",
+			request: apiRequest("PUT", "/issues/community/comment", issueParams("community", "comment"), nil, `{"userid": "user-1", "username": "Meera", "comment": "  I saw it too  "}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				issue := storedIssue(t, store, "community")
+				if len(issue.Comments) != 1 || issue.Comments[0].Comment != "I saw it too" {
+					t.Errorf("comments = %+v", issue.Comments)
+				}
+			},
+		},
+		{
+			name:    "empty comment",
+			request: apiRequest("PUT", "/issues/community/comment", issueParams("community", "comment"), nil, `{"userid": "user-1", "comment": " "}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "offer help",
+			request: apiRequest("PUT", "/issues/personal/help", issueParams("personal", "help"), nil, `{"userid": "helper-2", "username": "Kiran"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				if name := storedIssue(t, store, "personal").Helpers["helper-2"]; name != "Kiran" {
+					t.Errorf("helper name = %q, want Kiran", name)
+				}
+			},
+		},
+		{
+			name:    "support",
+			request: apiRequest("PUT", "/issues/community/support", issueParams("community", "support"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				if count := storedIssue(t, store, "community").SupportCount; count != 4 {
+					t.Errorf("support count = %d, want 4", count)
+				}
+			},
+		},
+		{
+			name:    "support without user",
+			request: apiRequest("PUT", "/issues/community/support", issueParams("community", "support"), nil, `{}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "support personal issue",
+			request: apiRequest("PUT", "/issues/personal/support", issueParams("personal", "support"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "support missing issue",
+			request: apiRequest("PUT", "/issues/missing/support", issueParams("missing", "support"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "accept help",
+			request: apiRequest("PUT", "/issues/community/accept", issueParams("community", "accept"), nil, `{"userid": "owner-1", "helperid": "helper-1"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				if accepted := storedIssue(t, store, "community").Accepted; len(accepted) != 1 || accepted[0] != "helper-1" {
+					t.Errorf("accepted = %v", accepted)
+				}
+				if store.Points["helper-1"] != acceptedHelpPoints {
+					t.Errorf("helper points = %d, want %d", store.Points["helper-1"], acceptedHelpPoints)
+				}
+			},
+		},
+		{
+			name:    "accept help by someone else",
+			request: apiRequest("PUT", "/issues/community/accept", issueParams("community", "accept"), nil, `{"userid": "user-1", "helperid": "helper-1"}`),
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "accept a user that did not help",
+			request: apiRequest("PUT", "/issues/community/accept", issueParams("community", "accept"), nil, `{"userid": "owner-1", "helperid": "user-1"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "accept help on missing issue",
+			request: apiRequest("PUT", "/issues/missing/accept", issueParams("missing", "accept"), nil, `{"userid": "owner-1", "helperid": "helper-1"}`),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "subscribe",
+			request: apiRequest("PUT", "/issues/community/subscription", issueParams("community", "subscription"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				if subscribers, _ := store.Subscribers("community"); len(subscribers) != 1 || subscribers[0] != "user-1" {
+					t.Errorf("subscribers = %v", subscribers)
+				}
+			},
+		},
+		{
+			name:    "subscribe to missing issue",
+			request: apiRequest("PUT", "/issues/missing/subscription", issueParams("missing", "subscription"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "change status",
+			request: apiRequest("PUT", "/issues/community/status", issueParams("community", "status"), nil, `{"statusmsg": "Resolved", "userid": "owner-1"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryIssueStore, response events.APIGatewayProxyResponse) {
+				if issue := storedIssue(t, store, "community"); issue.StatusMsg != statusResolved || issue.StatusBy != "owner-1" {
+					t.Errorf("status = %q by %q, want Resolved by owner-1", issue.StatusMsg, issue.StatusBy)
+				}
+			},
+		},
+		{
+			name:    "update unknown field",
+			request: apiRequest("PUT", "/issues/community/title", issueParams("community", "title"), nil, `{}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "withdraw support",
+			request: apiRequest("DELETE", "/issues/community/support", issueParams("community", "support"), map[string]string{"userid": "user-1"}, ""),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "withdraw support without user",
+			request: apiRequest("DELETE", "/issues/community/support", issueParams("community", "support"), nil, ""),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "unsubscribe",
+			request: apiRequest("DELETE", "/issues/community/subscription", issueParams("community", "subscription"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "delete unknown field",
+			request: apiRequest("DELETE", "/issues/community/comment", issueParams("community", "comment"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "unsupported method",
+			request: apiRequest("PATCH", "/issues/community", issueParams("community", ""), nil, `{}`),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "preflight",
+			request: apiRequest("OPTIONS", "/issues", nil, nil, ""),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "nearby preflight",
+			request: apiRequest("OPTIONS", "/issues/nearby", nil, nil, ""),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "delete nearby",
+			request: apiRequest("DELETE", "/issues/nearby", nil, nil, `{"userid": "user-1"}`),
+			status:  http.StatusMethodNotAllowed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore()
+			response, err := shared.CORS(NewServer(store).router)(test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("status = %d %s, want %d", response.StatusCode, response.Body, test.status)
+			}
+			if response.Headers["Access-Control-Allow-Origin"] != "*" {
+				t.Errorf("missing CORS headers: %v", response.Headers)
+			}
+			if test.check != nil {
+				test.check(t, store, response)
+			}
+		})
+	}
+}
 
-	t.Run("Successful Request", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(200)
-			fmt.Fprintf(w, "127.0.0.1")
-		}))
-		defer ts.Close()
+func TestSupportIsCountedOnce(t *testing.T) {
+	store := newTestStore()
+	router := NewServer(store).router
+	support := apiRequest("PUT", "/issues/community/support", issueParams("community", "support"), nil, `{"userid": "user-1"}`)
+	withdraw := apiRequest("DELETE", "/issues/community/support", issueParams("community", "support"), nil, `{"userid": "user-1"}`)
+	for _, request := range []events.APIGatewayProxyRequest{support, support, withdraw, withdraw} {
+		if response, _ := router(request); response.StatusCode >= 300 {
+			t.Fatalf("%s support = %d %s", request.HTTPMethod, response.StatusCode, response.Body)
+		}
+	}
+	if count := storedIssue(t, store, "community").SupportCount; count != 3 {
+		t.Errorf("support count = %d, want 3", count)
+	}
+}
 
-		DefaultHTTPGetAddress = ts.URL
+// destination returns the point distanceKm from a point in the direction of
+// bearing, in degrees clockwise from north.
+func destination(lat, lng, distanceKm, bearing float64) (float64, float64) {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	toDeg := func(rad float64) float64 { return rad * 180 / math.Pi }
+	d := distanceKm / earthRadiusKm
+	lat1, lng1, b := toRad(lat), toRad(lng), toRad(bearing)
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(b))
+	lng2 := lng1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	return toDeg(lat2), math.Mod(toDeg(lng2)+540, 360) - 180
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil {
-			t.Fatal("Everything should be ok")
+func TestCoveringGeoHashes(t *testing.T) {
+	// Far north and south, cells of geoKeyPrecision are narrower than
+	// maxRadiusKm.
+	centers := [][2]float64{{12.9716, 77.5946}, {0, 179.99}, {71.5, 25.8}, {78.2, 15.6}, {-85, -60}, {89.9, 0}}
+	for _, center := range centers {
+		for _, radiusKm := range []float64{1, defaultRadiusKm, maxRadiusKm} {
+			hashes := coveringGeoHashes(center[0], center[1], radiusKm)
+			for bearing := 0.0; bearing < 360; bearing += 15 {
+				lat, lng := destination(center[0], center[1], radiusKm*0.99, bearing)
+				hash := encodeGeoHash(lat, lng, geoHashPrecision)
+				covered := false
+				for _, prefix := range hashes {
+					covered = covered || strings.HasPrefix(hash, prefix)
+				}
+				if !covered {
+					t.Errorf("%v km from %v at %v° (%.4f, %.4f) is not covered by %v", radiusKm, center, bearing, lat, lng, hashes)
+				}
+			}
 		}
-	})
+	}
+	if hashes := coveringGeoHashes(12.9716, 77.5946, defaultRadiusKm); len(hashes) != 9 {
+		t.Errorf("covering hashes at Bangalore = %v, want the cell and its 8 neighbours", hashes)
+	}
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IssueStore keeps issues along with their supporters, subscribers and the
+// success stories posted about them.
+type IssueStore interface {
+	GetIssues() ([]*Issue, error)
+	GetOpenIssues() ([]*Issue, error)
+	// GetIssue returns nil when the issue does not exist.
+	GetIssue(issueID string) (*Issue, error)
+	// PutIssue stores an issue and makes it searchable unless it is private.
+	PutIssue(issue *Issue) error
+	AddComment(issueID string, comment *CommentsRequest) error
+	UpdateStatus(issueID string, status *StatusRequest) error
+	AddHelper(issueID string, helper *HelpersRequest) error
+	AcceptHelper(issueID string, helperID string) error
+	AddSupport(issueID string, userID string) error
+	RemoveSupport(issueID string, userID string) error
+	Subscribe(issueID string, userID string) error
+	Unsubscribe(issueID string, userID string) error
+	Subscribers(issueID string) ([]string, error)
+	GetStories(issueID string) ([]*Story, error)
+	GetIssuesByGeoHashes(prefixes []string) ([]*Issue, error)
+}
+
+var errNoIssue = errors.New("the issue does not exist")
+
+// Server handles the issues API with the issues kept in Store.
+type Server struct {
+	Store IssueStore
+}
+
+func NewServer(store IssueStore) *Server {
+	return &Server{Store: store}
+}
+
+// MemoryIssueStore keeps issues in memory, for the local server and tests.
+// Issues are copied in and out so that callers cannot change them behind its
+// back.
+type MemoryIssueStore struct {
+	mu            sync.Mutex
+	issues        map[string]*Issue
+	supporters    map[string]map[string]bool
+	subscriptions map[string]map[string]bool
+	stories       map[string][]*Story
+	// Points are the Samaritan Points awarded to each helper.
+	Points map[string]int
+}
+
+func NewMemoryIssueStore() *MemoryIssueStore {
+	return &MemoryIssueStore{
+		issues:        map[string]*Issue{},
+		supporters:    map[string]map[string]bool{},
+		subscriptions: map[string]map[string]bool{},
+		stories:       map[string][]*Story{},
+		Points:        map[string]int{},
+	}
+}
+
+func copyIssue(issue *Issue) *Issue {
+	c := *issue
+	c.Helpers = make(map[string]string, len(issue.Helpers))
+	for id, name := range issue.Helpers {
+		c.Helpers[id] = name
+	}
+	c.Accepted = append([]string(nil), issue.Accepted...)
+	c.Comments = append([]CommentsRequest(nil), issue.Comments...)
+	c.Stories = nil
+	return &c
+}
+
+// sortedIssues returns copies of the issues matching keep, ordered by id.
+func (s *MemoryIssueStore) sortedIssues(keep func(*Issue) bool) []*Issue {
+	issues := make([]*Issue, 0)
+	for _, issue := range s.issues {
+		if keep(issue) {
+			issues = append(issues, copyIssue(issue))
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].ID < issues[j].ID })
+	return issues
+}
+
+func (s *MemoryIssueStore) GetIssues() ([]*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like a scan of an empty table, no issues are reported as nil.
+	if len(s.issues) == 0 {
+		return nil, nil
+	}
+	return s.sortedIssues(func(*Issue) bool { return true }), nil
+}
+
+func (s *MemoryIssueStore) GetOpenIssues() ([]*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedIssues(func(issue *Issue) bool { return issue.StatusMsg != statusResolved }), nil
+}
+
+func (s *MemoryIssueStore) GetIssue(issueID string) (*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[issueID]
+	if !ok {
+		return nil, nil
+	}
+	return copyIssue(issue), nil
+}
+
+func (s *MemoryIssueStore) PutIssue(issue *Issue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issues[issue.ID] = copyIssue(issue)
+	return nil
+}
+
+// issue returns the stored issue, creating it the way an update of a missing
+// item does in DynamoDB.
+func (s *MemoryIssueStore) issue(issueID string) *Issue {
+	issue, ok := s.issues[issueID]
+	if !ok {
+		issue = &Issue{ID: issueID, Helpers: map[string]string{}}
+		s.issues[issueID] = issue
+	}
+	return issue
+}
+
+func (s *MemoryIssueStore) AddComment(issueID string, comment *CommentsRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue := s.issue(issueID)
+	issue.Comments = append(issue.Comments, *comment)
+	return nil
+}
+
+func (s *MemoryIssueStore) UpdateStatus(issueID string, status *StatusRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue := s.issue(issueID)
+	issue.StatusMsg = status.StatusMsg
+	issue.StatusBy = status.UserID
+	return nil
+}
+
+func (s *MemoryIssueStore) AddHelper(issueID string, helper *HelpersRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issue(issueID).Helpers[helper.UserID] = helper.UserName
+	return nil
+}
+
+// AcceptHelper awards the points only the first time a helper is accepted.
+func (s *MemoryIssueStore) AcceptHelper(issueID string, helperID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[issueID]
+	if !ok {
+		return errNoIssue
+	}
+	if _, ok := issue.Helpers[helperID]; !ok {
+		return errors.New("the user has not offered help on this issue")
+	}
+	for _, accepted := range issue.Accepted {
+		if accepted == helperID {
+			return nil
+		}
+	}
+	issue.Accepted = append(issue.Accepted, helperID)
+	s.Points[helperID] += acceptedHelpPoints
+	return nil
+}
+
+func (s *MemoryIssueStore) AddSupport(issueID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.supporters[issueID][userID] {
+		return nil
+	}
+	issue, ok := s.issues[issueID]
+	if !ok || issue.Personal != 0 {
+		return errors.New("only existing community issues can be supported")
+	}
+	if s.supporters[issueID] == nil {
+		s.supporters[issueID] = map[string]bool{}
+	}
+	s.supporters[issueID][userID] = true
+	issue.SupportCount++
+	return nil
+}
+
+func (s *MemoryIssueStore) RemoveSupport(issueID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.supporters[issueID][userID] {
+		return nil
+	}
+	issue, ok := s.issues[issueID]
+	if !ok {
+		return errNoIssue
+	}
+	delete(s.supporters[issueID], userID)
+	issue.SupportCount--
+	return nil
+}
+
+func (s *MemoryIssueStore) Subscribe(issueID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions[issueID] == nil {
+		s.subscriptions[issueID] = map[string]bool{}
+	}
+	s.subscriptions[issueID][userID] = true
+	return nil
+}
+
+func (s *MemoryIssueStore) Unsubscribe(issueID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions[issueID], userID)
+	return nil
+}
+
+func (s *MemoryIssueStore) Subscribers(issueID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userIDs := make([]string, 0, len(s.subscriptions[issueID]))
+	for userID := range s.subscriptions[issueID] {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+// AddStory links a story to an issue. Stories are posted through the users
+// API, so this is only needed to set up the store.
+func (s *MemoryIssueStore) AddStory(issueID string, story *Story) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stories[issueID] = append([]*Story{story}, s.stories[issueID]...)
+}
+
+func (s *MemoryIssueStore) GetStories(issueID string) ([]*Story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(make([]*Story, 0), s.stories[issueID]...), nil
+}
+
+func (s *MemoryIssueStore) GetIssuesByGeoHashes(prefixes []string) ([]*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedIssues(func(issue *Issue) bool {
+		if issue.Latitude == nil || issue.Longitude == nil {
+			return false
+		}
+		geoHash := encodeGeoHash(*issue.Latitude, *issue.Longitude, geoHashPrecision)
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(geoHash, prefix) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
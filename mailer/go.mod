@@ -0,0 +1,5 @@
+require github.com/aws/aws-sdk-go v1.34.13
+
+module mailer
+
+go 1.14
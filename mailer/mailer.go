@@ -0,0 +1,219 @@
+// Package mailer sends the templated emails of huManUnited through SMTP or
+// Amazon SES.
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(message *Message) error
+}
+
+// SMTPMailer sends emails through an SMTP server, e.g. a local sink such as
+// MailHog listening on localhost:1025.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(message *Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := strings.Split(m.Addr, ":")[0]
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	var raw bytes.Buffer
+	fmt.Fprintf(&raw, "From: %s\r\n", headerValue(m.From))
+	fmt.Fprintf(&raw, "To: %s\r\n", headerValue(message.To))
+	fmt.Fprintf(&raw, "Subject: %s\r\n", headerValue(message.Subject))
+	raw.WriteString("MIME-Version: 1.0\r\n")
+	raw.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	raw.WriteString(strings.Replace(message.Body, "\n", "\r\n", -1))
+	return smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, raw.Bytes())
+}
+
+// headerValue keeps user provided text such as issue titles from injecting headers.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// SESMailer sends emails through Amazon SES.
+type SESMailer struct {
+	Client sesiface.SESAPI
+	From   string
+}
+
+func (m *SESMailer) Send(message *Message) error {
+	input := &ses.SendEmailInput{
+		Source: aws.String(m.From),
+		Destination: &ses.Destination{
+			ToAddresses: []*string{aws.String(message.To)},
+		},
+		Message: &ses.Message{
+			Subject: &ses.Content{
+				Charset: aws.String("UTF-8"),
+				Data:    aws.String(message.Subject),
+			},
+			Body: &ses.Body{
+				Text: &ses.Content{
+					Charset: aws.String("UTF-8"),
+					Data:    aws.String(message.Body),
+				},
+			},
+		},
+	}
+	_, err := m.Client.SendEmail(input)
+	return err
+}
+
+// FromEnv returns the mailer configured by the MAILER environment variable:
+// "smtp" (SMTP_ADDR, SMTP_USERNAME, SMTP_PASSWORD), "ses" in the given
+// region, or nil when it is unset and emails are disabled. MAIL_FROM is the
+// sender of every email.
+func FromEnv(region string) (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	switch kind := os.Getenv("MAILER"); kind {
+	case "":
+		return nil, nil
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			addr = "localhost:1025"
+		}
+		return &SMTPMailer{Addr: addr, From: from, Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD")}, nil
+	case "ses":
+		client := ses.New(session.New(), aws.NewConfig().WithRegion(region))
+		return &SESMailer{Client: client, From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q, expected smtp or ses", kind)
+	}
+}
+
+// Category groups emails so that users can opt out of each kind separately.
+type Category string
+
+const (
+	CategoryHelpOffered Category = "helpoffered"
+	CategoryComment     Category = "comment"
+	CategoryPoints      Category = "points"
+	// CategoryDigest emails are opted into by choosing a digest frequency
+	// instead of being opted out of.
+	CategoryDigest Category = "digest"
+)
+
+// Categories lists every category users can opt out of.
+var Categories = []Category{CategoryHelpOffered, CategoryComment, CategoryPoints}
+
+// IssueSummary is an issue as listed in a digest.
+type IssueSummary struct {
+	Title        string
+	Location     string
+	StatusMsg    string
+	SupportCount int
+}
+
+// Data is what the email templates can refer to.
+type Data struct {
+	Name       string
+	IssueID    string
+	IssueTitle string
+	ActorName  string
+	Comment    string
+	Points     int
+	// Period, NewIssues, TopIssues and PointsChange are only used by digests.
+	Period       string
+	NewIssues    []*IssueSummary
+	TopIssues    []*IssueSummary
+	PointsChange int
+}
+
+type emailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+var templates = map[Category]emailTemplate{
+	CategoryHelpOffered: {
+		subject: template.Must(template.New("subject").Parse(`{{.ActorName}} offered to help with "{{.IssueTitle}}"`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+{{.ActorName}} offered to help with your issue "{{.IssueTitle}}".
+Open huManUnited to get in touch and accept their help.
+
+- huManUnited
+`)),
+	},
+	CategoryComment: {
+		subject: template.Must(template.New("subject").Parse(`New comment on "{{.IssueTitle}}"`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+{{.ActorName}} commented on your issue "{{.IssueTitle}}":
+
+{{.Comment}}
+
+- huManUnited
+`)),
+	},
+	CategoryPoints: {
+		subject: template.Must(template.New("subject").Parse(`You earned {{.Points}} Samaritan Points`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+
+Your help on "{{.IssueTitle}}" was accepted and you earned {{.Points}} Samaritan Points.
+Thank you for helping out!
+
+- huManUnited
+`)),
+	},
+	CategoryDigest: {
+		subject: template.Must(template.New("subject").Parse(`Your {{.Period}} huManUnited digest`)),
+		body: template.Must(template.New("body").Parse(`Hi {{.Name}},
+{{if .NewIssues}}
+New issues near you that could use your help:
+{{range .NewIssues}}  - {{.Title}} ({{.Location}}, {{.StatusMsg}})
+{{end}}{{end}}{{if .TopIssues}}
+Most supported open issues near you:
+{{range .TopIssues}}  - {{.Title}} ({{.SupportCount}} people affected)
+{{end}}{{end}}{{if .PointsChange}}
+Your Samaritan Points changed by {{.PointsChange}} since your last digest.
+{{end}}
+- huManUnited
+`)),
+	},
+}
+
+// Render builds the email of the given category for a recipient.
+func Render(category Category, to string, data *Data) (*Message, error) {
+	tmpl, ok := templates[category]
+	if !ok {
+		return nil, fmt.Errorf("no email template for category %s", category)
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	return &Message{To: to, Subject: subject.String(), Body: body.String()}, nil
+}
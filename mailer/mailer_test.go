@@ -0,0 +1,141 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+// smtpSink is a minimal SMTP server that records the data of every email it receives.
+type smtpSink struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{listener: listener, messages: make(chan string, 10)}
+	go sink.serve()
+	return sink
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 sink ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 sink")
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.messages <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPMailerSendsToSink(t *testing.T) {
+	sink := newSMTPSink(t)
+	defer sink.listener.Close()
+
+	mailer := &SMTPMailer{Addr: sink.listener.Addr().String(), From: "noreply@humanunited.org"}
+	message, err := Render(CategoryComment, "viggy@example.com", &Data{
+		Name:       "Viggy",
+		IssueTitle: "Streetlight broken\r\nBcc: everyone@example.com",
+		ActorName:  "Kiran",
+		Comment:    "I can call the BBMP tomorrow",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send(message); err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-sink.messages
+	for _, want := range []string{
+		"To: viggy@example.com\r\n",
+		"Subject: New comment on \"Streetlight broken  Bcc: everyone@example.com\"\r\n",
+		"Kiran commented on your issue",
+		"I can call the BBMP tomorrow",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("email does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+type fakeSES struct {
+	sesiface.SESAPI
+	sent []*ses.SendEmailInput
+}
+
+func (f *fakeSES) SendEmail(input *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+	f.sent = append(f.sent, input)
+	return &ses.SendEmailOutput{}, nil
+}
+
+func TestSESMailer(t *testing.T) {
+	client := &fakeSES{}
+	mailer := &SESMailer{Client: client, From: "noreply@humanunited.org"}
+	message, err := Render(CategoryPoints, "kiran@example.com", &Data{Name: "Kiran", IssueTitle: "Streetlight broken", Points: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send(message); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(client.sent))
+	}
+	sent := client.sent[0]
+	if *sent.Destination.ToAddresses[0] != "kiran@example.com" || *sent.Message.Subject.Data != "You earned 10 Samaritan Points" {
+		t.Errorf("unexpected email %v", sent)
+	}
+}
+
+func TestRenderUnknownCategory(t *testing.T) {
+	if _, err := Render(Category("unknown"), "kiran@example.com", &Data{}); err == nil {
+		t.Error("expected an error for an unknown category")
+	}
+}
@@ -0,0 +1,79 @@
+// Package moderation holds the rules every user comment of huManUnited has
+// to pass, whether it is made on an issue or on a post.
+package moderation
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxCommentLength is the maximum length of a comment in characters.
+	MaxCommentLength = 1000
+	// MaxCommentLinks keeps comments from being used to spread links.
+	MaxCommentLinks = 2
+)
+
+var (
+	ErrEmptyComment   = errors.New("comment must not be empty")
+	ErrCommentTooLong = errors.New("comment must be at most 1000 characters")
+	ErrTooManyLinks   = errors.New("comment must not contain more than 2 links")
+	ErrBlockedContent = errors.New("comment contains blocked words")
+	ErrMissingUser    = errors.New("userid is required")
+)
+
+// Blocklist holds lower-case words that are not allowed in comments. It is
+// read from the comma separated MODERATION_BLOCKLIST environment variable.
+var Blocklist = parseBlocklist(os.Getenv("MODERATION_BLOCKLIST"))
+
+func parseBlocklist(list string) map[string]bool {
+	blocked := map[string]bool{}
+	for _, word := range strings.Split(list, ",") {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			blocked[word] = true
+		}
+	}
+	return blocked
+}
+
+// CheckComment applies the comment rules and returns the comment as it
+// should be stored: trimmed and without control characters other than
+// newlines and tabs.
+func CheckComment(userID string, comment string) (string, error) {
+	if strings.TrimSpace(userID) == "" {
+		return "", ErrMissingUser
+	}
+	comment = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, comment))
+	if comment == "" {
+		return "", ErrEmptyComment
+	}
+	if utf8.RuneCountInString(comment) > MaxCommentLength {
+		return "", ErrCommentTooLong
+	}
+	links := 0
+	for _, field := range strings.Fields(strings.ToLower(comment)) {
+		if strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") || strings.HasPrefix(field, "www.") {
+			links++
+		}
+	}
+	if links > MaxCommentLinks {
+		return "", ErrTooManyLinks
+	}
+	words := strings.FieldsFunc(strings.ToLower(comment), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		if Blocklist[word] {
+			return "", ErrBlockedContent
+		}
+	}
+	return comment, nil
+}
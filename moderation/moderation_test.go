@@ -0,0 +1,35 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckComment(t *testing.T) {
+	Blocklist = parseBlocklist(" Scam, ,spam ")
+	defer func() { Blocklist = map[string]bool{} }()
+
+	tests := []struct {
+		userID  string
+		comment string
+		want    string
+		err     error
+	}{
+		{"user-1", "  On my way\x00 with a ladder\n", "On my way with a ladder", nil},
+		{"user-1", "Line one\n\tline two", "Line one\n\tline two", nil},
+		{"", "Hello", "", ErrMissingUser},
+		{"user-1", " \n\t ", "", ErrEmptyComment},
+		{"user-1", strings.Repeat("é", MaxCommentLength), strings.Repeat("é", MaxCommentLength), nil},
+		{"user-1", strings.Repeat("a", MaxCommentLength+1), "", ErrCommentTooLong},
+		{"user-1", "see https://a.example and http://b.example", "see https://a.example and http://b.example", nil},
+		{"user-1", "https://a.example http://b.example www.c.example", "", ErrTooManyLinks},
+		{"user-1", "Total SCAM!", "", ErrBlockedContent},
+		{"user-1", "Spammers are annoying", "Spammers are annoying", nil},
+	}
+	for _, test := range tests {
+		got, err := CheckComment(test.userID, test.comment)
+		if got != test.want || err != test.err {
+			t.Errorf("CheckComment(%q, %q) = %q, %v, want %q, %v", test.userID, test.comment, got, err, test.want, test.err)
+		}
+	}
+}
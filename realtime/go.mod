@@ -0,0 +1,8 @@
+require (
+	github.com/aws/aws-sdk-go v1.34.13
+	github.com/gorilla/websocket v1.4.2
+)
+
+module realtime
+
+go 1.14
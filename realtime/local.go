@@ -0,0 +1,139 @@
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// connectionsPath is the route of the API Gateway management API.
+const connectionsPath = "/@connections/"
+
+// LocalServer stands in for the API Gateway WebSocket API when running
+// locally. Clients connect to any path with an optional ?userid= and send the
+// same messages as they would to API Gateway. The server also serves
+// POST /@connections/{connectionId}, so an APIGatewaySender pointed at it
+// reaches its clients just like it would on AWS.
+type LocalServer struct {
+	Manager *Manager
+
+	upgrader    websocket.Upgrader
+	mu          sync.Mutex
+	connections map[string]*localConnection
+}
+
+// localConnection serializes writes, which gorilla/websocket does not allow
+// to happen concurrently.
+type localConnection struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *localConnection) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func NewLocalServer(store ConnectionStore) *LocalServer {
+	return &LocalServer{
+		Manager: &Manager{Store: store},
+		upgrader: websocket.Upgrader{
+			// Any origin may connect, as the frontend is served from a different port locally.
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		connections: map[string]*localConnection{},
+	}
+}
+
+// Broadcaster returns a broadcaster writing directly to the clients of this server.
+func (s *LocalServer) Broadcaster() *Broadcaster {
+	return &Broadcaster{Store: s.Manager.Store, Sender: s}
+}
+
+// Send writes data to a connected client.
+func (s *LocalServer) Send(connectionID string, data []byte) error {
+	s.mu.Lock()
+	connection := s.connections[connectionID]
+	s.mu.Unlock()
+	if connection == nil {
+		return ErrGone
+	}
+	return connection.write(data)
+}
+
+func (s *LocalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, connectionsPath) {
+		s.postToConnection(w, r)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	connectionID, err := newConnectionID()
+	if err != nil {
+		return
+	}
+	if err := s.Manager.Connect(connectionID, r.URL.Query().Get("userid")); err != nil {
+		return
+	}
+	connection := &localConnection{conn: conn}
+	s.mu.Lock()
+	s.connections[connectionID] = connection
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.connections, connectionID)
+		s.mu.Unlock()
+		s.Manager.Disconnect(connectionID)
+	}()
+	for {
+		_, body, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := s.Manager.Receive(connectionID, body); err != nil {
+			reply, _ := json.Marshal(map[string]string{"message": err.Error()})
+			connection.write(reply)
+		}
+	}
+}
+
+func (s *LocalServer) postToConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = s.Send(strings.TrimPrefix(r.URL.Path, connectionsPath), body)
+	if err == ErrGone {
+		w.Header().Set("X-Amzn-Errortype", "GoneException")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(`{"message": "connection is gone"}`))
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func newConnectionID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
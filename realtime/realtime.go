@@ -0,0 +1,139 @@
+// Package realtime pushes issue updates to WebSocket clients, either through
+// an API Gateway WebSocket API or a plain net/http server for local testing.
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+)
+
+// ActionSubscribe is the action of the message a client sends to receive the
+// updates of an issue: {"action": "subscribe", "issueId": "..."}.
+const ActionSubscribe = "subscribe"
+
+var (
+	// ErrGone is returned by a Sender when the connection no longer exists.
+	ErrGone          = errors.New("connection is gone")
+	ErrUnknownAction = errors.New("unknown action")
+	ErrMissingIssue  = errors.New("issueId is required")
+	// ErrBadMessage wraps the error decoding a message that is not valid
+	// JSON.
+	ErrBadMessage = errors.New("message is not valid JSON")
+)
+
+// Message is an update pushed to the clients subscribed to an issue.
+type Message struct {
+	Type    string      `json:"type"`
+	IssueID string      `json:"issueId"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// ClientMessage is a message sent by a client.
+type ClientMessage struct {
+	Action  string `json:"action"`
+	IssueID string `json:"issueId"`
+}
+
+// Manager handles the lifecycle of connections: connecting, subscribing to
+// issues and disconnecting.
+type Manager struct {
+	Store ConnectionStore
+}
+
+func (m *Manager) Connect(connectionID string, userID string) error {
+	return m.Store.Connect(connectionID, userID)
+}
+
+func (m *Manager) Disconnect(connectionID string) error {
+	return m.Store.Disconnect(connectionID)
+}
+
+// Receive handles a message sent by a client.
+func (m *Manager) Receive(connectionID string, body []byte) error {
+	message := ClientMessage{}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return fmt.Errorf("%w: %s", ErrBadMessage, err)
+	}
+	if message.Action != ActionSubscribe {
+		return ErrUnknownAction
+	}
+	if message.IssueID == "" {
+		return ErrMissingIssue
+	}
+	return m.Store.Subscribe(connectionID, message.IssueID)
+}
+
+// Sender sends data to a single connection.
+type Sender interface {
+	Send(connectionID string, data []byte) error
+}
+
+// APIGatewaySender posts to connections through the API Gateway management
+// API. The endpoint is the https URL of the deployed stage, or the URL of a
+// LocalServer, which serves the same @connections route.
+type APIGatewaySender struct {
+	Client apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+}
+
+func NewAPIGatewaySender(endpoint string, region string) (*APIGatewaySender, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:   aws.String(region),
+		Endpoint: aws.String(endpoint)})
+	if err != nil {
+		return nil, err
+	}
+	return &APIGatewaySender{Client: apigatewaymanagementapi.New(sess)}, nil
+}
+
+func (s *APIGatewaySender) Send(connectionID string, data []byte) error {
+	_, err := s.Client.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(connectionID),
+		Data:         data,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == apigatewaymanagementapi.ErrCodeGoneException {
+		return ErrGone
+	}
+	return err
+}
+
+// Broadcaster pushes messages to every connection subscribed to an issue.
+type Broadcaster struct {
+	Store  ConnectionStore
+	Sender Sender
+}
+
+// Broadcast sends the message to the subscribers of its issue. Connections
+// that are gone are removed from the store; other failures do not stop the
+// remaining subscribers from being reached, and are reported together.
+func (b *Broadcaster) Broadcast(message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	connectionIDs, err := b.Store.Subscribers(message.IssueID)
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, connectionID := range connectionIDs {
+		err := b.Sender.Send(connectionID, data)
+		if err == ErrGone {
+			err = b.Store.Disconnect(connectionID)
+		}
+		if err != nil {
+			failures = append(failures, connectionID+": "+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("could not reach %d of %d connections: %s", len(failures), len(connectionIDs), strings.Join(failures, "; "))
+	}
+	return nil
+}
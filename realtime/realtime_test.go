@@ -0,0 +1,180 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/gorilla/websocket"
+)
+
+func TestManagerReceive(t *testing.T) {
+	store := NewMemoryConnectionStore()
+	manager := &Manager{Store: store}
+	manager.Connect("c1", "user-1")
+
+	tests := []struct {
+		body string
+		err  error
+	}{
+		{`{"action": "subscribe", "issueId": "issue-1"}`, nil},
+		{`{"action": "subscribe"}`, ErrMissingIssue},
+		{`{"action": "shout", "issueId": "issue-1"}`, ErrUnknownAction},
+	}
+	for _, test := range tests {
+		if err := manager.Receive("c1", []byte(test.body)); err != test.err {
+			t.Errorf("Receive(%s) = %v, want %v", test.body, err, test.err)
+		}
+	}
+	if err := manager.Receive("c1", []byte("not json")); !errors.Is(err, ErrBadMessage) {
+		t.Errorf("Receive(not json) = %v, want %v", err, ErrBadMessage)
+	}
+	if got, _ := store.Subscribers("issue-1"); !reflect.DeepEqual(got, []string{"c1"}) {
+		t.Errorf("subscribers = %v", got)
+	}
+	manager.Disconnect("c1")
+	if got, _ := store.Subscribers("issue-1"); len(got) != 0 {
+		t.Errorf("subscribers after disconnect = %v", got)
+	}
+}
+
+type fakeSender struct {
+	sent map[string][]byte
+	gone map[string]bool
+	fail map[string]bool
+}
+
+func (s *fakeSender) Send(connectionID string, data []byte) error {
+	if s.gone[connectionID] {
+		return ErrGone
+	}
+	if s.fail[connectionID] {
+		return errors.New("throttled")
+	}
+	s.sent[connectionID] = data
+	return nil
+}
+
+func TestBroadcast(t *testing.T) {
+	store := NewMemoryConnectionStore()
+	for _, connectionID := range []string{"c1", "c2", "c3", "c4"} {
+		store.Subscribe(connectionID, "issue-1")
+	}
+	store.Subscribe("c5", "issue-2")
+	sender := &fakeSender{
+		sent: map[string][]byte{},
+		gone: map[string]bool{"c2": true},
+		fail: map[string]bool{"c3": true},
+	}
+	broadcaster := &Broadcaster{Store: store, Sender: sender}
+
+	err := broadcaster.Broadcast(&Message{Type: "IssueCommentAdded", IssueID: "issue-1", Data: map[string]string{"comment": "On my way"}})
+	if err == nil || !strings.Contains(err.Error(), "1 of 4") {
+		t.Errorf("Broadcast error = %v, want the failed connection reported", err)
+	}
+	if len(sender.sent) != 2 || sender.sent["c1"] == nil || sender.sent["c4"] == nil {
+		t.Errorf("sent to %v, want c1 and c4", sender.sent)
+	}
+	want := `{"type":"IssueCommentAdded","issueId":"issue-1","data":{"comment":"On my way"}}`
+	if got := string(sender.sent["c1"]); got != want {
+		t.Errorf("message = %s, want %s", got, want)
+	}
+	if got, _ := store.Subscribers("issue-1"); !reflect.DeepEqual(got, []string{"c1", "c3", "c4"}) {
+		t.Errorf("subscribers = %v, want the gone connection removed", got)
+	}
+}
+
+func dial(t *testing.T, server *httptest.Server) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?userid=user-1"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// waitForSubscribers waits until the server has handled the subscribe messages.
+func waitForSubscribers(t *testing.T, store ConnectionStore, issueID string, n int) []string {
+	for i := 0; i < 100; i++ {
+		subscribers, _ := store.Subscribers(issueID)
+		if len(subscribers) == n {
+			return subscribers
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("issue %s never reached %d subscribers", issueID, n)
+	return nil
+}
+
+func TestLocalServer(t *testing.T) {
+	store := NewMemoryConnectionStore()
+	local := NewLocalServer(store)
+	server := httptest.NewServer(local)
+	defer server.Close()
+
+	subscribed := dial(t, server)
+	defer subscribed.Close()
+	other := dial(t, server)
+	defer other.Close()
+	subscribed.WriteMessage(websocket.TextMessage, []byte(`{"action": "subscribe", "issueId": "issue-1"}`))
+	other.WriteMessage(websocket.TextMessage, []byte(`{"action": "subscribe", "issueId": "issue-2"}`))
+	waitForSubscribers(t, store, "issue-1", 1)
+
+	if err := local.Broadcaster().Broadcast(&Message{Type: "IssueStatusChanged", IssueID: "issue-1"}); err != nil {
+		t.Fatal(err)
+	}
+	subscribed.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := subscribed.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := Message{}
+	if err := json.Unmarshal(data, &message); err != nil || message.Type != "IssueStatusChanged" {
+		t.Errorf("received %s", data)
+	}
+
+	other.WriteMessage(websocket.TextMessage, []byte(`{"action": "subscribe"}`))
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	if _, data, err := other.ReadMessage(); err != nil || !strings.Contains(string(data), ErrMissingIssue.Error()) {
+		t.Errorf("reply to an invalid message = %s, %v", data, err)
+	}
+}
+
+func TestAPIGatewaySenderAgainstLocalServer(t *testing.T) {
+	store := NewMemoryConnectionStore()
+	local := NewLocalServer(store)
+	server := httptest.NewServer(local)
+	defer server.Close()
+
+	conn := dial(t, server)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"action": "subscribe", "issueId": "issue-1"}`))
+	connectionID := waitForSubscribers(t, store, "issue-1", 1)[0]
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("ap-south-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	}))
+	sender := &APIGatewaySender{Client: apigatewaymanagementapi.New(sess)}
+	if err := sender.Send(connectionID, []byte(`{"type":"IssueHelperAdded"}`)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != `{"type":"IssueHelperAdded"}` {
+		t.Errorf("received %s, %v", data, err)
+	}
+
+	conn.Close()
+	waitForSubscribers(t, store, "issue-1", 0)
+	if err := sender.Send(connectionID, []byte(`{}`)); err != ErrGone {
+		t.Errorf("Send to a closed connection = %v, want ErrGone", err)
+	}
+}
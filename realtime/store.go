@@ -0,0 +1,192 @@
+package realtime
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// connectionTopic is the topic of the item recording the connection itself.
+	connectionTopic  = "connection"
+	issueTopicPrefix = "issue#"
+	// TopicIndex is the connections table index listing the connections of a topic.
+	TopicIndex = "TopicIndex"
+	// connectionTTL outlives the two hour limit API Gateway puts on a WebSocket connection.
+	connectionTTL   = 3 * time.Hour
+	batchWriteLimit = 25
+)
+
+func issueTopic(issueID string) string {
+	return issueTopicPrefix + issueID
+}
+
+// ConnectionStore keeps track of the open connections and the issues they
+// are subscribed to.
+type ConnectionStore interface {
+	Connect(connectionID string, userID string) error
+	Subscribe(connectionID string, issueID string) error
+	Disconnect(connectionID string) error
+	Subscribers(issueID string) ([]string, error)
+}
+
+// DynamoConnectionStore keeps connections in a DynamoDB table keyed by
+// ConnectionId and Topic, with a TopicIndex for the reverse lookup. Items
+// expire through the ExpiresAt TTL attribute in case a disconnect is missed.
+type DynamoConnectionStore struct {
+	DB    dynamodbiface.DynamoDBAPI
+	Table string
+}
+
+func (s *DynamoConnectionStore) put(connectionID string, topic string, userID string) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"ConnectionId": {
+			S: aws.String(connectionID),
+		},
+		"Topic": {
+			S: aws.String(topic),
+		},
+		"ExpiresAt": {
+			N: aws.String(strconv.FormatInt(time.Now().Add(connectionTTL).Unix(), 10)),
+		},
+	}
+	if userID != "" {
+		item["UserId"] = &dynamodb.AttributeValue{S: aws.String(userID)}
+	}
+	_, err := s.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.Table),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoConnectionStore) Connect(connectionID string, userID string) error {
+	return s.put(connectionID, connectionTopic, userID)
+}
+
+func (s *DynamoConnectionStore) Subscribe(connectionID string, issueID string) error {
+	return s.put(connectionID, issueTopic(issueID), "")
+}
+
+// Disconnect deletes the connection along with all of its subscriptions.
+func (s *DynamoConnectionStore) Disconnect(connectionID string) error {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("ConnectionId = :c"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {
+				S: aws.String(connectionID),
+			},
+		},
+		ProjectionExpression: aws.String("ConnectionId, Topic"),
+	}
+	requests := make([]*dynamodb.WriteRequest, 0)
+	err := s.DB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: item},
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(requests); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(requests) {
+			end = len(requests)
+		}
+		pending := map[string][]*dynamodb.WriteRequest{s.Table: requests[start:end]}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == 5 {
+				return fmt.Errorf("unprocessed items remain for connection %s", connectionID)
+			}
+			result, err := s.DB.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = result.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// Subscribers returns the ids of the connections subscribed to an issue.
+func (s *DynamoConnectionStore) Subscribers(issueID string) ([]string, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(TopicIndex),
+		KeyConditionExpression: aws.String("Topic = :t"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {
+				S: aws.String(issueTopic(issueID)),
+			},
+		},
+	}
+	connectionIDs := make([]string, 0)
+	err := s.DB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if id := item["ConnectionId"]; id != nil && id.S != nil {
+				connectionIDs = append(connectionIDs, *id.S)
+			}
+		}
+		return true
+	})
+	return connectionIDs, err
+}
+
+// MemoryConnectionStore keeps connections in memory, for the local server and tests.
+type MemoryConnectionStore struct {
+	mu          sync.Mutex
+	connections map[string]map[string]bool
+}
+
+func NewMemoryConnectionStore() *MemoryConnectionStore {
+	return &MemoryConnectionStore{connections: map[string]map[string]bool{}}
+}
+
+func (s *MemoryConnectionStore) Connect(connectionID string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connections[connectionID] == nil {
+		s.connections[connectionID] = map[string]bool{}
+	}
+	return nil
+}
+
+func (s *MemoryConnectionStore) Subscribe(connectionID string, issueID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connections[connectionID] == nil {
+		s.connections[connectionID] = map[string]bool{}
+	}
+	s.connections[connectionID][issueID] = true
+	return nil
+}
+
+func (s *MemoryConnectionStore) Disconnect(connectionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.connections, connectionID)
+	return nil
+}
+
+func (s *MemoryConnectionStore) Subscribers(issueID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	connectionIDs := make([]string, 0)
+	for connectionID, issues := range s.connections {
+		if issues[issueID] {
+			connectionIDs = append(connectionIDs, connectionID)
+		}
+	}
+	sort.Strings(connectionIDs)
+	return connectionIDs, nil
+}
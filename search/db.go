@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"shared"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var db *dynamodb.DynamoDB
+
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
+
+const batchGetLimit = 100
+
+// getIndexEntries returns the index entries of every term starting with the given term.
+func getIndexEntries(term string) ([]*indexEntry, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.SearchIndex),
+		KeyConditionExpression: aws.String("Prefix = :p and begins_with(TermKey, :t)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {
+				S: aws.String(shared.TermPrefix(term)),
+			},
+			":t": {
+				S: aws.String(term),
+			},
+		},
+	}
+	entries := make([]*indexEntry, 0)
+	var unmarshalErr error
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			entry := new(indexEntry)
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(i, entry); unmarshalErr != nil {
+				return false
+			}
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, unmarshalErr
+}
+
+// batchGet fetches the items with the given ids from a table and unmarshals
+// each of them with the given function.
+func batchGet(table string, ids []string, unmarshal func(map[string]*dynamodb.AttributeValue) error) error {
+	for start := 0; start < len(ids); start += batchGetLimit {
+		end := start + batchGetLimit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		keys := make([]map[string]*dynamodb.AttributeValue, 0, end-start)
+		for _, id := range ids[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"Id": {
+					S: aws.String(id),
+				},
+			})
+		}
+		pending := map[string]*dynamodb.KeysAndAttributes{table: {Keys: keys}}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == 5 {
+				return fmt.Errorf("could not read all items from %s: unprocessed keys remain", table)
+			}
+			result, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			for _, item := range result.Responses[table] {
+				if err := unmarshal(item); err != nil {
+					return err
+				}
+			}
+			pending = result.UnprocessedKeys
+		}
+	}
+	return nil
+}
+
+// DynamoDocumentStore reads documents from the issues and posts tables.
+type DynamoDocumentStore struct{}
+
+func (s *DynamoDocumentStore) GetIssuesByIds(ids []string) (map[string]*Issue, error) {
+	issues := map[string]*Issue{}
+	err := batchGet(tables.Issues, ids, func(item map[string]*dynamodb.AttributeValue) error {
+		issue := new(Issue)
+		if err := dynamodbattribute.UnmarshalMap(item, issue); err != nil {
+			return err
+		}
+		issues[issue.ID] = issue
+		return nil
+	})
+	return issues, err
+}
+
+func (s *DynamoDocumentStore) GetPostsByIds(ids []string) (map[string]*Post, error) {
+	posts := map[string]*Post{}
+	err := batchGet(tables.Posts, ids, func(item map[string]*dynamodb.AttributeValue) error {
+		post := new(Post)
+		if err := dynamodbattribute.UnmarshalMap(item, post); err != nil {
+			return err
+		}
+		posts[post.ID] = post
+		return nil
+	})
+	return posts, err
+}
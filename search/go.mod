@@ -0,0 +1,11 @@
+require (
+	github.com/aws/aws-lambda-go v1.13.3
+	github.com/aws/aws-sdk-go v1.34.13
+	shared v0.0.0
+)
+
+replace shared => ../shared
+
+module search
+
+go 1.14
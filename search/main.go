@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"shared"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+type Issue struct {
+	ID        string      `json:"id"`
+	Created   shared.Time `json:"created"`
+	Title     string      `json:"title"`
+	Body      string      `json:"body"`
+	Private   int         `json:"private"`
+	UserID    string      `json:"userid"`
+	UserName  string      `json:"username"`
+	Location  string      `json:"location"`
+	Personal  int         `json:"personal"`
+	StatusMsg string      `json:"statusmsg"`
+}
+
+type Post struct {
+	ID          string      `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	PostTime    shared.Time `json:"posttime"`
+	UserId      string      `json:"userid"`
+}
+
+type SearchResult struct {
+	Type  string  `json:"type"`
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+	Issue *Issue  `json:"issue,omitempty"`
+	Post  *Post   `json:"post,omitempty"`
+}
+
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch req.HTTPMethod {
+	case "GET":
+		return search(req)
+	default:
+		return shared.Status(http.StatusMethodNotAllowed), nil
+	}
+}
+
+func search(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	docType := request.QueryStringParameters["type"]
+	if docType != "" && docType != "issue" && docType != "post" {
+		return shared.Text(http.StatusBadRequest, "type must be issue or post"), nil
+	}
+	limit := defaultSearchLimit
+	if l, ok := request.QueryStringParameters["limit"]; ok {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 || parsed > maxSearchLimit {
+			return shared.Text(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit)), nil
+		}
+		limit = parsed
+	}
+	terms := shared.Tokenize(request.QueryStringParameters["q"])
+	if len(terms) == 0 {
+		return shared.Text(http.StatusBadRequest, "q must contain at least one searchable word"), nil
+	}
+
+	entriesByTerm := map[string][]*indexEntry{}
+	for _, term := range terms {
+		if _, ok := entriesByTerm[term]; ok {
+			continue
+		}
+		entries, err := getIndexEntries(term)
+		if err != nil {
+			shared.Log.WithError(err).Errorf("Failed to query search index for %s", term)
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		entriesByTerm[term] = entries
+	}
+	ranked := rankDocuments(entriesByTerm, docType)
+
+	results, err := loadResults(&DynamoDocumentStore{}, ranked, limit)
+	if err != nil {
+		shared.Log.WithError(err).Errorf("Failed to load search results")
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.JSON(http.StatusOK, results), nil
+}
+
+// DocumentStore reads the issues and posts search results show. Documents
+// that do not exist are missing from the maps it returns.
+type DocumentStore interface {
+	GetIssuesByIds(ids []string) (map[string]*Issue, error)
+	GetPostsByIds(ids []string) (map[string]*Post, error)
+}
+
+// loadResults fetches the ranked documents, keeping their order, until limit
+// results are kept. Documents that no longer exist or are private are left
+// out, and the ones ranked below them loaded in their place.
+func loadResults(store DocumentStore, ranked []*scoredDocument, limit int) ([]*SearchResult, error) {
+	results := make([]*SearchResult, 0, limit)
+	for len(ranked) > 0 && len(results) < limit {
+		batch := ranked
+		if missing := limit - len(results); len(batch) > missing {
+			batch = batch[:missing]
+		}
+		ranked = ranked[len(batch):]
+		loaded, err := loadDocuments(store, batch)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, loaded...)
+	}
+	return results, nil
+}
+
+// loadDocuments fetches a batch of ranked documents, leaving out the ones
+// that no longer exist or are private.
+func loadDocuments(store DocumentStore, ranked []*scoredDocument) ([]*SearchResult, error) {
+	issueIDs := make([]string, 0)
+	postIDs := make([]string, 0)
+	for _, document := range ranked {
+		if document.DocType == "issue" {
+			issueIDs = append(issueIDs, document.DocID)
+		} else {
+			postIDs = append(postIDs, document.DocID)
+		}
+	}
+	issues, err := store.GetIssuesByIds(issueIDs)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := store.GetPostsByIds(postIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0, len(ranked))
+	for _, document := range ranked {
+		result := &SearchResult{Type: document.DocType, ID: document.DocID, Score: document.Score}
+		if document.DocType == "issue" {
+			issue, ok := issues[document.DocID]
+			if !ok || issue.Private != 0 {
+				continue
+			}
+			result.Issue = issue
+		} else {
+			post, ok := posts[document.DocID]
+			if !ok {
+				continue
+			}
+			result.Post = post
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func main() {
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	db = shared.MustNewDynamoDB(cfg)
+	lambda.Start(shared.Logged(shared.CORS(router)))
+}
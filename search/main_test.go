@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"shared"
+	"testing"
+	"time"
+)
+
+func TestRankDocuments(t *testing.T) {
+	entries := map[string][]*indexEntry{
+		"flood": {
+			{Term: "flood", DocType: "issue", DocId: "old", Weight: 3, IndexedAt: 100},
+			{Term: "flood", DocType: "issue", DocId: "new", Weight: 3, IndexedAt: 200},
+			{Term: "floodlight", DocType: "post", DocId: "prefix", Weight: 3, IndexedAt: 300},
+		},
+		"road": {
+			{Term: "road", DocType: "issue", DocId: "old", Weight: 1, IndexedAt: 100},
+		},
+	}
+
+	ranked := rankDocuments(entries, "")
+	got := make([]string, 0)
+	for _, document := range ranked {
+		got = append(got, document.DocID)
+	}
+	if want := []string{"old", "new", "prefix"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rankDocuments order = %v, want %v", got, want)
+	}
+
+	ranked = rankDocuments(entries, "post")
+	if len(ranked) != 1 || ranked[0].DocID != "prefix" || ranked[0].Score != 1.5 {
+		t.Errorf("rankDocuments filtered by post = %+v", ranked)
+	}
+}
+
+func TestRankDocumentsByCreation(t *testing.T) {
+	created := func(date string) shared.Time {
+		t, _ := time.Parse("2006-01-02", date)
+		return shared.Time{Time: t}
+	}
+	// The old issue was edited, and so indexed again, after the new one was
+	// created. The legacy entry predates Created.
+	entries := map[string][]*indexEntry{
+		"drain": {
+			{Term: "drain", DocType: "issue", DocId: "edited", Weight: 3, Created: created("2020-01-01"), IndexedAt: created("2020-09-01").Unix()},
+			{Term: "drain", DocType: "issue", DocId: "new", Weight: 3, Created: created("2020-06-01"), IndexedAt: created("2020-06-01").Unix()},
+			{Term: "drain", DocType: "post", DocId: "legacy", Weight: 3, IndexedAt: created("2020-03-01").Unix()},
+		},
+	}
+	got := make([]string, 0)
+	for _, document := range rankDocuments(entries, "") {
+		got = append(got, document.DocID)
+	}
+	if want := []string{"new", "legacy", "edited"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rankDocuments order = %v, want %v", got, want)
+	}
+}
+
+// memoryDocuments holds the issues and posts of search results.
+type memoryDocuments struct {
+	issues map[string]*Issue
+	posts  map[string]*Post
+	loaded int
+}
+
+func (s *memoryDocuments) GetIssuesByIds(ids []string) (map[string]*Issue, error) {
+	s.loaded += len(ids)
+	issues := map[string]*Issue{}
+	for _, id := range ids {
+		if issue, ok := s.issues[id]; ok {
+			issues[id] = issue
+		}
+	}
+	return issues, nil
+}
+
+func (s *memoryDocuments) GetPostsByIds(ids []string) (map[string]*Post, error) {
+	s.loaded += len(ids)
+	posts := map[string]*Post{}
+	for _, id := range ids {
+		if post, ok := s.posts[id]; ok {
+			posts[id] = post
+		}
+	}
+	return posts, nil
+}
+
+func TestLoadResults(t *testing.T) {
+	store := &memoryDocuments{
+		issues: map[string]*Issue{
+			"private": {ID: "private", Private: 1},
+			"public":  {ID: "public"},
+			"other":   {ID: "other"},
+		},
+		posts: map[string]*Post{
+			"post": {ID: "post"},
+		},
+	}
+	// The two best matches are a private issue and a deleted post.
+	ranked := []*scoredDocument{
+		{DocType: "issue", DocID: "private", Score: 9},
+		{DocType: "post", DocID: "deleted", Score: 8},
+		{DocType: "issue", DocID: "public", Score: 7},
+		{DocType: "post", DocID: "post", Score: 6},
+		{DocType: "issue", DocID: "other", Score: 5},
+	}
+
+	results, err := loadResults(store, ranked, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, result := range results {
+		got = append(got, result.ID)
+	}
+	if want := []string{"public", "post"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+	if store.loaded != 4 {
+		t.Errorf("loaded %d documents, want 4", store.loaded)
+	}
+
+	if results, err := loadResults(store, ranked[:2], 2); err != nil || len(results) != 0 {
+		t.Errorf("results of private and deleted documents = %v, %v", results, err)
+	}
+}
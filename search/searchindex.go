@@ -0,0 +1,87 @@
+package main
+
+import (
+	"shared"
+	"sort"
+	"time"
+)
+
+// prefixMatchFactor scales down the weight of terms that only start with a
+// query term.
+const prefixMatchFactor = 0.5
+
+type indexEntry struct {
+	Term    string
+	DocType string
+	DocId   string
+	Weight  int
+	// Created is when the document was created or posted. Entries indexed
+	// before it was stored only have IndexedAt.
+	Created   shared.Time
+	IndexedAt int64
+}
+
+// created returns when the document of the entry was created, or indexed if
+// the entry does not say.
+func (e *indexEntry) created() time.Time {
+	if e.Created.IsZero() {
+		return time.Unix(e.IndexedAt, 0)
+	}
+	return e.Created.Time
+}
+
+type scoredDocument struct {
+	DocType string
+	DocID   string
+	Score   float64
+	Created time.Time
+}
+
+// rankDocuments scores every document found for the query terms and returns
+// them by relevance, then by how recently they were created. A document scores the full weight of a
+// term it contains exactly and a fraction of it when it only contains a term
+// starting with the query term; only the best match per query term counts.
+func rankDocuments(entriesByTerm map[string][]*indexEntry, docType string) []*scoredDocument {
+	documents := map[string]*scoredDocument{}
+	for queryTerm, entries := range entriesByTerm {
+		best := map[string]float64{}
+		for _, entry := range entries {
+			if docType != "" && entry.DocType != docType {
+				continue
+			}
+			key := entry.DocType + "#" + entry.DocId
+			score := float64(entry.Weight)
+			if entry.Term != queryTerm {
+				score *= prefixMatchFactor
+			}
+			if score > best[key] {
+				best[key] = score
+			}
+			document, ok := documents[key]
+			if !ok {
+				document = &scoredDocument{DocType: entry.DocType, DocID: entry.DocId}
+				documents[key] = document
+			}
+			if created := entry.created(); created.After(document.Created) {
+				document.Created = created
+			}
+		}
+		for key, score := range best {
+			documents[key].Score += score
+		}
+	}
+	ranked := make([]*scoredDocument, 0, len(documents))
+	for _, document := range documents {
+		ranked = append(ranked, document)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		if !ranked[i].Created.Equal(ranked[j].Created) {
+			return ranked[i].Created.After(ranked[j].Created)
+		}
+		return ranked[i].DocID < ranked[j].DocID
+	})
+	return ranked
+}
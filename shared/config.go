@@ -0,0 +1,266 @@
+// Package shared holds what every huManUnited function needs: its
+// configuration, the DynamoDB client, the logger, the search index and the
+// API Gateway responses with their CORS headers.
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// EnvAWS is the AWSENV of functions deployed to AWS, the default.
+const EnvAWS = "AWS"
+
+// EnvSAMLocal is the AWSENV of functions run by "sam local", which talk to
+// DynamoDB Local at DBENDPOINT.
+const EnvSAMLocal = "AWS_SAM_LOCAL"
+
+// EnvMemory is the AWSENV of functions run by cmd/devserver without a
+// database, which keep their data in memory.
+const EnvMemory = "MEMORY"
+
+// LocalRegion is the region of functions run outside AWS without
+// AWS_REGION. DynamoDB Local keeps the tables of every region together.
+const LocalRegion = "ap-south-1"
+
+// Tables are the names of the DynamoDB tables. template.yaml passes them to
+// every function in the <TABLE>_TABLE environment variables.
+type Tables struct {
+	Issues            string
+	Users             string
+	Posts             string
+	PostLikes         string
+	PostComments      string
+	IssueSupport      string
+	Subscriptions     string
+	Notifications     string
+	ProcessedEvents   string
+	Connections       string
+	Webhooks          string
+	WebhookDeliveries string
+	SearchIndex       string
+}
+
+// DefaultTables are the names of the tables cmd/dbctl creates, used outside
+// AWS for the tables whose variable is not set.
+var DefaultTables = Tables{
+	Issues:            "issues",
+	Users:             "users",
+	Posts:             "posts",
+	PostLikes:         "postlikes",
+	PostComments:      "postcomments",
+	IssueSupport:      "issuesupport",
+	Subscriptions:     "subscriptions",
+	Notifications:     "notifications",
+	ProcessedEvents:   "processedevents",
+	Connections:       "connections",
+	Webhooks:          "webhooks",
+	WebhookDeliveries: "webhookdeliveries",
+	SearchIndex:       "searchindex",
+}
+
+// StageProd is the production stage. Its tables keep the names they had
+// before there were stages, so that deploying it does not replace them.
+const StageProd = "prod"
+
+// StageTableName returns the name of a table in a stage: the name prefixed
+// with the stage, or the name itself in production and without a stage.
+func StageTableName(stage string, name string) string {
+	if stage == "" || stage == StageProd {
+		return name
+	}
+	return stage + "-" + name
+}
+
+// StageTables returns DefaultTables as named in a stage.
+func StageTables(stage string) Tables {
+	tables := DefaultTables
+	for _, v := range tables.variables() {
+		*v.Table = StageTableName(stage, *v.Table)
+	}
+	return tables
+}
+
+// tableVariable is the environment variable of a table name.
+type tableVariable struct {
+	Name  string
+	Table *string
+}
+
+func (t *Tables) variables() []tableVariable {
+	return []tableVariable{
+		{"ISSUES_TABLE", &t.Issues},
+		{"USERS_TABLE", &t.Users},
+		{"POSTS_TABLE", &t.Posts},
+		{"POSTLIKES_TABLE", &t.PostLikes},
+		{"POSTCOMMENTS_TABLE", &t.PostComments},
+		{"ISSUESUPPORT_TABLE", &t.IssueSupport},
+		{"SUBSCRIPTIONS_TABLE", &t.Subscriptions},
+		{"NOTIFICATIONS_TABLE", &t.Notifications},
+		{"PROCESSEDEVENTS_TABLE", &t.ProcessedEvents},
+		{"CONNECTIONS_TABLE", &t.Connections},
+		{"WEBHOOKS_TABLE", &t.Webhooks},
+		{"WEBHOOKDELIVERIES_TABLE", &t.WebhookDeliveries},
+		{"SEARCHINDEX_TABLE", &t.SearchIndex},
+	}
+}
+
+// Config is the configuration every function reads from its environment.
+type Config struct {
+	// Env is AWSENV, either EnvAWS, EnvSAMLocal or EnvMemory.
+	Env string
+	// DBEndpoint is DBENDPOINT, the DynamoDB endpoint used locally.
+	DBEndpoint string
+	// Region is AWS_REGION, which Lambda and "sam local" set.
+	Region string
+	// Stage is STAGE, the stage of the stack such as dev, staging or
+	// StageProd, empty outside a stack.
+	Stage string
+	// LogLevel is LOG_LEVEL, the level of the entries Log writes, info by
+	// default in AWS and debug elsewhere.
+	LogLevel string
+	Tables   Tables
+}
+
+// LoadConfig reads the configuration from the environment and validates
+// it. Outside AWS, the region and the table names that are not set default
+// to LocalRegion and the StageTables of the stage.
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Env:        os.Getenv("AWSENV"),
+		DBEndpoint: os.Getenv("DBENDPOINT"),
+		Region:     os.Getenv("AWS_REGION"),
+		Stage:      os.Getenv("STAGE"),
+		LogLevel:   os.Getenv("LOG_LEVEL"),
+	}
+	if cfg.Env == "" {
+		cfg.Env = EnvAWS
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = LevelDebug.String()
+		if cfg.Env == EnvAWS {
+			cfg.LogLevel = LevelInfo.String()
+		}
+	}
+	if cfg.Region == "" && cfg.Env != EnvAWS {
+		cfg.Region = LocalRegion
+	}
+	if cfg.Env != EnvAWS {
+		cfg.Tables = StageTables(cfg.Stage)
+	}
+	for _, v := range cfg.Tables.variables() {
+		if name := os.Getenv(v.Name); name != "" {
+			*v.Table = name
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// MustLoadConfig is LoadConfig for the cold start of a function, which
+// cannot run misconfigured. It also sets the level of Log.
+func MustLoadConfig() Config {
+	cfg, err := LoadConfig()
+	if err != nil {
+		Log.Errorf("%s", err)
+		panic(err.Error())
+	}
+	level, _ := ParseLevel(cfg.LogLevel)
+	Log.SetLevel(level)
+	return cfg
+}
+
+// regionPattern matches region names such as ap-south-1 or us-gov-west-1.
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// stagePattern matches stage names, which prefix table names.
+var stagePattern = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// tableNamePattern matches the table names DynamoDB accepts.
+var tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+// Validate reports every setting that is missing or malformed, naming the
+// environment variable to fix.
+func (c Config) Validate() error {
+	var problems []string
+	switch c.Env {
+	case EnvAWS, EnvSAMLocal, EnvMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("AWSENV is %q, expected %s, %s or %s", c.Env, EnvAWS, EnvSAMLocal, EnvMemory))
+	}
+	if c.Local() {
+		if u, err := url.Parse(c.DBEndpoint); c.DBEndpoint == "" || err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("DBENDPOINT is %q, expected the URL of DynamoDB Local such as http://localhost:8000", c.DBEndpoint))
+		}
+	}
+	switch {
+	case c.Region == "":
+		problems = append(problems, "AWS_REGION is not set")
+	case !regionPattern.MatchString(c.Region):
+		problems = append(problems, fmt.Sprintf("AWS_REGION is %q, expected a region such as %s", c.Region, LocalRegion))
+	}
+	if c.Stage != "" && !stagePattern.MatchString(c.Stage) {
+		problems = append(problems, fmt.Sprintf("STAGE is %q, expected a stage such as dev, staging or %s", c.Stage, StageProd))
+	}
+	if _, err := ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL is %q, expected %s", c.LogLevel, strings.Join(levelNames, ", ")))
+	}
+	for _, v := range c.Tables.variables() {
+		switch {
+		case *v.Table == "":
+			problems = append(problems, v.Name+" is not set")
+		case !tableNamePattern.MatchString(*v.Table):
+			problems = append(problems, fmt.Sprintf("%s is %q, which is not a DynamoDB table name", v.Name, *v.Table))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Local reports whether the function runs against DynamoDB Local.
+func (c Config) Local() bool {
+	return c.Env == EnvSAMLocal
+}
+
+// Memory reports whether the function keeps its data in memory instead of
+// DynamoDB. Functions without an in-memory store ignore it.
+func (c Config) Memory() bool {
+	return c.Env == EnvMemory
+}
+
+// NewSession returns an AWS session in the configured region.
+func NewSession(cfg Config) (*session.Session, error) {
+	return session.NewSession(aws.NewConfig().WithRegion(cfg.Region))
+}
+
+// NewDynamoDB returns a DynamoDB client, pointed at DBEndpoint when running
+// locally.
+func NewDynamoDB(cfg Config) (*dynamodb.DynamoDB, error) {
+	sess, err := NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Local() {
+		return dynamodb.New(sess, aws.NewConfig().WithEndpoint(cfg.DBEndpoint)), nil
+	}
+	return dynamodb.New(sess), nil
+}
+
+// MustNewDynamoDB is NewDynamoDB for the cold start of a function, which
+// cannot do anything without its client.
+func MustNewDynamoDB(cfg Config) *dynamodb.DynamoDB {
+	db, err := NewDynamoDB(cfg)
+	if err != nil {
+		panic("could not create the DynamoDB client: " + err.Error())
+	}
+	return db
+}
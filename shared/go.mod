@@ -0,0 +1,8 @@
+require (
+	github.com/aws/aws-lambda-go v1.13.3
+	github.com/aws/aws-sdk-go v1.34.13
+)
+
+module shared
+
+go 1.14
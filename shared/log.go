@@ -0,0 +1,200 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Level is how severe a log entry is. Entries below the level of a logger
+// are dropped.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of a name such as info, as LOG_LEVEL sets it.
+func ParseLevel(name string) (Level, error) {
+	for l, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(l), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// emailPattern matches email addresses, which are redacted from every entry.
+var emailPattern = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+
+// redactedEmail replaces the email addresses in entries.
+const redactedEmail = "[email]"
+
+// Logger writes log entries as JSON lines with the time, level and message
+// of the entry and the fields of the logger, such as the request IDs. Email
+// addresses in messages and fields are redacted.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  *Level
+	fields map[string]interface{}
+}
+
+// NewLogger returns a logger writing the entries of the given level and
+// above to out.
+func NewLogger(out io.Writer, level Level) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out, level: &level}
+}
+
+// Log is the logger of the function. Logged adds the fields of the request
+// being handled to it; MustLoadConfig sets its level.
+var Log = NewLogger(os.Stdout, LevelInfo)
+
+// SetLevel sets the level of the logger and of every logger derived from it
+// with With.
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.level = level
+}
+
+// Enabled reports whether entries of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= *l.level
+}
+
+// With returns a logger adding the field to every entry. A nil value
+// removes the field.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	if value == nil {
+		delete(fields, key)
+	} else {
+		fields[key] = value
+	}
+	return &Logger{mu: l.mu, out: l.out, level: l.level, fields: fields}
+}
+
+// WithError returns a logger adding the error to every entry, the logger
+// itself if err is nil.
+func (l *Logger) WithError(err error) *Logger {
+	if err == nil {
+		return l
+	}
+	return l.With("error", err.Error())
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args)
+}
+
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	entry := make(map[string]interface{}, len(l.fields)+3)
+	for key, value := range l.fields {
+		entry[key] = redact(value)
+	}
+	entry["time"] = FormatTime(time.Now())
+	entry["level"] = level.String()
+	entry["message"] = redact(fmt.Sprintf(format, args...))
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{
+			"time":    FormatTime(time.Now()),
+			"level":   LevelError.String(),
+			"message": "Failed to encode log entry " + err.Error(),
+		})
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+// redact replaces the email addresses of strings. Other values are logged
+// as they are.
+func redact(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return emailPattern.ReplaceAllString(s, redactedEmail)
+	}
+	return value
+}
+
+// LogInvocation makes Log add the Lambda request ID of the invocation in
+// ctx to its entries until the returned function is called at the end of
+// the invocation.
+func LogInvocation(ctx context.Context) (done func()) {
+	base := Log
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		Log = Log.With("requestId", lc.AwsRequestID)
+	}
+	return func() { Log = base }
+}
+
+// Logged logs every request of handler with its status and latency, and
+// while handling it adds the Lambda and API Gateway request IDs, the route
+// and the userId path parameter to the entries of Log. Like Lambda, it
+// expects one request at a time.
+func Logged(handler Handler) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		defer LogInvocation(ctx)()
+		Log = Log.With("apiRequestId", request.RequestContext.RequestID).
+			With("route", request.HTTPMethod+" "+request.Resource)
+		if userID := request.PathParameters["userId"]; userID != "" {
+			Log = Log.With("userId", userID)
+		}
+
+		response, err := handler(request)
+		entry := Log.WithError(err).
+			With("status", response.StatusCode).
+			With("latencyMs", time.Since(start).Milliseconds())
+		switch {
+		case err != nil || response.StatusCode >= http.StatusInternalServerError:
+			entry.Errorf("Request failed")
+		default:
+			entry.Infof("Request handled")
+		}
+		return response, err
+	}
+}
@@ -0,0 +1,81 @@
+package shared
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// allowedMethods are the methods any of the APIs accept from browsers.
+const allowedMethods = "OPTIONS,POST,GET,PUT,PATCH,DELETE"
+
+// Headers returns the CORS headers of every response.
+func Headers() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept, X-Api-Key",
+		"Access-Control-Allow-Methods": allowedMethods,
+	}
+}
+
+// Text returns a plain text response.
+func Text(status int, body string) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{StatusCode: status, Headers: Headers(), Body: body}
+}
+
+// Status returns a response whose body is the text of the status code.
+func Status(status int) events.APIGatewayProxyResponse {
+	return Text(status, http.StatusText(status))
+}
+
+// Error returns a response whose body is the error message.
+func Error(status int, err error) events.APIGatewayProxyResponse {
+	return Text(status, err.Error())
+}
+
+// JSON returns a response with v encoded as the body, or a 500 response if
+// v cannot be encoded.
+func JSON(status int, v interface{}) events.APIGatewayProxyResponse {
+	body, err := json.Marshal(v)
+	if err != nil {
+		Log.WithError(err).Errorf("Failed to encode response")
+		return Status(http.StatusInternalServerError)
+	}
+	response := Text(status, string(body))
+	response.Headers["Content-Type"] = "application/json"
+	return response
+}
+
+// IsJSON reports whether a request declares a JSON body.
+func IsJSON(request events.APIGatewayProxyRequest) bool {
+	for name, value := range request.Headers {
+		if http.CanonicalHeaderKey(name) == "Content-Type" && value == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler is the signature of the API Gateway handler of a function.
+type Handler func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// CORS answers browser preflight requests and adds the CORS headers to every
+// response of handler that does not set them itself.
+func CORS(handler Handler) Handler {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if request.HTTPMethod == http.MethodOptions {
+			return Text(http.StatusOK, ""), nil
+		}
+		response, err := handler(request)
+		if response.Headers == nil {
+			response.Headers = map[string]string{}
+		}
+		for name, value := range Headers() {
+			if _, ok := response.Headers[name]; !ok {
+				response.Headers[name] = value
+			}
+		}
+		return response, err
+	}
+}
@@ -0,0 +1,187 @@
+package shared
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// searchPrefixLength is the length of the term prefix used as the index
+	// partition key, and the length of the shortest term indexed.
+	searchPrefixLength = 2
+	titleTermWeight    = 3
+	bodyTermWeight     = 1
+	batchWriteLimit    = 25
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "has": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "that": true, "the": true, "this": true, "to": true, "was": true, "with": true,
+}
+
+// Tokenize splits text into lower-case, stemmed terms, dropping stop words
+// and terms too short to be indexed.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		term := stem(word)
+		if utf8.RuneCountInString(term) < searchPrefixLength {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// stem is a light English suffix-stripping stemmer. It only handles the
+// common inflections (plurals, -ing, -ed, -ly) so that "streetlights" and
+// "streetlight" or "flooding" and "flooded" land on the same term.
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		return undouble(word[:len(word)-3])
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		return undouble(word[:len(word)-2])
+	case len(word) > 4 && strings.HasSuffix(word, "ly"):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// undouble turns "runn" (from "running") back into "run".
+func undouble(word string) string {
+	n := len(word)
+	if n > 2 && word[n-1] == word[n-2] && !strings.ContainsRune("aeiouls", rune(word[n-1])) {
+		return word[:n-1]
+	}
+	return word
+}
+
+// TermPrefix returns the partition key of the index entries for a term.
+func TermPrefix(term string) string {
+	return string([]rune(term)[:searchPrefixLength])
+}
+
+// TermWeights returns the weight of every term in a document, counting title
+// terms more than body terms.
+func TermWeights(title string, body string) map[string]int {
+	weights := map[string]int{}
+	for _, term := range Tokenize(title) {
+		weights[term] += titleTermWeight
+	}
+	for _, term := range Tokenize(body) {
+		weights[term] += bodyTermWeight
+	}
+	return weights
+}
+
+// SearchIndex is the searchindex table the search function queries, which
+// holds one entry per distinct term of every issue and post. A failed index
+// write only costs search visibility or relevance, so callers log it rather
+// than fail the request that stored, edited or deleted the document.
+type SearchIndex struct {
+	DB    dynamodbiface.DynamoDBAPI
+	Table string
+}
+
+func termKey(term string, docType string, docID string) string {
+	return term + "#" + docType + "#" + docID
+}
+
+// Index adds one entry per distinct term of the document. created is when
+// the document was created or posted, which orders equally relevant results
+// and stays the same when an edited document is indexed again.
+func (s *SearchIndex) Index(docType string, docID string, created Time, title string, body string) error {
+	indexedAt := strconv.FormatInt(time.Now().Unix(), 10)
+	requests := make([]*dynamodb.WriteRequest, 0)
+	for term, weight := range TermWeights(title, body) {
+		item := map[string]*dynamodb.AttributeValue{
+			"Prefix":    {S: aws.String(TermPrefix(term))},
+			"TermKey":   {S: aws.String(termKey(term, docType, docID))},
+			"Term":      {S: aws.String(term)},
+			"DocType":   {S: aws.String(docType)},
+			"DocId":     {S: aws.String(docID)},
+			"Weight":    {N: aws.String(strconv.Itoa(weight))},
+			"IndexedAt": {N: aws.String(indexedAt)},
+		}
+		if !created.IsZero() {
+			item["Created"] = &dynamodb.AttributeValue{S: aws.String(created.String())}
+		}
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: item},
+		})
+	}
+	if err := BatchWrite(s.DB, s.Table, requests); err != nil {
+		return fmt.Errorf("could not index %s %s: %s", docType, docID, err)
+	}
+	return nil
+}
+
+// Unindex deletes the entries of the terms of the old title and body that
+// are not terms of the new ones. Pass empty new text to remove the document
+// from the index.
+func (s *SearchIndex) Unindex(docType string, docID string, oldTitle string, oldBody string, title string, body string) error {
+	current := TermWeights(title, body)
+	requests := make([]*dynamodb.WriteRequest, 0)
+	for term := range TermWeights(oldTitle, oldBody) {
+		if _, ok := current[term]; ok {
+			continue
+		}
+		requests = append(requests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{
+					"Prefix":  {S: aws.String(TermPrefix(term))},
+					"TermKey": {S: aws.String(termKey(term, docType, docID))},
+				},
+			},
+		})
+	}
+	if err := BatchWrite(s.DB, s.Table, requests); err != nil {
+		return fmt.Errorf("could not unindex %s %s: %s", docType, docID, err)
+	}
+	return nil
+}
+
+// BatchWrite writes the requests to a table in batches of 25, retrying
+// unprocessed items.
+func BatchWrite(db dynamodbiface.DynamoDBAPI, table string, requests []*dynamodb.WriteRequest) error {
+	for start := 0; start < len(requests); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(requests) {
+			end = len(requests)
+		}
+		pending := map[string][]*dynamodb.WriteRequest{table: requests[start:end]}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == 5 {
+				return fmt.Errorf("unprocessed items remain for table %s", table)
+			}
+			result, err := db.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = result.UnprocessedItems
+		}
+	}
+	return nil
+}
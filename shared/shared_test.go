@@ -0,0 +1,409 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func TestResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		response events.APIGatewayProxyResponse
+		status   int
+		body     string
+	}{
+		{"text", Text(http.StatusCreated, "stored"), 201, "stored"},
+		{"status", Status(http.StatusNotFound), 404, "Not Found"},
+		{"error", Error(http.StatusBadGateway, errors.New("table not found")), 502, "table not found"},
+		{"json", JSON(http.StatusOK, map[string]int{"count": 2}), 200, `{"count":2}`},
+		{"unencodable json", JSON(http.StatusOK, math.Inf(1)), 500, "Internal Server Error"},
+	}
+	for _, test := range tests {
+		if test.response.StatusCode != test.status || test.response.Body != test.body {
+			t.Errorf("%s = %d %q, want %d %q", test.name, test.response.StatusCode, test.response.Body, test.status, test.body)
+		}
+		if test.response.Headers["Access-Control-Allow-Origin"] != "*" {
+			t.Errorf("%s has no CORS headers: %v", test.name, test.response.Headers)
+		}
+	}
+	if got := JSON(http.StatusOK, nil).Headers["Content-Type"]; got != "application/json" {
+		t.Errorf("JSON content type = %q", got)
+	}
+}
+
+func TestIsJSON(t *testing.T) {
+	for headers, want := range map[string]bool{"content-type": true, "Content-Type": true, "CONTENT-TYPE": true, "Accept": false} {
+		request := events.APIGatewayProxyRequest{Headers: map[string]string{headers: "application/json"}}
+		if got := IsJSON(request); got != want {
+			t.Errorf("IsJSON(%s) = %v, want %v", headers, got, want)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	calls := 0
+	handler := CORS(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls++
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusTeapot,
+			Headers: map[string]string{"Access-Control-Allow-Origin": "https://humanunited.org"}}, nil
+	})
+
+	preflight, _ := handler(events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS"})
+	if preflight.StatusCode != http.StatusOK || preflight.Headers["Access-Control-Allow-Methods"] != allowedMethods || calls != 0 {
+		t.Errorf("preflight = %+v after %d calls", preflight, calls)
+	}
+	response, _ := handler(events.APIGatewayProxyRequest{HTTPMethod: "GET"})
+	if response.StatusCode != http.StatusTeapot || calls != 1 {
+		t.Errorf("response = %+v after %d calls", response, calls)
+	}
+	if response.Headers["Access-Control-Allow-Origin"] != "https://humanunited.org" || response.Headers["Access-Control-Allow-Methods"] != allowedMethods {
+		t.Errorf("headers = %v", response.Headers)
+	}
+}
+
+func TestNewDynamoDB(t *testing.T) {
+	db, err := NewDynamoDB(Config{Env: EnvSAMLocal, DBEndpoint: "http://localhost:8000", Region: LocalRegion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Endpoint != "http://localhost:8000" || *db.Config.Region != LocalRegion {
+		t.Errorf("local client uses %s in %s", db.Endpoint, *db.Config.Region)
+	}
+	db, err = NewDynamoDB(Config{Env: "AWS", Region: LocalRegion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Endpoint != "https://dynamodb.ap-south-1.amazonaws.com" {
+		t.Errorf("AWS client uses %s", db.Endpoint)
+	}
+}
+
+// setenv sets the given environment variables, unsetting every other
+// variable LoadConfig reads, until the test ends.
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+	names := []string{"AWSENV", "DBENDPOINT", "AWS_REGION", "STAGE", "LOG_LEVEL"}
+	for _, v := range new(Tables).variables() {
+		names = append(names, v.Name)
+	}
+	for _, name := range names {
+		old, ok := os.LookupEnv(name)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	setenv(t, map[string]string{"AWSENV": EnvSAMLocal, "DBENDPOINT": "http://localhost:8000", "POSTS_TABLE": "dev-posts"})
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultTables
+	want.Posts = "dev-posts"
+	if cfg.Region != LocalRegion || cfg.LogLevel != "debug" || cfg.Tables != want {
+		t.Errorf("local config = %+v", cfg)
+	}
+
+	setenv(t, map[string]string{"AWSENV": EnvMemory, "STAGE": "staging"})
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tables.Issues != "staging-issues" || cfg.Tables.SearchIndex != "staging-searchindex" {
+		t.Errorf("staging tables = %+v", cfg.Tables)
+	}
+	if tables := StageTables(StageProd); tables != DefaultTables {
+		t.Errorf("prod tables = %+v", tables)
+	}
+
+	env := map[string]string{"AWS_REGION": "eu-west-1", "LOG_LEVEL": "WARN"}
+	for _, v := range new(Tables).variables() {
+		env[v.Name] = "huManUnited-" + v.Name
+	}
+	setenv(t, env)
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != EnvAWS || cfg.Region != "eu-west-1" || cfg.LogLevel != "WARN" || cfg.Tables.Issues != "huManUnited-ISSUES_TABLE" {
+		t.Errorf("AWS config = %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"unknown env", map[string]string{"AWSENV": "PROD"}, []string{`AWSENV is "PROD"`}},
+		{"local without endpoint", map[string]string{"AWSENV": EnvSAMLocal}, []string{`DBENDPOINT is ""`}},
+		{"local with a bad endpoint", map[string]string{"AWSENV": EnvSAMLocal, "DBENDPOINT": "localhost"}, []string{`DBENDPOINT is "localhost"`}},
+		{"AWS without anything", nil, []string{"AWS_REGION is not set", "ISSUES_TABLE is not set", "SEARCHINDEX_TABLE is not set"}},
+		{"bad region", map[string]string{"AWSENV": EnvMemory, "AWS_REGION": "Mumbai"}, []string{`AWS_REGION is "Mumbai"`}},
+		{"bad stage", map[string]string{"AWSENV": EnvMemory, "STAGE": "Staging 2"}, []string{`STAGE is "Staging 2"`}},
+		{"bad log level", map[string]string{"AWSENV": EnvMemory, "LOG_LEVEL": "verbose"}, []string{`LOG_LEVEL is "verbose"`}},
+		{"bad table", map[string]string{"AWSENV": EnvMemory, "USERS_TABLE": "my users"}, []string{`USERS_TABLE is "my users"`}},
+	}
+	for _, test := range tests {
+		setenv(t, test.env)
+		_, err := LoadConfig()
+		if err == nil {
+			t.Errorf("%s: loaded", test.name)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: %s, want %s", test.name, err, want)
+			}
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2020, 9, 7, 6, 30, 0, 123000000, time.UTC)
+	for _, value := range []string{
+		"2020-09-07T06:30:00.123Z",
+		"2020-09-07T12:00:00.123+05:30",
+		"2020-09-07 12:00:00.123 +0530 IST",
+		"2020-09-07 12:00:00.123 +0530 IST m=+0.000100001",
+	} {
+		got, err := ParseTime(value)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseTime(%q) = %s, %v, want %s", value, got, err, want)
+		}
+		if formatted := FormatTime(got); formatted != "2020-09-07T06:30:00.123Z" {
+			t.Errorf("FormatTime(%s) = %s", got, formatted)
+		}
+	}
+	if _, err := ParseTime("last tuesday"); err == nil {
+		t.Error("parsed last tuesday")
+	}
+}
+
+func TestTimeEncoding(t *testing.T) {
+	type item struct {
+		Created Time  `json:"created"`
+		Edited  *Time `json:"edited,omitempty"`
+	}
+	var legacy item
+	if err := json.Unmarshal([]byte(`{"created": "2020-09-07 12:00:00.123 +0530 IST m=+0.0001"}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(legacy)
+	if err != nil || string(data) != `{"created":"2020-09-07T06:30:00.123Z"}` {
+		t.Errorf("json = %s, %v", data, err)
+	}
+	if data, _ := json.Marshal(item{}); string(data) != `{"created":null}` {
+		t.Errorf("zero json = %s", data)
+	}
+
+	av, err := dynamodbattribute.MarshalMap(legacy)
+	if err != nil || aws.StringValue(av["created"].S) != "2020-09-07T06:30:00.123Z" {
+		t.Errorf("item = %v, %v", av, err)
+	}
+	var decoded item
+	err = dynamodbattribute.UnmarshalMap(map[string]*dynamodb.AttributeValue{
+		"Created": {S: aws.String("2020-09-07 12:00:00.123 +0530 IST")},
+	}, &decoded)
+	if err != nil || decoded.Created != legacy.Created || decoded.Edited != nil {
+		t.Errorf("decoded = %+v, %v", decoded, err)
+	}
+	if err := dynamodbattribute.UnmarshalMap(map[string]*dynamodb.AttributeValue{"Created": {S: aws.String("soon")}}, &decoded); err == nil {
+		t.Error("decoded soon")
+	}
+}
+
+// logEntries decodes the JSON lines a logger wrote.
+func logEntries(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%q is not a JSON line: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewLogger(&out, LevelInfo)
+	logger.Debugf("dropped")
+	logger.With("userId", "u1").WithError(errors.New("no mail to kiran@example.com")).Warnf("Failed to notify %s", "Kiran.K+1@mail.example.in")
+	logger.SetLevel(LevelError)
+	logger.With("userId", "u1").Infof("dropped after SetLevel")
+
+	entries := logEntries(t, &out)
+	if len(entries) != 1 {
+		t.Fatalf("entries = %v", entries)
+	}
+	entry := entries[0]
+	if entry["level"] != "warn" || entry["userId"] != "u1" ||
+		entry["message"] != "Failed to notify [email]" || entry["error"] != "no mail to [email]" {
+		t.Errorf("entry = %v", entry)
+	}
+	if _, err := ParseTime(entry["time"].(string)); err != nil {
+		t.Errorf("time: %s", err)
+	}
+	if strings.Contains(out.String(), "@") {
+		t.Errorf("email not redacted: %s", out.String())
+	}
+
+	if level, err := ParseLevel("Error"); err != nil || level != LevelError {
+		t.Errorf("ParseLevel(Error) = %s, %v", level, err)
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Error("parsed trace")
+	}
+}
+
+func TestLogged(t *testing.T) {
+	var out bytes.Buffer
+	defer func(log *Logger) { Log = log }(Log)
+	Log = NewLogger(&out, LevelDebug)
+
+	handler := Logged(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		Log.Debugf("Handling")
+		if request.HTTPMethod == http.MethodDelete {
+			return events.APIGatewayProxyResponse{}, errors.New("broken")
+		}
+		return Status(http.StatusOK), nil
+	})
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:     http.MethodGet,
+		Resource:       "/users/{userId}",
+		PathParameters: map[string]string{"userId": "u1"},
+		RequestContext: events.APIGatewayProxyRequestContext{RequestID: "api-1"},
+	}
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "lambda-1"})
+	if response, err := handler(ctx, request); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("response = %v, %v", response, err)
+	}
+	request.HTTPMethod = http.MethodDelete
+	if _, err := handler(context.Background(), request); err == nil {
+		t.Fatal("error not returned")
+	}
+	Log.Infof("After")
+
+	entries := logEntries(t, &out)
+	if len(entries) != 5 {
+		t.Fatalf("entries = %v", entries)
+	}
+	for i, entry := range entries[:4] {
+		if entry["apiRequestId"] != "api-1" || entry["userId"] != "u1" || !strings.HasSuffix(entry["route"].(string), " /users/{userId}") {
+			t.Errorf("entry %d = %v", i, entry)
+		}
+	}
+	if entries[0]["requestId"] != "lambda-1" || entries[0]["message"] != "Handling" {
+		t.Errorf("handler entry = %v", entries[0])
+	}
+	if done := entries[1]; done["message"] != "Request handled" || done["level"] != "info" || done["status"] != float64(http.StatusOK) || done["latencyMs"] == nil {
+		t.Errorf("request entry = %v", done)
+	}
+	if failed := entries[3]; failed["level"] != "error" || failed["error"] != "broken" || failed["requestId"] != nil {
+		t.Errorf("failed request entry = %v", failed)
+	}
+	if after := entries[4]; after["apiRequestId"] != nil {
+		t.Errorf("request fields kept after the request: %v", after)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Streetlights broken on 5th cross", []string{"streetlight", "broken", "5th", "cross"}},
+		{"Flooding, flooded and FLOODS!", []string{"flood", "flood", "flood"}},
+		{"Running repairs", []string{"run", "repair"}},
+		{"a I of", []string{}},
+	}
+	for _, test := range tests {
+		if got := Tokenize(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+// batchDB records the items written with BatchWriteItem, leaving the first
+// item of every call unprocessed once.
+type batchDB struct {
+	dynamodbiface.DynamoDBAPI
+	calls   int
+	retried bool
+	puts    map[string]bool
+	deletes map[string]bool
+}
+
+func (db *batchDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	db.calls++
+	output := &dynamodb.BatchWriteItemOutput{}
+	for table, requests := range input.RequestItems {
+		if !db.retried {
+			db.retried = true
+			output.UnprocessedItems = map[string][]*dynamodb.WriteRequest{table: requests[:1]}
+			requests = requests[1:]
+		}
+		for _, request := range requests {
+			if request.PutRequest != nil {
+				db.puts[aws.StringValue(request.PutRequest.Item["TermKey"].S)] = true
+			} else {
+				db.deletes[aws.StringValue(request.DeleteRequest.Key["TermKey"].S)] = true
+			}
+		}
+	}
+	return output, nil
+}
+
+func TestSearchIndex(t *testing.T) {
+	db := &batchDB{puts: map[string]bool{}, deletes: map[string]bool{}}
+	index := &SearchIndex{DB: db, Table: "searchindex"}
+	if err := index.Index("post", "p1", Now(), "Flooded roads", "The roads near the lake"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"flood#post#p1": true, "road#post#p1": true, "near#post#p1": true, "lake#post#p1": true}
+	if !reflect.DeepEqual(db.puts, want) || db.calls != 2 {
+		t.Errorf("indexed %v in %d calls", db.puts, db.calls)
+	}
+	if err := index.Unindex("post", "p1", "Flooded roads", "The roads near the lake", "Flooded roads", ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"near#post#p1": true, "lake#post#p1": true}; !reflect.DeepEqual(db.deletes, want) {
+		t.Errorf("unindexed %v", db.deletes)
+	}
+	if weights := TermWeights("Flooded roads", "flooding"); weights["flood"] != titleTermWeight+bodyTermWeight {
+		t.Errorf("weights = %v", weights)
+	}
+}
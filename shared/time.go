@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// TimeLayout is the layout times are stored in: RFC 3339 in UTC with
+// milliseconds, so that stored times sort as strings.
+const TimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// legacyTimeLayout is the layout of time.Time.String(), which is how
+// creation and login times were stored before TimeLayout.
+const legacyTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// FormatTime formats a time to be stored.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
+
+// ParseTime parses a stored time, either RFC 3339 or in the
+// time.Time.String() format of older items, which may carry a monotonic
+// clock reading.
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if i := strings.Index(value, " m="); i >= 0 {
+		value = value[:i]
+	}
+	return time.Parse(legacyTimeLayout, value)
+}
+
+// Time is a time the functions store and return. It is stored and encoded
+// to JSON in TimeLayout and read in any layout ParseTime knows, so items
+// stored before TimeLayout still read. Times read are in UTC and to the
+// millisecond, so an item decodes the same before and after its times are
+// rewritten in TimeLayout. The zero Time is null.
+type Time struct {
+	time.Time
+}
+
+// Now returns the current time.
+func Now() Time {
+	return Time{time.Now()}
+}
+
+// String returns the time in TimeLayout, empty for the zero Time.
+func (t Time) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return FormatTime(t.Time)
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.String())
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var value *string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return t.parse(value)
+}
+
+func (t Time) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if t.IsZero() {
+		av.NULL = aws.Bool(true)
+		return nil
+	}
+	av.S = aws.String(t.String())
+	return nil
+}
+
+func (t *Time) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	return t.parse(av.S)
+}
+
+func (t *Time) parse(value *string) error {
+	if value == nil || *value == "" {
+		*t = Time{}
+		return nil
+	}
+	parsed, err := ParseTime(*value)
+	if err != nil {
+		return err
+	}
+	*t = Time{parsed.UTC().Truncate(time.Millisecond)}
+	return nil
+}
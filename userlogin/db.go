@@ -1,11 +1,10 @@
 package main
 
 import (
-	"fmt"
+	"shared"
 	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
@@ -13,27 +12,16 @@ import (
 
 var db *dynamodb.DynamoDB
 
-//const usersTable = "huManUnited-UsersTable-16HJ59LOVEINZ"
-var usersTable = "users"
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
 
-func createDBConnection(env string, endpoint string) {
-	if env == "AWS_SAM_LOCAL" {
-		sess, err := session.NewSession(&aws.Config{
-			Region:   aws.String("ap-south-1"),
-			Endpoint: aws.String(endpoint)})
-		if err != nil {
-			fmt.Println("Failed to create dynamodb session")
-
-		}
-		db = dynamodb.New(sess)
-	} else {
-		db = dynamodb.New(session.New(), aws.NewConfig().WithRegion("ap-south-1"))
-	}
-}
+// DynamoUserStore keeps users in the users table.
+type DynamoUserStore struct{}
 
-func getUsers() ([]*User, error) {
+func (s *DynamoUserStore) GetUsers() ([]*User, error) {
 	input := &dynamodb.ScanInput{
-		TableName: aws.String(usersTable),
+		TableName: aws.String(tables.Users),
 	}
 
 	result, err := db.Scan(input)
@@ -58,9 +46,9 @@ func getUsers() ([]*User, error) {
 	return users, nil
 }
 
-func putUser(user *User) error {
+func (s *DynamoUserStore) PutUser(user *User) error {
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(usersTable),
+		TableName: aws.String(tables.Users),
 		Item: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(user.ID),
@@ -72,7 +60,7 @@ func putUser(user *User) error {
 				S: aws.String(user.Email),
 			},
 			"JoinedDate": {
-				S: aws.String(user.JoinedDate),
+				S: aws.String(user.JoinedDate.String()),
 			},
 			"SamaritanPoints": {
 				N: aws.String(strconv.Itoa(user.SamaritanPoints)),
@@ -81,7 +69,7 @@ func putUser(user *User) error {
 				S: aws.String(user.ProfileImageUrl),
 			},
 			"LastLogin": {
-				S: aws.String(user.LastLogin),
+				S: aws.String(user.LastLogin.String()),
 			},
 		},
 	}
@@ -90,14 +78,14 @@ func putUser(user *User) error {
 	return err
 }
 
-func updateUserLastLogin(loginTime string, userID string) error {
+func (s *DynamoUserStore) UpdateLastLogin(loginTime shared.Time, userID string) error {
 	input := &dynamodb.UpdateItemInput{
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":l": {
-				S: aws.String(loginTime),
+				S: aws.String(loginTime.String()),
 			},
 		},
-		TableName: aws.String(usersTable),
+		TableName: aws.String(tables.Users),
 		Key: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(userID),
@@ -111,25 +99,26 @@ func updateUserLastLogin(loginTime string, userID string) error {
 	return err
 }
 
-func checkIfUserExists(usermail string) (*User, error) {
+// FindUserByEmail returns the id and points of the user with the given email,
+// or nil if no user has it.
+func (s *DynamoUserStore) FindUserByEmail(usermail string) (*User, error) {
 	filt := expression.Name("Email").Equal(expression.Value(usermail))
 	proj := expression.NamesList(expression.Name("Id"), expression.Name("SamaritanPoints"))
 	expr, err := expression.NewBuilder().WithFilter(filt).WithProjection(proj).Build()
 	if err != nil {
-		fmt.Println("Failed to build filter by email expression")
-		fmt.Println(err.Error())
+		shared.Log.WithError(err).Errorf("Failed to build filter by email expression")
 	}
 	input := &dynamodb.ScanInput{
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
 		ProjectionExpression:      expr.Projection(),
-		TableName:                 aws.String(usersTable),
+		TableName:                 aws.String(tables.Users),
 	}
 
 	result, err := db.Scan(input)
 	if err != nil {
-		fmt.Printf("Failed to scan the table %s using filter expression", usersTable)
+		shared.Log.WithError(err).Errorf("Failed to scan the table %s using filter expression", tables.Users)
 		return nil, err
 	}
 	if len(result.Items) == 0 {
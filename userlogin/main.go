@@ -2,10 +2,8 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
-	"time"
+	"shared"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -13,13 +11,13 @@ import (
 )
 
 type User struct {
-	ID              string `json:"id"`
-	Name            string `json:"name"`
-	Email           string `json:"email"`
-	ProfileImageUrl string `json:"imageurl"`
-	JoinedDate      string `json:"joineddate"`
-	LastLogin       string `json:"lastlogin"`
-	SamaritanPoints int    `json:"samaritanpoints"`
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	Email           string      `json:"email"`
+	ProfileImageUrl string      `json:"imageurl"`
+	JoinedDate      shared.Time `json:"joineddate"`
+	LastLogin       shared.Time `json:"lastlogin"`
+	SamaritanPoints int         `json:"samaritanpoints"`
 }
 
 type LoginResponse struct {
@@ -27,95 +25,51 @@ type LoginResponse struct {
 	SamaritanPoints int
 }
 
-func getHeaders() map[string]string {
-	return map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept",
-		"Access-Control-Allow-Methods": "OPTIONS,POST,GET"}
-}
-
-func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (s *Server) router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	switch req.HTTPMethod {
 	case "GET":
-		return fetch(req)
+		return s.fetch(req)
 	case "POST":
-		return insert(req)
-	case "OPTIONS":
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Headers:    getHeaders()}, nil
+		return s.insert(req)
 	default:
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed,
-			Headers: getHeaders(),
-			Body:    http.StatusText(http.StatusMethodNotAllowed)}, nil
+		return shared.Status(http.StatusMethodNotAllowed), nil
 	}
 }
 
-func fetch(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (s *Server) fetch(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	users, err := getUsers()
+	users, err := s.Store.GetUsers()
 	if err != nil {
 		//See if we can pass err instead
 
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadGateway,
-			Headers: getHeaders(),
-			Body:    err.Error()}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
 	}
-	users_json, err := json.Marshal(users)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Body:       http.StatusText(http.StatusInternalServerError),
-			Headers:    getHeaders()}, nil
-	}
-
-	return events.APIGatewayProxyResponse{
-		Body:       string(users_json),
-		Headers:    getHeaders(),
-		StatusCode: 201,
-	}, nil
+	return shared.JSON(http.StatusCreated, users), nil
 }
 
-func insert(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (s *Server) insert(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	if request.Headers["content-type"] != "application/json" && request.Headers["Content-Type"] != "application/json" {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusNotAcceptable,
-			Headers:    getHeaders(),
-			Body:       http.StatusText(http.StatusNotAcceptable)}, nil
+	if !shared.IsJSON(request) {
+		return shared.Status(http.StatusNotAcceptable), nil
 	}
 	user := new(User)
 	loginResponse := new(LoginResponse)
 	err := json.Unmarshal([]byte(request.Body), user)
-	currTime := time.Now().Local().String()
-	existingUser, err := checkIfUserExists(user.Email)
+	currTime := shared.Now()
+	existingUser, err := s.Store.FindUserByEmail(user.Email)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Headers:    getHeaders(),
-			Body:       fmt.Sprintf("Failed to check if user exists")}, nil
+		return shared.Text(http.StatusInternalServerError, "Failed to check if user exists"), nil
 	}
 
 	if existingUser != nil {
-		err = updateUserLastLogin(currTime, existingUser.ID)
+		err = s.Store.UpdateLastLogin(currTime, existingUser.ID)
 		if err != nil {
 			//See if we can pass err instead
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Headers:    getHeaders(),
-				Body:       err.Error()}, nil
+			return shared.Error(http.StatusInternalServerError, err), nil
 		}
 		loginResponse.UserID = existingUser.ID
 		loginResponse.SamaritanPoints = existingUser.SamaritanPoints
-		loginJson, err := json.Marshal(loginResponse)
-		if err != nil {
-			return events.APIGatewayProxyResponse{
-				StatusCode: http.StatusInternalServerError,
-				Body:       http.StatusText(http.StatusInternalServerError),
-				Headers:    getHeaders()}, nil
-		}
-		return events.APIGatewayProxyResponse{
-			StatusCode: 201,
-			Headers:    getHeaders(),
-			Body:       string(loginJson)}, nil
+		return shared.JSON(http.StatusCreated, loginResponse), nil
 	}
 
 	user.ID = uuid.New().String()
@@ -123,32 +77,22 @@ func insert(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespon
 	user.LastLogin = currTime
 	// default samaratian points - 10
 	user.SamaritanPoints = 10
-	err = putUser(user)
+	err = s.Store.PutUser(user)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Body:       http.StatusText(http.StatusInternalServerError),
-			Headers:    getHeaders()}, nil
+		return shared.Status(http.StatusInternalServerError), nil
 	}
 	loginResponse.UserID = user.ID
 	loginResponse.SamaritanPoints = user.SamaritanPoints
-	loginJson, err := json.Marshal(loginResponse)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Body:       http.StatusText(http.StatusInternalServerError),
-			Headers:    getHeaders()}, nil
-	}
-	return events.APIGatewayProxyResponse{
-		Body:       string(loginJson),
-		Headers:    getHeaders(),
-		StatusCode: 201,
-	}, nil
+	return shared.JSON(http.StatusCreated, loginResponse), nil
 }
 
 func main() {
-	env := os.Getenv("AWSENV")
-	dbEndpoint := os.Getenv("DBENDPOINT")
-	createDBConnection(env, dbEndpoint)
-	lambda.Start(router)
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	var store UserStore = NewMemoryUserStore()
+	if !cfg.Memory() {
+		db = shared.MustNewDynamoDB(cfg)
+		store = &DynamoUserStore{}
+	}
+	lambda.Start(shared.Logged(shared.CORS(NewServer(store).router)))
 }
@@ -1,64 +1,115 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"net/http"
-	"net/http/httptest"
+	"shared"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
-func TestHandler(t *testing.T) {
-	t.Run("Unable to get IP", func(t *testing.T) {
-		DefaultHTTPGetAddress = "http://127.0.0.1:12345"
-
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid request")
-		}
-	})
-
-	t.Run("Non 200 Response", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
-
-		DefaultHTTPGetAddress = ts.URL
-
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil && err.Error() != ErrNon200Response.Error() {
-			t.Fatalf("Error failed to trigger with an invalid HTTP response: %v", err)
-		}
-	})
-
-	t.Run("Unable decode IP", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
-
-		DefaultHTTPGetAddress = ts.URL
-
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid HTTP response")
-		}
-	})
+func apiRequest(method string, body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: method,
+		Path:       "/userlogin",
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       body,
+	}
+}
 
-	t.Run("Successful Request", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(200)
-			fmt.Fprintf(w, "127.0.0.1")
-		}))
-		defer ts.Close()
+// lastLogin is when Asha last signed in.
+var lastLogin = shared.Time{Time: time.Date(2020, 9, 7, 12, 0, 0, 0, time.UTC)}
 
-		DefaultHTTPGetAddress = ts.URL
+func newTestStore() *MemoryUserStore {
+	store := NewMemoryUserStore()
+	store.PutUser(&User{ID: "user-1", Name: "Asha", Email: "asha@example.org", SamaritanPoints: 25, LastLogin: lastLogin})
+	return store
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil {
-			t.Fatal("Everything should be ok")
-		}
-	})
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		status  int
+		check   func(t *testing.T, store *MemoryUserStore, response events.APIGatewayProxyResponse)
+	}{
+		{
+			name:    "list users",
+			request: apiRequest("GET", ""),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryUserStore, response events.APIGatewayProxyResponse) {
+				var users []*User
+				if err := json.Unmarshal([]byte(response.Body), &users); err != nil || len(users) != 1 || users[0].Email != "asha@example.org" {
+					t.Errorf("users = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "sign in existing user",
+			request: apiRequest("POST", `{"name": "Asha", "email": "asha@example.org"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryUserStore, response events.APIGatewayProxyResponse) {
+				login := new(LoginResponse)
+				if err := json.Unmarshal([]byte(response.Body), login); err != nil || login.UserID != "user-1" || login.SamaritanPoints != 25 {
+					t.Errorf("login = %s", response.Body)
+				}
+				if users, _ := store.GetUsers(); len(users) != 1 || !users[0].LastLogin.After(lastLogin.Time) {
+					t.Errorf("the last login was not updated: %+v", users)
+				}
+			},
+		},
+		{
+			name:    "sign up new user",
+			request: apiRequest("POST", `{"name": "Ravi", "email": "ravi@example.org", "imageurl": "https://example.org/ravi.png"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryUserStore, response events.APIGatewayProxyResponse) {
+				login := new(LoginResponse)
+				if err := json.Unmarshal([]byte(response.Body), login); err != nil || login.UserID == "" || login.SamaritanPoints != 10 {
+					t.Errorf("login = %s", response.Body)
+				}
+				user, _ := store.FindUserByEmail("ravi@example.org")
+				if user == nil || user.ID != login.UserID {
+					t.Errorf("the new user was not stored")
+				}
+			},
+		},
+		{
+			name:    "sign in without JSON",
+			request: events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/userlogin", Body: `{"email": "asha@example.org"}`},
+			status:  http.StatusNotAcceptable,
+		},
+		{
+			name:    "delete users",
+			request: apiRequest("DELETE", ""),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "preflight",
+			request: apiRequest("OPTIONS", ""),
+			status:  http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore()
+			response, err := shared.CORS(NewServer(store).router)(test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("status = %d %s, want %d", response.StatusCode, response.Body, test.status)
+			}
+			if response.Headers["Access-Control-Allow-Origin"] != "*" {
+				t.Errorf("missing CORS headers: %v", response.Headers)
+			}
+			if test.status < 300 && test.request.HTTPMethod != "OPTIONS" && response.Headers["Content-Type"] != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", response.Headers["Content-Type"])
+			}
+			if test.check != nil {
+				test.check(t, store, response)
+			}
+		})
+	}
 }
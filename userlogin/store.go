@@ -0,0 +1,82 @@
+package main
+
+import (
+	"shared"
+	"sort"
+	"sync"
+)
+
+// UserStore keeps the users that signed in.
+type UserStore interface {
+	GetUsers() ([]*User, error)
+	PutUser(user *User) error
+	UpdateLastLogin(loginTime shared.Time, userID string) error
+	// FindUserByEmail returns nil when no user has the email.
+	FindUserByEmail(email string) (*User, error)
+}
+
+// Server handles sign ins with the users kept in Store.
+type Server struct {
+	Store UserStore
+}
+
+func NewServer(store UserStore) *Server {
+	return &Server{Store: store}
+}
+
+// MemoryUserStore keeps users in memory, for the local server and tests.
+type MemoryUserStore struct {
+	mu    sync.Mutex
+	users map[string]*User
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[string]*User{}}
+}
+
+func (s *MemoryUserStore) GetUsers() ([]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like a scan of an empty table, no users are reported as nil.
+	if len(s.users) == 0 {
+		return nil, nil
+	}
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		c := *user
+		users = append(users, &c)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *MemoryUserStore) PutUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *user
+	s.users[user.ID] = &c
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateLastLogin(loginTime shared.Time, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		user = &User{ID: userID}
+		s.users[userID] = user
+	}
+	user.LastLogin = loginTime
+	return nil
+}
+
+func (s *MemoryUserStore) FindUserByEmail(email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == email {
+			return &User{ID: user.ID, SamaritanPoints: user.SamaritanPoints}, nil
+		}
+	}
+	return nil, nil
+}
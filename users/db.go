@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"shared"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
@@ -12,29 +16,43 @@ import (
 
 var db *dynamodb.DynamoDB
 
-//const usersTable = "huManUnited-UsersTable-16HJ59LOVEINZ"
-var usersTable = "users"
-var postsTable = "posts"
-var issuesTable = "issues"
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
 
-func createDBConnection(env string, endpoint string) {
-	if env == "AWS_SAM_LOCAL" {
-		sess, err := session.NewSession(&aws.Config{
-			Region:   aws.String("ap-south-1"),
-			Endpoint: aws.String(endpoint)})
-		if err != nil {
-			fmt.Println("Failed to create dynamodb session")
-
-		}
-		db = dynamodb.New(sess)
-	} else {
-		db = dynamodb.New(session.New(), aws.NewConfig().WithRegion("ap-south-1"))
-	}
+// searchIndex is the index the search function finds posts in.
+func searchIndex() *shared.SearchIndex {
+	return &shared.SearchIndex{DB: db, Table: tables.SearchIndex}
 }
 
-func getUsers() ([]*User, error) {
+const batchGetLimit = 100
+
+const (
+	userPostsIndex = "UserPostsIndex"
+	feedIndex      = "FeedIndex"
+	// feedKey is the FeedIndex partition key of every post, so that the index
+	// holds all posts ordered by PostTime. Posts stored before the index
+	// existed get it from the dbctl migration 0007-posts-feedkey-backfill.
+	feedKey = "post"
+	// storiesIndex holds the posts linked to an issue, i.e. its stories.
+	storiesIndex = "IssueStoriesIndex"
+)
+
+// storyBonusPoints are the Samaritan Points the author of the first story
+// about a resolved issue earns.
+const storyBonusPoints = 5
+
+// DynamoUserStore reads users from the users table, and the issues and
+// notifications that concern them from the tables alongside it.
+type DynamoUserStore struct{}
+
+// DynamoPostStore keeps posts in the posts table, with their likes and
+// comments in the postlikes and postcomments tables.
+type DynamoPostStore struct{}
+
+func (s *DynamoUserStore) GetUsers() ([]*User, error) {
 	input := &dynamodb.ScanInput{
-		TableName: aws.String(usersTable),
+		TableName: aws.String(tables.Users),
 	}
 
 	result, err := db.Scan(input)
@@ -59,109 +77,544 @@ func getUsers() ([]*User, error) {
 	return users, nil
 }
 
-func addPost(post *Post) error {
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(postsTable),
-		Item: map[string]*dynamodb.AttributeValue{
+// AddPost stores a new post. bonusPoints are Samaritan Points awarded to the
+// author in the same transaction, for the first story about an issue. The
+// issues the author earned them for are kept in StoryIssues on the user, and
+// the transaction is conditional on the issue not being there yet, so two
+// stories posted at once cannot both earn them; the second is stored without
+// the points.
+func (s *DynamoPostStore) AddPost(post *Post, bonusPoints int) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"Id": {
+			S: aws.String(post.ID),
+		},
+		"Title": {
+			S: aws.String(post.Title),
+		},
+		"Description": {
+			S: aws.String(post.Description),
+		},
+		"PostTime": {
+			S: aws.String(post.PostTime.String()),
+		},
+		"FeedKey": {
+			S: aws.String(feedKey),
+		},
+		"UserId": {
+			S: aws.String(post.UserId),
+		},
+	}
+	if post.IssueID != "" {
+		item["IssueId"] = &dynamodb.AttributeValue{S: aws.String(post.IssueID)}
+	}
+	if len(post.Tagged) > 0 {
+		item["Tagged"] = &dynamodb.AttributeValue{SS: aws.StringSlice(post.Tagged)}
+	}
+
+	var err error
+	if bonusPoints > 0 && post.IssueID != "" {
+		_, err = db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{
+					Put: &dynamodb.Put{
+						TableName: aws.String(tables.Posts),
+						Item:      item,
+					},
+				},
+				{
+					Update: &dynamodb.Update{
+						TableName: aws.String(tables.Users),
+						Key: map[string]*dynamodb.AttributeValue{
+							"Id": {
+								S: aws.String(post.UserId),
+							},
+						},
+						ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+							":p": {
+								N: aws.String(strconv.Itoa(bonusPoints)),
+							},
+							":i": {
+								S: aws.String(post.IssueID),
+							},
+							":is": {
+								SS: aws.StringSlice([]string{post.IssueID}),
+							},
+						},
+						ConditionExpression: aws.String("attribute_exists(Id) AND NOT contains(StoryIssues, :i)"),
+						UpdateExpression:    aws.String("ADD SamaritanPoints :p, StoryIssues :is"),
+						// The old user tells an existing user who already
+						// earned the points from one that does not exist.
+						ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+					},
+				},
+			},
+		})
+		if bonusAwarded(err) {
+			bonusPoints = 0
+		}
+	}
+	if bonusPoints == 0 {
+		_, err = db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(tables.Posts),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if err := searchIndex().Index("post", post.ID, post.PostTime, post.Title, post.Description); err != nil {
+		shared.Log.WithError(err).Errorf("Failed to index post %s", post.ID)
+	}
+	return nil
+}
+
+// bonusAwarded reports whether a story transaction was cancelled because the
+// author, who exists, already earned the points for the issue.
+func bonusAwarded(err error) bool {
+	cancelled, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok || len(cancelled.CancellationReasons) < 2 {
+		return false
+	}
+	user := cancelled.CancellationReasons[1]
+	return user.Code != nil && *user.Code == "ConditionalCheckFailed" && len(user.Item) > 0
+}
+
+// GetStoryIssue reads the fields of an issue a story is checked against. It
+// returns nil if the issue does not exist.
+func (s *DynamoPostStore) GetStoryIssue(issueId string) (*Issue, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(tables.Issues),
+		Key: map[string]*dynamodb.AttributeValue{
 			"Id": {
-				S: aws.String(post.ID),
+				S: aws.String(issueId),
 			},
-			"Title": {
-				S: aws.String(post.Title),
+		},
+		ProjectionExpression: aws.String("Id, Title, StatusMsg, Accepted"),
+	}
+	result, err := db.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	issue := new(Issue)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, issue); err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+// HasStory reports whether a user already wrote a story about an issue.
+func (s *DynamoPostStore) HasStory(issueId string, userId string) (bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.Posts),
+		IndexName:              aws.String(storiesIndex),
+		KeyConditionExpression: aws.String("IssueId = :i"),
+		FilterExpression:       aws.String("UserId = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":i": {
+				S: aws.String(issueId),
 			},
-			"Description": {
-				S: aws.String(post.Description),
+			":u": {
+				S: aws.String(userId),
 			},
-			"PostTime": {
-				S: aws.String(post.PostTime),
+		},
+		Select: aws.String(dynamodb.SelectCount),
+	}
+	found := false
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		found = aws.Int64Value(page.Count) > 0
+		return !found
+	})
+	return found, err
+}
+
+func (s *DynamoPostStore) GetPost(postId string) (*Post, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(tables.Posts),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(postId),
 			},
-			"UserId": {
-				S: aws.String(post.UserId),
+		},
+	}
+	result, err := db.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	post := new(Post)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func conditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// UpdatePost changes the given fields of a post written by post.UserId and
+// stamps it as edited. It returns nil if the post no longer exists.
+func (s *DynamoPostStore) UpdatePost(post *Post, title *string, description *string, edited shared.Time) (*Post, error) {
+	update := "SET Edited = :e"
+	values := map[string]*dynamodb.AttributeValue{
+		":e": {
+			S: aws.String(edited.String()),
+		},
+		":u": {
+			S: aws.String(post.UserId),
+		},
+	}
+	if title != nil {
+		update += ", Title = :t"
+		values[":t"] = &dynamodb.AttributeValue{S: aws.String(*title)}
+	}
+	if description != nil {
+		update += ", Description = :d"
+		values[":d"] = &dynamodb.AttributeValue{S: aws.String(*description)}
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tables.Posts),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(post.ID),
 			},
 		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("UserId = :u"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String("ALL_NEW"),
+	}
+	result, err := db.UpdateItem(input)
+	if conditionFailed(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	editedPost := new(Post)
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, editedPost); err != nil {
+		return nil, err
 	}
+	if err := searchIndex().Unindex("post", post.ID, post.Title, post.Description, editedPost.Title, editedPost.Description); err != nil {
+		shared.Log.WithError(err).Errorf("Failed to unindex post %s", post.ID)
+	}
+	if err := searchIndex().Index("post", post.ID, editedPost.PostTime, editedPost.Title, editedPost.Description); err != nil {
+		shared.Log.WithError(err).Errorf("Failed to index post %s", post.ID)
+	}
+	return editedPost, nil
+}
 
-	_, err := db.PutItem(input)
-	return err
+// DeletePost deletes a post written by post.UserId, reporting false if it no
+// longer exists.
+func (s *DynamoPostStore) DeletePost(post *Post) (bool, error) {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(tables.Posts),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(post.ID),
+			},
+		},
+		ConditionExpression: aws.String("UserId = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":u": {
+				S: aws.String(post.UserId),
+			},
+		},
+	}
+	_, err := db.DeleteItem(input)
+	if conditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := searchIndex().Unindex("post", post.ID, post.Title, post.Description, "", ""); err != nil {
+		shared.Log.WithError(err).Errorf("Failed to unindex post %s", post.ID)
+	}
+	return true, nil
 }
 
-func getPostsByUserId(userId string) ([]*Post, error) {
-	filt := expression.Name("UserId").Equal(expression.Value(userId))
-	expr, err := expression.NewBuilder().WithFilter(filt).Build()
+// Like records that a user likes a post and counts the like on the
+// post. It returns false if the user already liked the post.
+func (s *DynamoPostStore) Like(post *Post, userId string) (bool, error) {
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(tables.PostLikes),
+					Item: map[string]*dynamodb.AttributeValue{
+						"PostId": {
+							S: aws.String(post.ID),
+						},
+						"UserId": {
+							S: aws.String(userId),
+						},
+						"AuthorId": {
+							S: aws.String(post.UserId),
+						},
+						"PostTitle": {
+							S: aws.String(post.Title),
+						},
+						"Created": {
+							S: aws.String(shared.FormatTime(time.Now())),
+						},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(UserId)"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tables.Posts),
+					Key: map[string]*dynamodb.AttributeValue{
+						"Id": {
+							S: aws.String(post.ID),
+						},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":one": {
+							N: aws.String("1"),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(Id)"),
+					UpdateExpression:    aws.String("ADD LikeCount :one"),
+				},
+			},
+		},
+	}
+	_, err := db.TransactWriteItems(input)
+	if alreadyDone(err) {
+		return false, nil
+	}
 	if err != nil {
-		fmt.Println("Failed to build filter by email expression ")
-		fmt.Println(err.Error())
+		return false, err
 	}
-	input := &dynamodb.ScanInput{
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		FilterExpression:          expr.Filter(),
-		ProjectionExpression:      expr.Projection(),
-		TableName:                 aws.String(postsTable),
+	return true, nil
+}
+
+// Unlike undoes Like. It returns false if the user did not like the
+// post.
+func (s *DynamoPostStore) Unlike(post *Post, userId string) (bool, error) {
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(tables.PostLikes),
+					Key: map[string]*dynamodb.AttributeValue{
+						"PostId": {
+							S: aws.String(post.ID),
+						},
+						"UserId": {
+							S: aws.String(userId),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(UserId)"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tables.Posts),
+					Key: map[string]*dynamodb.AttributeValue{
+						"Id": {
+							S: aws.String(post.ID),
+						},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":minusone": {
+							N: aws.String("-1"),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(Id)"),
+					UpdateExpression:    aws.String("ADD LikeCount :minusone"),
+				},
+			},
+		},
+	}
+	_, err := db.TransactWriteItems(input)
+	if alreadyDone(err) {
+		return false, nil
 	}
-	result, err := db.Scan(input)
-	fmt.Println("Kiran: 3 %s", result)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	if len(result.Items) == 0 {
-		return nil, nil
+	return true, nil
+}
+
+// alreadyDone reports whether a like transaction was cancelled because its
+// first item, the like itself, failed its condition.
+func alreadyDone(err error) bool {
+	cancelled, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok || len(cancelled.CancellationReasons) == 0 {
+		return false
 	}
-	posts := make([]*Post, 0)
-	for _, i := range result.Items {
-		post := new(Post)
-		err = dynamodbattribute.UnmarshalMap(i, &post)
+	first := cancelled.CancellationReasons[0]
+	return first.Code != nil && *first.Code == "ConditionalCheckFailed"
+}
 
-		if err != nil {
-			return nil, err
-		}
+// AddComment stores a comment on a post and counts it on the post.
+func (s *DynamoPostStore) AddComment(post *Post, comment *PostComment) error {
+	item, err := dynamodbattribute.MarshalMap(comment)
+	if err != nil {
+		return err
+	}
+	item["AuthorId"] = &dynamodb.AttributeValue{S: aws.String(post.UserId)}
+	item["PostTitle"] = &dynamodb.AttributeValue{S: aws.String(post.Title)}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(tables.PostComments),
+					Item:      item,
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(tables.Posts),
+					Key: map[string]*dynamodb.AttributeValue{
+						"Id": {
+							S: aws.String(post.ID),
+						},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":one": {
+							N: aws.String("1"),
+						},
+					},
+					ConditionExpression: aws.String("attribute_exists(Id)"),
+					UpdateExpression:    aws.String("ADD CommentCount :one"),
+				},
+			},
+		},
+	}
+	_, err = db.TransactWriteItems(input)
+	return err
+}
 
-		posts = append(posts, post)
+// GetComments returns a page of the comments on a post, oldest first.
+func (s *DynamoPostStore) GetComments(postId string, cursor string, limit int) (*PostCommentsPage, error) {
+	start, err := decodeCursor(cursor, "PostId", "CommentId")
+	if err != nil {
+		return nil, err
 	}
-	return posts, nil
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.PostComments),
+		KeyConditionExpression: aws.String("PostId = :p"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {
+				S: aws.String(postId),
+			},
+		},
+		ScanIndexForward: aws.Bool(true),
+	}
+	page := &PostCommentsPage{Comments: []*PostComment{}}
+	page.Cursor, err = queryPage(input, start, limit, &page.Comments)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
 }
 
-func getAllPosts() ([]*Post, error) {
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(postsTable),
+// GetFeed returns a page of all posts, newest first.
+func (s *DynamoPostStore) GetFeed(cursor string, limit int) (*PostsPage, error) {
+	start, err := decodeCursor(cursor, "Id", "FeedKey", "PostTime")
+	if err != nil {
+		return nil, err
 	}
-	result, err := db.Scan(input)
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.Posts),
+		IndexName:              aws.String(feedIndex),
+		KeyConditionExpression: aws.String("FeedKey = :f"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": {
+				S: aws.String(feedKey),
+			},
+		},
+	}
+	return getPostsPage(input, start, limit)
+}
+
+// GetPostsByUser returns a page of the posts of a user, newest first.
+func (s *DynamoPostStore) GetPostsByUser(userId string, cursor string, limit int) (*PostsPage, error) {
+	start, err := decodeCursor(cursor, "Id", "UserId", "PostTime")
 	if err != nil {
 		return nil, err
 	}
-	if len(result.Items) == 0 {
-		return nil, nil
+	if start != nil && start["UserId"] != userId {
+		return nil, errInvalidCursor
 	}
-	posts := make([]*Post, 0)
-	for _, i := range result.Items {
-		post := new(Post)
-		err = dynamodbattribute.UnmarshalMap(i, &post)
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.Posts),
+		IndexName:              aws.String(userPostsIndex),
+		KeyConditionExpression: aws.String("UserId = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":u": {
+				S: aws.String(userId),
+			},
+		},
+	}
+	return getPostsPage(input, start, limit)
+}
 
-		if err != nil {
-			return nil, err
+// queryPage runs a query starting after the given key and unmarshals the
+// items into the slice items points to. It returns the cursor of the next
+// page, which is empty on the last page.
+func queryPage(input *dynamodb.QueryInput, start map[string]string, limit int, items interface{}) (string, error) {
+	input.Limit = aws.Int64(int64(limit))
+	if start != nil {
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{}
+		for name, value := range start {
+			input.ExclusiveStartKey[name] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+	}
+	result, err := db.Query(input)
+	if err != nil {
+		return "", err
+	}
+	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, items); err != nil {
+		return "", err
+	}
+	last := map[string]string{}
+	for name, value := range result.LastEvaluatedKey {
+		if value.S == nil {
+			return "", fmt.Errorf("unexpected key attribute %s", name)
 		}
+		last[name] = *value.S
+	}
+	return encodeCursor(last), nil
+}
 
-		posts = append(posts, post)
+// getPostsPage runs a query on a posts index newest first.
+func getPostsPage(input *dynamodb.QueryInput, start map[string]string, limit int) (*PostsPage, error) {
+	input.ScanIndexForward = aws.Bool(false)
+	page := &PostsPage{Posts: []*Post{}}
+	cursor, err := queryPage(input, start, limit, &page.Posts)
+	if err != nil {
+		return nil, err
 	}
-	return posts, nil
+	page.Cursor = cursor
+	return page, nil
 }
 
-func getIssuesCreatedByUser(userId string) ([]*Issue, error) {
+func (s *DynamoUserStore) GetIssuesCreatedBy(userId string) ([]*Issue, error) {
 	// get userid and filter by userid
 	// return issue data with projection
 	filt := expression.Name("UserID").Equal(expression.Value(userId))
 	proj := expression.NamesList(expression.Name("Id"), expression.Name("Title"), expression.Name("StatusMsg"))
 	expr, err := expression.NewBuilder().WithProjection(proj).WithFilter(filt).Build()
 	if err != nil {
-		fmt.Println("Failed to build filter by userId expression ")
-		fmt.Println(err.Error())
+		shared.Log.WithError(err).Errorf("Failed to build filter by userId expression")
 	}
 	input := &dynamodb.ScanInput{
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
 		ProjectionExpression:      expr.Projection(),
-		TableName:                 aws.String(issuesTable),
+		TableName:                 aws.String(tables.Issues),
 	}
 	result, err := db.Scan(input)
 	if err != nil {
@@ -182,22 +635,21 @@ func getIssuesCreatedByUser(userId string) ([]*Issue, error) {
 	return issues, nil
 }
 
-func getIssuesHelpedByUser(userId string, userName string) ([]*Issue, error) {
+func (s *DynamoUserStore) GetIssuesHelpedBy(userId string, userName string) ([]*Issue, error) {
 	// check for userid and username in issues table
 	//  return issue data with projection
 	filt := expression.Name("Helpers." + userId).Contains(userName)
 	proj := expression.NamesList(expression.Name("Id"), expression.Name("Title"), expression.Name("StatusMsg"))
 	expr, err := expression.NewBuilder().WithProjection(proj).WithFilter(filt).Build()
 	if err != nil {
-		fmt.Println("Failed to build filter by Helpers expression ")
-		fmt.Println(err.Error())
+		shared.Log.WithError(err).Errorf("Failed to build filter by Helpers expression")
 	}
 	input := &dynamodb.ScanInput{
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
 		ProjectionExpression:      expr.Projection(),
-		TableName:                 aws.String(issuesTable),
+		TableName:                 aws.String(tables.Issues),
 	}
 	result, err := db.Scan(input)
 	if err != nil {
@@ -216,24 +668,225 @@ func getIssuesHelpedByUser(userId string, userName string) ([]*Issue, error) {
 		issues = append(issues, issue)
 	}
 	return issues, nil
-	return nil, nil
 }
 
-func getUserById(userId string) (*User, error) {
+// GetSubscribedIssues returns the issues a user follows.
+func (s *DynamoUserStore) GetSubscribedIssues(userId string) ([]*Issue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.Subscriptions),
+		KeyConditionExpression: aws.String("UserId = :u"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":u": {
+				S: aws.String(userId),
+			},
+		},
+	}
+	keys := make([]map[string]*dynamodb.AttributeValue, 0)
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{"Id": i["IssueId"]})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	issues := make([]*Issue, 0, len(keys))
+	for start := 0; start < len(keys); start += batchGetLimit {
+		end := start + batchGetLimit
+		if end > len(keys) {
+			end = len(keys)
+		}
+		pending := map[string]*dynamodb.KeysAndAttributes{
+			tables.Issues: {
+				Keys:                 keys[start:end],
+				ProjectionExpression: aws.String("Id, Title, StatusMsg"),
+			},
+		}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == 5 {
+				return nil, fmt.Errorf("could not read all subscribed issues of user %s", userId)
+			}
+			result, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range result.Responses[tables.Issues] {
+				issue := new(Issue)
+				err = dynamodbattribute.UnmarshalMap(i, &issue)
+				if err != nil {
+					return nil, err
+				}
+				issues = append(issues, issue)
+			}
+			pending = result.UnprocessedKeys
+		}
+	}
+	return issues, nil
+}
+
+// GetNotifications returns a user's notifications, newest first. Items past
+// their expiry are skipped because DynamoDB TTL deletes them lazily.
+func (s *DynamoUserStore) GetNotifications(userId string, unreadOnly bool) ([]*Notification, error) {
+	filter := "ExpiresAt > :now"
+	values := map[string]*dynamodb.AttributeValue{
+		":u": {
+			S: aws.String(userId),
+		},
+		":now": {
+			N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+		},
+	}
+	names := map[string]*string(nil)
+	if unreadOnly {
+		filter += " and #r = :false"
+		values[":false"] = &dynamodb.AttributeValue{BOOL: aws.Bool(false)}
+		names = map[string]*string{"#r": aws.String("Read")}
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(tables.Notifications),
+		KeyConditionExpression:    aws.String("UserId = :u"),
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(false),
+	}
+	notifications := make([]*Notification, 0)
+	var unmarshalErr error
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			notification := new(Notification)
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(i, notification); unmarshalErr != nil {
+				return false
+			}
+			notifications = append(notifications, notification)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return notifications, unmarshalErr
+}
+
+func (s *DynamoUserStore) MarkNotificationRead(userId string, notificationId string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tables.Notifications),
+		Key: map[string]*dynamodb.AttributeValue{
+			"UserId": {
+				S: aws.String(userId),
+			},
+			"NotificationId": {
+				S: aws.String(notificationId),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#r": aws.String("Read"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true": {
+				BOOL: aws.Bool(true),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(NotificationId)"),
+		UpdateExpression:    aws.String("set #r = :true"),
+	}
+	_, err := db.UpdateItem(input)
+	if err != nil && strings.Contains(err.Error(), "The conditional request failed") {
+		// Unknown or already expired notifications are simply skipped.
+		return nil
+	}
+	return err
+}
+
+// UpdateEmailOptOut stores the email categories a user opted out of.
+func (s *DynamoUserStore) UpdateEmailOptOut(userId string, optOut []string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tables.Users),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(userId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(Id)"),
+	}
+	// String sets may not contain duplicates.
+	unique := make([]string, 0, len(optOut))
+	seen := map[string]bool{}
+	for _, category := range optOut {
+		if !seen[category] {
+			seen[category] = true
+			unique = append(unique, category)
+		}
+	}
+	optOut = unique
+	if len(optOut) == 0 {
+		// DynamoDB does not store empty sets.
+		input.UpdateExpression = aws.String("remove EmailOptOut")
+	} else {
+		input.UpdateExpression = aws.String("set EmailOptOut = :o")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":o": {
+				SS: aws.StringSlice(optOut),
+			},
+		}
+	}
+	_, err := db.UpdateItem(input)
+	return err
+}
+
+func (s *DynamoUserStore) UpdateDigestPreferences(userId string, digest *DigestRequest) error {
+	interests, err := dynamodbattribute.MarshalList(digest.Interests)
+	if err != nil {
+		return err
+	}
+	if interests == nil {
+		interests = []*dynamodb.AttributeValue{}
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tables.Users),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(userId),
+			},
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l": {
+				S: aws.String(digest.Location),
+			},
+			":i": {
+				L: interests,
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(Id)"),
+	}
+	if digest.Frequency == "" {
+		// Without a frequency attribute the user is left out of every digest scan.
+		input.UpdateExpression = aws.String("set Location = :l, Interests = :i remove DigestFrequency")
+	} else {
+		input.UpdateExpression = aws.String("set Location = :l, Interests = :i, DigestFrequency = :f")
+		input.ExpressionAttributeValues[":f"] = &dynamodb.AttributeValue{S: aws.String(digest.Frequency)}
+	}
+	_, err = db.UpdateItem(input)
+	return err
+}
+
+func (s *DynamoUserStore) GetUser(userId string) (*User, error) {
 	input := &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"Id": {
 				S: aws.String(userId),
 			},
 		},
-		TableName: aws.String(usersTable),
+		TableName: aws.String(tables.Users),
 	}
 	result, err := db.GetItem(input)
 	if err != nil {
-		fmt.Printf("Failed to get Item from table %s for %s", usersTable, userId)
+		shared.Log.WithError(err).Errorf("Failed to get Item from table %s for %s", tables.Users, userId)
 		return nil, err
 	}
-	if result == nil {
+	if len(result.Item) == 0 {
 		return nil, nil
 	}
 	user := new(User)
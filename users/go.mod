@@ -2,8 +2,17 @@ require (
 	github.com/aws/aws-lambda-go v1.13.3
 	github.com/aws/aws-sdk-go v1.34.13
 	github.com/google/uuid v1.1.1
+	mailer v0.0.0
+	moderation v0.0.0
+	shared v0.0.0
 )
 
+replace mailer => ../mailer
+
+replace moderation => ../moderation
+
+replace shared => ../shared
+
 module issues
 
 go 1.14
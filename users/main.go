@@ -3,10 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"mailer"
+	"moderation"
 	"net/http"
-	"os"
+	"shared"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -14,31 +15,98 @@ import (
 )
 
 type Issue struct {
-	ID        string `json:"id"`
-	Title     string `json:"title"`
-	StatusMsg string `json:"statusmsg"`
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	StatusMsg string   `json:"statusmsg"`
+	Accepted  []string `json:"-"`
 }
 
+const statusResolved = "Resolved"
+
 type User struct {
-	ID              string   `json:"id"`
-	Name            string   `json:"name"`
-	Email           string   `json:"email"`
-	ProfileImageUrl string   `json:"profileimageurl"`
-	JoinedDate      string   `json:"joineddate"`
-	LastLogin       string   `json:"lastlogin"`
-	SamaritanPoints int      `json:"samaritanpoints"`
-	UserIssues      []*Issue `json:"userissues"`
-	UserHelps       []*Issue `json:"userhelps"`
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	Email           string      `json:"email"`
+	ProfileImageUrl string      `json:"profileimageurl"`
+	JoinedDate      shared.Time `json:"joineddate"`
+	LastLogin       shared.Time `json:"lastlogin"`
+	SamaritanPoints int         `json:"samaritanpoints"`
+	EmailOptOut     []string    `json:"emailoptout"`
+	Location        string      `json:"location"`
+	Interests       []string    `json:"interests"`
+	DigestFrequency string      `json:"digestfrequency"`
+	UserIssues      []*Issue    `json:"userissues"`
+	UserHelps       []*Issue    `json:"userhelps"`
 	//UserInterests     []Issue `json:userinterests`
 	//UsersCurrentHelps []Issue `json:usercurrenthelps`
 }
 
 type Post struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	PostTime    string `json:"posttime"`
-	UserId      string `json:"userid"`
+	ID           string       `json:"id"`
+	Title        string       `json:"title"`
+	Description  string       `json:"description"`
+	PostTime     shared.Time  `json:"posttime"`
+	Edited       *shared.Time `json:"edited,omitempty"`
+	UserId       string       `json:"userid"`
+	LikeCount    int          `json:"likecount"`
+	CommentCount int          `json:"commentcount"`
+	// IssueID links a story to the resolved issue it is about. Tagged are
+	// the helpers whose help on that issue was accepted.
+	IssueID string   `json:"issueid,omitempty" dynamodbav:"IssueId"`
+	Tagged  []string `json:"tagged,omitempty"`
+}
+
+// PostComment is a comment on a post. CommentId starts with the comment
+// time, so comments sort oldest first.
+type PostComment struct {
+	PostID   string      `json:"postid" dynamodbav:"PostId"`
+	ID       string      `json:"id" dynamodbav:"CommentId"`
+	UserID   string      `json:"userid" dynamodbav:"UserId"`
+	UserName string      `json:"username"`
+	Comment  string      `json:"comment"`
+	Created  shared.Time `json:"created"`
+}
+
+// PostCommentRequest is the body of POST /posts/item/{postId}/comments.
+type PostCommentRequest struct {
+	UserID   string `json:"userid"`
+	UserName string `json:"username"`
+	Comment  string `json:"comment"`
+}
+
+// PostUpdateRequest is the body of PATCH /posts/item/{postId}. Only the
+// fields that are given are changed.
+type PostUpdateRequest struct {
+	UserID      string  `json:"userid"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+}
+
+type Notification struct {
+	ID         string      `json:"id" dynamodbav:"NotificationId"`
+	IssueID    string      `json:"issueid" dynamodbav:"IssueId"`
+	IssueTitle string      `json:"issuetitle"`
+	PostID     string      `json:"postid,omitempty" dynamodbav:"PostId"`
+	PostTitle  string      `json:"posttitle,omitempty"`
+	Kind       string      `json:"kind"`
+	ActorID    string      `json:"actorid" dynamodbav:"ActorId"`
+	Message    string      `json:"message"`
+	Read       bool        `json:"read"`
+	Created    shared.Time `json:"created"`
+}
+
+type ReadNotificationsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type EmailPreferencesRequest struct {
+	OptOut []string `json:"optout"`
+}
+
+type DigestRequest struct {
+	Frequency string   `json:"frequency"`
+	Location  string   `json:"location"`
+	Interests []string `json:"interests"`
 }
 
 type UserRequest struct {
@@ -47,203 +115,496 @@ type UserRequest struct {
 	IssueID  string `json:"issue_id"`
 }
 
-func getHeaders() map[string]string {
-	return map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept",
-		"Access-Control-Allow-Methods": "OPTIONS,POST,GET"}
-}
-
-func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (s *Server) router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	if strings.HasPrefix(req.Path, "/users") {
 		userId := req.PathParameters["userId"]
-		fmt.Printf("Path parameter user id :%s", userId)
 		switch req.HTTPMethod {
 		case "GET":
-			return fetch(req, userId)
+			if strings.HasSuffix(req.Path, "/subscriptions") {
+				return s.fetchSubscriptions(req, userId)
+			}
+			if strings.HasSuffix(req.Path, "/notifications") {
+				return s.fetchNotifications(req, userId)
+			}
+			return s.fetch(req, userId)
 		case "PUT":
-			return insert(req, userId)
+			if strings.HasSuffix(req.Path, "/emailpreferences") {
+				return s.updateEmailPreferences(req, userId)
+			}
+			if strings.HasSuffix(req.Path, "/digest") {
+				return s.updateDigest(req, userId)
+			}
+			return s.insert(req, userId)
 		case "POST":
-			return insertPost(req)
-		case "OPTIONS":
-			return events.APIGatewayProxyResponse{
-				StatusCode: 200,
-				Headers:    getHeaders()}, nil
+			if strings.HasSuffix(req.Path, "/notifications/read") {
+				return s.readNotifications(req, userId)
+			}
+			return s.insertPost(req)
 		default:
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed,
-				Headers: getHeaders(),
-				Body:    http.StatusText(http.StatusMethodNotAllowed)}, nil
+			return shared.Status(http.StatusMethodNotAllowed), nil
 		}
 	}
 	if strings.HasPrefix(req.Path, "/userPosts") {
 		userId := req.PathParameters["userId"]
-		fmt.Printf("Path parameter user id :%s", userId)
 		switch req.HTTPMethod {
 		case "GET":
-			return fetchPostsByUserId(req, userId)
+			return s.fetchPostsByUserId(req, userId)
 		case "POST":
-			return insertPost(req)
+			return s.insertPost(req)
 		default:
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed,
-				Headers: getHeaders(),
-				Body:    http.StatusText(http.StatusMethodNotAllowed)}, nil
+			return shared.Status(http.StatusMethodNotAllowed), nil
+		}
+	}
+	// Posts are created with POST /posts and listed with GET /posts (all
+	// posts) or GET /posts/{userId} (posts of a user). A single post is read,
+	// edited and deleted at /posts/item/{postId}.
+	if strings.HasPrefix(req.Path, "/posts/item/") {
+		postId := req.PathParameters["postId"]
+		if strings.HasSuffix(req.Path, "/like") {
+			switch req.HTTPMethod {
+			case "PUT":
+				return s.like(req, postId)
+			case "DELETE":
+				return s.unlike(req, postId)
+			default:
+				return shared.Status(http.StatusMethodNotAllowed), nil
+			}
+		}
+		if strings.HasSuffix(req.Path, "/comments") {
+			switch req.HTTPMethod {
+			case "GET":
+				return s.fetchPostComments(req, postId)
+			case "POST":
+				return s.commentOnPost(req, postId)
+			default:
+				return shared.Status(http.StatusMethodNotAllowed), nil
+			}
+		}
+		switch req.HTTPMethod {
+		case "GET":
+			return s.fetchPost(postId)
+		case "PATCH":
+			return s.editPost(req, postId)
+		case "DELETE":
+			return s.removePost(req, postId)
+		default:
+			return shared.Status(http.StatusMethodNotAllowed), nil
 		}
 	}
 	if strings.HasPrefix(req.Path, "/posts") {
 		switch req.HTTPMethod {
 		case "GET":
-			return fetchAllPosts(req)
+			if userId := req.PathParameters["userId"]; userId != "" {
+				return s.fetchPostsByUserId(req, userId)
+			}
+			return s.fetchAllPosts(req)
+		case "POST":
+			return s.insertPost(req)
 		default:
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed,
-				Headers: getHeaders(),
-				Body:    http.StatusText(http.StatusMethodNotAllowed)}, nil
+			return shared.Status(http.StatusMethodNotAllowed), nil
 		}
 	}
-	return events.APIGatewayProxyResponse{StatusCode: http.StatusMethodNotAllowed,
-		Headers: getHeaders(),
-		Body:    http.StatusText(http.StatusMethodNotAllowed)}, nil
+	return shared.Status(http.StatusMethodNotAllowed), nil
 }
 
-func fetch(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
-	userInfo, err := getUserById(userId)
+func (s *Server) fetch(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+	userInfo, err := s.Users.GetUser(userId)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusBadRequest,
-			Headers:    getHeaders(),
-			Body:       err.Error()}, nil
+		return shared.Error(http.StatusBadRequest, err), nil
+	}
+	if userInfo == nil {
+		return shared.Status(http.StatusNotFound), nil
 	}
 
 	// one filter call to get issues created by user
-	userIssues, err := getIssuesCreatedByUser(userId)
+	userIssues, err := s.Users.GetIssuesCreatedBy(userId)
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
 	// one filter call to get issues helped by user
-	helpedIssues, err := getIssuesHelpedByUser(userId, userInfo.Name)
+	helpedIssues, err := s.Users.GetIssuesHelpedBy(userId, userInfo.Name)
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
 	userInfo.UserIssues = userIssues
 	userInfo.UserHelps = helpedIssues
-	user_json, err := json.Marshal(userInfo)
+	return shared.JSON(http.StatusOK, userInfo), nil
+}
+
+func (s *Server) fetchSubscriptions(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+	issues, err := s.Users.GetSubscribedIssues(userId)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Headers:    getHeaders(),
-			Body:       http.StatusText(http.StatusInternalServerError)}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
 	}
+	return shared.JSON(http.StatusOK, issues), nil
+}
 
-	return events.APIGatewayProxyResponse{
-		Body:       string(user_json),
-		Headers:    getHeaders(),
-		StatusCode: 200,
-	}, nil
+func (s *Server) fetchNotifications(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+	unreadOnly := request.QueryStringParameters["unread"] == "true"
+	notifications, err := s.Users.GetNotifications(userId, unreadOnly)
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.JSON(http.StatusOK, notifications), nil
 }
 
-func insert(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+// readNotifications marks the given notifications as read, or all unread
+// notifications of the user when no ids are given.
+func (s *Server) readNotifications(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+	readRequest := new(ReadNotificationsRequest)
+	if request.Body != "" {
+		if err := json.Unmarshal([]byte(request.Body), readRequest); err != nil {
+			return shared.Status(http.StatusBadRequest), nil
+		}
+	}
+	ids := readRequest.IDs
+	if len(ids) == 0 {
+		unread, err := s.Users.GetNotifications(userId, true)
+		if err != nil {
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		for _, notification := range unread {
+			ids = append(ids, notification.ID)
+		}
+	}
+	for _, id := range ids {
+		if err := s.Users.MarkNotificationRead(userId, id); err != nil {
+			return shared.Error(http.StatusInternalServerError, err), nil
+		}
+	}
 
-	if request.Headers["content-type"] != "application/json" && request.Headers["Content-Type"] != "application/json" {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotAcceptable,
-			Headers: getHeaders(),
-			Body:    http.StatusText(http.StatusNotAcceptable)}, nil
+	return shared.Text(http.StatusOK, fmt.Sprintf("Marked %d notifications as read", len(ids))), nil
+}
+
+func (s *Server) insert(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+
+	if !shared.IsJSON(request) {
+		return shared.Status(http.StatusNotAcceptable), nil
 	}
 	userRequest := new(UserRequest)
 	err := json.Unmarshal([]byte(request.Body), userRequest)
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
-			Headers: getHeaders(),
-			Body:    http.StatusText(http.StatusBadRequest)}, nil
+		return shared.Status(http.StatusBadRequest), nil
 	}
 	//err = updateUser(userId, userRequest)
 	if err != nil {
 
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Headers:    getHeaders(),
-			Body:       err.Error()}, nil
+		return shared.Error(http.StatusInternalServerError, err), nil
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       fmt.Sprintf("Successfully updated User"),
-		Headers:    getHeaders(),
-		StatusCode: 201,
-	}, nil
+	return shared.Text(http.StatusCreated, fmt.Sprintf("Successfully updated User")), nil
+}
+
+// updateEmailPreferences replaces the categories of email a user opted out of.
+func (s *Server) updateEmailPreferences(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+	preferences := new(EmailPreferencesRequest)
+	err := json.Unmarshal([]byte(request.Body), preferences)
+	if err != nil {
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	for _, category := range preferences.OptOut {
+		if !isEmailCategory(category) {
+			return shared.Text(http.StatusBadRequest, fmt.Sprintf("Unknown email category %s", category)), nil
+		}
+	}
+	err = s.Users.UpdateEmailOptOut(userId, preferences.OptOut)
+	if err != nil {
+		return shared.Error(http.StatusInternalServerError, err), nil
+	}
+
+	return shared.Text(http.StatusOK, fmt.Sprintf("Successfully updated email preferences")), nil
+}
+
+// updateDigest opts a user into daily or weekly digests of open issues in
+// their location matching their interests, or out of them with an empty frequency.
+func (s *Server) updateDigest(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+	digest := new(DigestRequest)
+	err := json.Unmarshal([]byte(request.Body), digest)
+	if err != nil {
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	if digest.Frequency != "" && digest.Frequency != "daily" && digest.Frequency != "weekly" {
+		return shared.Text(http.StatusBadRequest, "frequency must be daily, weekly or empty"), nil
+	}
+	if digest.Frequency != "" && strings.TrimSpace(digest.Location) == "" {
+		return shared.Text(http.StatusBadRequest, "location is required for a digest"), nil
+	}
+	err = s.Users.UpdateDigestPreferences(userId, digest)
+	if err != nil {
+		return shared.Error(http.StatusInternalServerError, err), nil
+	}
+
+	return shared.Text(http.StatusOK, fmt.Sprintf("Successfully updated digest preferences")), nil
+}
+
+func isEmailCategory(category string) bool {
+	for _, known := range mailer.Categories {
+		if string(known) == category {
+			return true
+		}
+	}
+	return false
 }
 
-func insertPost(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	headers := map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept",
-		"Access-Control-Allow-Methods": "OPTIONS,POST,GET"}
-	if request.Headers["content-type"] != "application/json" && request.Headers["Content-Type"] != "application/json" {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotAcceptable,
-			Headers: headers,
-			Body:    http.StatusText(http.StatusNotAcceptable)}, nil
+func (s *Server) insertPost(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !shared.IsJSON(request) {
+		return shared.Status(http.StatusNotAcceptable), nil
 	}
 	post := new(Post)
 	err := json.Unmarshal([]byte(request.Body), post)
 	post.ID = uuid.New().String()
-	post.PostTime = time.Now().Local().String()
+	post.PostTime = shared.Now()
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
-			Headers: headers,
-			Body:    http.StatusText(http.StatusBadRequest)}, nil
+		return shared.Status(http.StatusBadRequest), nil
 	}
-	err = addPost(post)
+	// Tags are derived from the linked issue, never taken from the client.
+	post.Tagged = nil
+	bonusPoints := 0
+	if post.IssueID != "" {
+		issue, err := s.Posts.GetStoryIssue(post.IssueID)
+		if err != nil {
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		if issue == nil {
+			return shared.Text(http.StatusBadRequest, "issueid does not exist"), nil
+		}
+		if issue.StatusMsg != statusResolved {
+			return shared.Text(http.StatusBadRequest, "Only resolved issues can have stories"), nil
+		}
+		for _, helperId := range issue.Accepted {
+			if helperId != post.UserId {
+				post.Tagged = append(post.Tagged, helperId)
+			}
+		}
+		// Only the first story of an author about an issue earns points, so
+		// that they cannot be collected by posting again. AddPost awards
+		// them once even to stories posted at the same time.
+		told, err := s.Posts.HasStory(post.IssueID, post.UserId)
+		if err != nil {
+			return shared.Error(http.StatusBadGateway, err), nil
+		}
+		if !told {
+			bonusPoints = storyBonusPoints
+		}
+	}
+	err = s.Posts.AddPost(post, bonusPoints)
 	if err != nil {
 		//See if we can pass err instead
 
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadGateway,
-			Headers: headers,
-			Body:    err.Error()}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       fmt.Sprintf("Successfully stored the entry"),
-		Headers:    headers,
-		StatusCode: 201,
-	}, nil
+	return shared.Text(http.StatusCreated, "Successfully stored the entry"), nil
 }
 
-func fetchPostsByUserId(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
-	userInfo, err := getPostsByUserId(userId)
+func (s *Server) fetchPost(postId string) (events.APIGatewayProxyResponse, error) {
+	post, err := s.Posts.GetPost(postId)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusBadRequest,
-			Headers:    getHeaders(),
-			Body:       err.Error()}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	if post == nil {
+		return shared.Status(http.StatusNotFound), nil
+	}
+	return shared.JSON(http.StatusOK, post), nil
+}
+
+// checkPostAuthor loads a post and makes sure the user wrote it. When the
+// post is missing or not theirs it returns a nil post and the response to
+// send.
+func (s *Server) checkPostAuthor(postId string, userId string) (*Post, events.APIGatewayProxyResponse) {
+	post, err := s.Posts.GetPost(postId)
+	if err != nil {
+		return nil, shared.Error(http.StatusBadGateway, err)
+	}
+	if post == nil {
+		return nil, shared.Status(http.StatusNotFound)
+	}
+	if userId == "" || post.UserId != userId {
+		return nil, shared.Text(http.StatusForbidden, "Only the author can change a post")
+	}
+	return post, events.APIGatewayProxyResponse{}
+}
+
+func (s *Server) editPost(request events.APIGatewayProxyRequest, postId string) (events.APIGatewayProxyResponse, error) {
+	update := new(PostUpdateRequest)
+	if err := json.Unmarshal([]byte(request.Body), update); err != nil {
+		return shared.Status(http.StatusBadRequest), nil
 	}
-	user_json, err := json.Marshal(userInfo)
+	if update.Title == nil && update.Description == nil {
+		return shared.Text(http.StatusBadRequest, "title or description is required"), nil
+	}
+	if update.Title != nil && strings.TrimSpace(*update.Title) == "" {
+		return shared.Text(http.StatusBadRequest, "title must not be empty"), nil
+	}
+	post, errResponse := s.checkPostAuthor(postId, update.UserID)
+	if post == nil {
+		return errResponse, nil
+	}
+	edited, err := s.Posts.UpdatePost(post, update.Title, update.Description, shared.Now())
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Headers:    getHeaders(),
-			Body:       http.StatusText(http.StatusInternalServerError)}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
 	}
+	if edited == nil {
+		// The post was deleted since it was read.
+		return shared.Status(http.StatusNotFound), nil
+	}
+	return shared.JSON(http.StatusOK, edited), nil
+}
 
-	return events.APIGatewayProxyResponse{
-		Body:       string(user_json),
-		Headers:    getHeaders(),
-		StatusCode: 200,
-	}, nil
+// requestUserID reads the acting user of a request from the userid of the
+// body or, for requests without a body, from ?userid=.
+func requestUserID(request events.APIGatewayProxyRequest) (string, error) {
+	userRequest := new(PostUpdateRequest)
+	if request.Body != "" {
+		if err := json.Unmarshal([]byte(request.Body), userRequest); err != nil {
+			return "", err
+		}
+	}
+	if userRequest.UserID == "" {
+		return request.QueryStringParameters["userid"], nil
+	}
+	return userRequest.UserID, nil
 }
 
-func fetchAllPosts(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	userInfo, err := getAllPosts()
+// removePost deletes a post. The author is taken from the body or, as
+// DELETE bodies are often dropped, the userid query parameter.
+func (s *Server) removePost(request events.APIGatewayProxyRequest, postId string) (events.APIGatewayProxyResponse, error) {
+	userId, err := requestUserID(request)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusBadRequest,
-			Headers:    getHeaders(),
-			Body:       err.Error()}, nil
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	post, errResponse := s.checkPostAuthor(postId, userId)
+	if post == nil {
+		return errResponse, nil
 	}
-	user_json, err := json.Marshal(userInfo)
+	deleted, err := s.Posts.DeletePost(post)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Headers:    getHeaders(),
-			Body:       http.StatusText(http.StatusInternalServerError)}, nil
+		return shared.Error(http.StatusBadGateway, err), nil
 	}
+	if !deleted {
+		return shared.Status(http.StatusNotFound), nil
+	}
+	return shared.Text(http.StatusOK, "Successfully deleted the post"), nil
+}
 
-	return events.APIGatewayProxyResponse{
-		Body:       string(user_json),
-		Headers:    getHeaders(),
-		StatusCode: 200,
-	}, nil
+// findPost loads a post that is being liked or commented on. When it cannot
+// it returns a nil post and the response to send.
+func (s *Server) findPost(postId string) (*Post, events.APIGatewayProxyResponse) {
+	post, err := s.Posts.GetPost(postId)
+	if err != nil {
+		return nil, shared.Error(http.StatusBadGateway, err)
+	}
+	if post == nil {
+		return nil, shared.Status(http.StatusNotFound)
+	}
+	return post, events.APIGatewayProxyResponse{}
+}
+
+func (s *Server) like(request events.APIGatewayProxyRequest, postId string) (events.APIGatewayProxyResponse, error) {
+	userId, err := requestUserID(request)
+	if err != nil || userId == "" {
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	post, errResponse := s.findPost(postId)
+	if post == nil {
+		return errResponse, nil
+	}
+	// Liking a post twice is not an error, the like is only counted once.
+	if _, err := s.Posts.Like(post, userId); err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.Text(http.StatusOK, "Successfully liked the post"), nil
+}
+
+func (s *Server) unlike(request events.APIGatewayProxyRequest, postId string) (events.APIGatewayProxyResponse, error) {
+	userId, err := requestUserID(request)
+	if err != nil || userId == "" {
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	post, errResponse := s.findPost(postId)
+	if post == nil {
+		return errResponse, nil
+	}
+	if _, err := s.Posts.Unlike(post, userId); err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.Text(http.StatusOK, "Successfully removed the like"), nil
+}
+
+func (s *Server) commentOnPost(request events.APIGatewayProxyRequest, postId string) (events.APIGatewayProxyResponse, error) {
+	commentReq := new(PostCommentRequest)
+	if err := json.Unmarshal([]byte(request.Body), commentReq); err != nil {
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	text, err := moderation.CheckComment(commentReq.UserID, commentReq.Comment)
+	if err != nil {
+		return shared.Error(http.StatusBadRequest, err), nil
+	}
+	post, errResponse := s.findPost(postId)
+	if post == nil {
+		return errResponse, nil
+	}
+	created := shared.Now()
+	comment := &PostComment{
+		PostID:   post.ID,
+		ID:       created.String() + "#" + uuid.New().String(),
+		UserID:   commentReq.UserID,
+		UserName: commentReq.UserName,
+		Comment:  text,
+		Created:  created,
+	}
+	if err := s.Posts.AddComment(post, comment); err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.JSON(http.StatusCreated, comment), nil
+}
+
+func (s *Server) fetchPostComments(request events.APIGatewayProxyRequest, postId string) (events.APIGatewayProxyResponse, error) {
+	limit, err := parseLimit(request.QueryStringParameters["limit"])
+	if err != nil {
+		return shared.Error(http.StatusBadRequest, err), nil
+	}
+	page, err := s.Posts.GetComments(postId, request.QueryStringParameters["cursor"], limit)
+	return pageResponse(page, err)
+}
+
+func (s *Server) fetchPostsByUserId(request events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
+	limit, err := parseLimit(request.QueryStringParameters["limit"])
+	if err != nil {
+		return shared.Error(http.StatusBadRequest, err), nil
+	}
+	page, err := s.Posts.GetPostsByUser(userId, request.QueryStringParameters["cursor"], limit)
+	return pageResponse(page, err)
+}
+
+func (s *Server) fetchAllPosts(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	limit, err := parseLimit(request.QueryStringParameters["limit"])
+	if err != nil {
+		return shared.Error(http.StatusBadRequest, err), nil
+	}
+	page, err := s.Posts.GetFeed(request.QueryStringParameters["cursor"], limit)
+	return pageResponse(page, err)
+}
+
+func pageResponse(page interface{}, err error) (events.APIGatewayProxyResponse, error) {
+	if err == errInvalidCursor {
+		return shared.Error(http.StatusBadRequest, err), nil
+	}
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.JSON(http.StatusOK, page), nil
 }
 
 func main() {
-	env := os.Getenv("AWSENV")
-	dbEndpoint := os.Getenv("DBENDPOINT")
-	createDBConnection(env, dbEndpoint)
-	lambda.Start(router)
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	server := NewServer(&DynamoUserStore{}, &DynamoPostStore{})
+	if cfg.Memory() {
+		store := NewMemoryStore()
+		server = NewServer(store, store)
+	} else {
+		db = shared.MustNewDynamoDB(cfg)
+	}
+	lambda.Start(shared.Logged(shared.CORS(server.router)))
 }
@@ -1,64 +1,617 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"net/http"
-	"net/http/httptest"
+	"shared"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
-func TestHandler(t *testing.T) {
-	t.Run("Unable to get IP", func(t *testing.T) {
-		DefaultHTTPGetAddress = "http://127.0.0.1:12345"
+// at parses a stored time for the test data.
+func at(value string) shared.Time {
+	t, err := shared.ParseTime(value)
+	if err != nil {
+		panic(err)
+	}
+	return shared.Time{Time: t}
+}
+
+// newTestStore returns a store where Asha (user-1) helped Ravi (user-2) on a
+// resolved issue and follows an open issue of her own. Ravi wrote post-1 and
+// post-3, Asha wrote post-2.
+func newTestStore() *MemoryStore {
+	store := NewMemoryStore()
+	store.PutUser(&User{ID: "user-1", Name: "Asha", Email: "asha@example.org", SamaritanPoints: 20})
+	store.PutUser(&User{ID: "user-2", Name: "Ravi", Email: "ravi@example.org", SamaritanPoints: 10})
+	store.PutIssue(&Issue{ID: "resolved", Title: "Blood donors needed", StatusMsg: statusResolved, Accepted: []string{"user-1"}},
+		"user-2", map[string]string{"user-1": "Asha"})
+	store.PutIssue(&Issue{ID: "open", Title: "Streetlight broken", StatusMsg: "Need Help"}, "user-1", nil)
+	store.Subscribe("user-1", "open")
+	store.AddNotification("user-1", &Notification{ID: "2020-09-07T10:00:00.000Z#1", IssueID: "resolved", Kind: "accepted", Read: true})
+	store.AddNotification("user-1", &Notification{ID: "2020-09-08T10:00:00.000Z#2", IssueID: "open", Kind: "comment"})
+	store.AddPost(&Post{ID: "post-1", Title: "Thank you all", UserId: "user-2", PostTime: at("2020-09-07T10:00:00.000Z")}, 0)
+	store.AddPost(&Post{ID: "post-2", Title: "Cleanup drive", UserId: "user-1", PostTime: at("2020-09-08T10:00:00.000Z")}, 0)
+	store.AddPost(&Post{ID: "post-3", Title: "Food bank", UserId: "user-2", PostTime: at("2020-09-09T10:00:00.000Z")}, 0)
+	post, _ := store.GetPost("post-1")
+	store.AddComment(post, &PostComment{PostID: "post-1", ID: "2020-09-07T11:00:00.000Z#1", UserID: "user-1", Comment: "Well done"})
+	return store
+}
+
+func apiRequest(method string, path string, params map[string]string, query map[string]string, body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod:            method,
+		Path:                  path,
+		PathParameters:        params,
+		QueryStringParameters: query,
+		Headers:               map[string]string{"Content-Type": "application/json"},
+		Body:                  body,
+	}
+}
+
+func userParams(userID string) map[string]string {
+	return map[string]string{"userId": userID}
+}
+
+func postParams(postID string) map[string]string {
+	return map[string]string{"postId": postID}
+}
+
+func decode(t *testing.T, response events.APIGatewayProxyResponse, v interface{}) {
+	t.Helper()
+	if response.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", response.Headers["Content-Type"])
+	}
+	if err := json.Unmarshal([]byte(response.Body), v); err != nil {
+		t.Fatalf("decoding %s: %s", response.Body, err)
+	}
+}
+
+func postIDs(posts []*Post) string {
+	ids := make([]string, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return strings.Join(ids, ",")
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid request")
+func checkPosts(want string) func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+	return func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+		page := new(PostsPage)
+		decode(t, response, page)
+		if got := postIDs(page.Posts); got != want {
+			t.Errorf("posts = %s, want %s", got, want)
 		}
-	})
+	}
+}
+
+func storedPost(t *testing.T, store *MemoryStore, postID string) *Post {
+	t.Helper()
+	post, _ := store.GetPost(postID)
+	if post == nil {
+		t.Fatalf("post %s is not stored", postID)
+	}
+	return post
+}
 
-	t.Run("Non 200 Response", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
+func storedUser(t *testing.T, store *MemoryStore, userID string) *User {
+	t.Helper()
+	user, _ := store.GetUser(userID)
+	if user == nil {
+		t.Fatalf("user %s is not stored", userID)
+	}
+	return user
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name    string
+		request events.APIGatewayProxyRequest
+		status  int
+		check   func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse)
+	}{
+		{
+			name:    "get user with issues",
+			request: apiRequest("GET", "/users/user-1", userParams("user-1"), nil, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				user := new(User)
+				decode(t, response, user)
+				if user.Name != "Asha" || len(user.UserIssues) != 1 || user.UserIssues[0].ID != "open" ||
+					len(user.UserHelps) != 1 || user.UserHelps[0].ID != "resolved" {
+					t.Errorf("user = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "get missing user",
+			request: apiRequest("GET", "/users/missing", userParams("missing"), nil, ""),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "subscriptions",
+			request: apiRequest("GET", "/users/user-1/subscriptions", userParams("user-1"), nil, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				var issues []*Issue
+				decode(t, response, &issues)
+				if len(issues) != 1 || issues[0].ID != "open" {
+					t.Errorf("subscriptions = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "unread notifications",
+			request: apiRequest("GET", "/users/user-1/notifications", userParams("user-1"), map[string]string{"unread": "true"}, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				var notifications []*Notification
+				decode(t, response, &notifications)
+				if len(notifications) != 1 || notifications[0].IssueID != "open" {
+					t.Errorf("notifications = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "all notifications newest first",
+			request: apiRequest("GET", "/users/user-1/notifications", userParams("user-1"), nil, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				var notifications []*Notification
+				decode(t, response, &notifications)
+				if len(notifications) != 2 || notifications[0].IssueID != "open" {
+					t.Errorf("notifications = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "read all notifications",
+			request: apiRequest("POST", "/users/user-1/notifications/read", userParams("user-1"), nil, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				if response.Body != "Marked 1 notifications as read" {
+					t.Errorf("body = %q", response.Body)
+				}
+				if unread, _ := store.GetNotifications("user-1", true); len(unread) != 0 {
+					t.Errorf("%d notifications are still unread", len(unread))
+				}
+			},
+		},
+		{
+			name:    "read notifications with invalid JSON",
+			request: apiRequest("POST", "/users/user-1/notifications/read", userParams("user-1"), nil, `{"ids": `),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "update user",
+			request: apiRequest("PUT", "/users/user-1", userParams("user-1"), nil, `{"action": "help"}`),
+			status:  http.StatusCreated,
+		},
+		{
+			name:    "update user without JSON",
+			request: events.APIGatewayProxyRequest{HTTPMethod: "PUT", Path: "/users/user-1", PathParameters: userParams("user-1"), Body: `{}`},
+			status:  http.StatusNotAcceptable,
+		},
+		{
+			name:    "email preferences",
+			request: apiRequest("PUT", "/users/user-1/emailpreferences", userParams("user-1"), nil, `{"optout": ["comment", "points", "comment"]}`),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				if optOut := strings.Join(storedUser(t, store, "user-1").EmailOptOut, ","); optOut != "comment,points" {
+					t.Errorf("opted out of %s, want comment,points", optOut)
+				}
+			},
+		},
+		{
+			name:    "unknown email category",
+			request: apiRequest("PUT", "/users/user-1/emailpreferences", userParams("user-1"), nil, `{"optout": ["newsletter"]}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "email preferences of missing user",
+			request: apiRequest("PUT", "/users/missing/emailpreferences", userParams("missing"), nil, `{"optout": []}`),
+			status:  http.StatusInternalServerError,
+		},
+		{
+			name:    "digest",
+			request: apiRequest("PUT", "/users/user-1/digest", userParams("user-1"), nil, `{"frequency": "weekly", "location": "Bangalore", "interests": ["health"]}`),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				user := storedUser(t, store, "user-1")
+				if user.DigestFrequency != "weekly" || user.Location != "Bangalore" || len(user.Interests) != 1 {
+					t.Errorf("user = %+v", user)
+				}
+			},
+		},
+		{
+			name:    "digest with unknown frequency",
+			request: apiRequest("PUT", "/users/user-1/digest", userParams("user-1"), nil, `{"frequency": "monthly", "location": "Bangalore"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "digest without location",
+			request: apiRequest("PUT", "/users/user-1/digest", userParams("user-1"), nil, `{"frequency": "daily"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "post through users",
+			request: apiRequest("POST", "/users/user-1", userParams("user-1"), nil, `{"title": "Hello", "userid": "user-1"}`),
+			status:  http.StatusCreated,
+		},
+		{
+			name:    "delete user",
+			request: apiRequest("DELETE", "/users/user-1", userParams("user-1"), nil, ""),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "posts of user",
+			request: apiRequest("GET", "/userPosts/user-2", userParams("user-2"), nil, ""),
+			status:  http.StatusOK,
+			check:   checkPosts("post-3,post-1"),
+		},
+		{
+			name:    "post through userPosts",
+			request: apiRequest("POST", "/userPosts/user-1", userParams("user-1"), nil, `{"title": "Hello", "userid": "user-1"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				if page, _ := store.GetPostsByUser("user-1", "", 10); len(page.Posts) != 2 {
+					t.Errorf("user-1 has %d posts, want 2", len(page.Posts))
+				}
+			},
+		},
+		{
+			name:    "update userPosts",
+			request: apiRequest("PUT", "/userPosts/user-1", userParams("user-1"), nil, `{}`),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "feed",
+			request: apiRequest("GET", "/posts", nil, nil, ""),
+			status:  http.StatusOK,
+			check:   checkPosts("post-3,post-2,post-1"),
+		},
+		{
+			name:    "feed with invalid limit",
+			request: apiRequest("GET", "/posts", nil, map[string]string{"limit": "0"}, ""),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "feed with invalid cursor",
+			request: apiRequest("GET", "/posts", nil, map[string]string{"cursor": "bogus"}, ""),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "posts by user id",
+			request: apiRequest("GET", "/posts/user-1", userParams("user-1"), nil, ""),
+			status:  http.StatusOK,
+			check:   checkPosts("post-2"),
+		},
+		{
+			name:    "create post",
+			request: apiRequest("POST", "/posts", nil, nil, `{"title": "Hello", "description": "First post", "userid": "user-1", "tagged": ["user-2"]}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				page, _ := store.GetPostsByUser("user-1", "", 1)
+				if len(page.Posts) != 1 || page.Posts[0].Title != "Hello" || len(page.Posts[0].Tagged) != 0 {
+					t.Errorf("newest post of user-1 = %+v", page.Posts)
+				}
+			},
+		},
+		{
+			name:    "create story",
+			request: apiRequest("POST", "/posts", nil, nil, `{"title": "We found donors", "userid": "user-2", "issueid": "resolved"}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				page, _ := store.GetPostsByUser("user-2", "", 1)
+				if len(page.Posts) != 1 || page.Posts[0].IssueID != "resolved" || strings.Join(page.Posts[0].Tagged, ",") != "user-1" {
+					t.Errorf("story = %+v", page.Posts)
+				}
+				if points := storedUser(t, store, "user-2").SamaritanPoints; points != 10+storyBonusPoints {
+					t.Errorf("author points = %d, want %d", points, 10+storyBonusPoints)
+				}
+			},
+		},
+		{
+			name:    "story about open issue",
+			request: apiRequest("POST", "/posts", nil, nil, `{"title": "Almost there", "userid": "user-1", "issueid": "open"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "story about missing issue",
+			request: apiRequest("POST", "/posts", nil, nil, `{"title": "Done", "userid": "user-1", "issueid": "missing"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "create post without JSON",
+			request: events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/posts", Body: `{"title": "Hello"}`},
+			status:  http.StatusNotAcceptable,
+		},
+		{
+			name:    "create post with invalid JSON",
+			request: apiRequest("POST", "/posts", nil, nil, `{"title": `),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "delete posts",
+			request: apiRequest("DELETE", "/posts", nil, nil, ""),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "get post",
+			request: apiRequest("GET", "/posts/item/post-1", postParams("post-1"), nil, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				post := new(Post)
+				decode(t, response, post)
+				if post.Title != "Thank you all" || post.CommentCount != 1 {
+					t.Errorf("post = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "get missing post",
+			request: apiRequest("GET", "/posts/item/missing", postParams("missing"), nil, ""),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "edit post",
+			request: apiRequest("PATCH", "/posts/item/post-1", postParams("post-1"), nil, `{"userid": "user-2", "title": "Thank you, Asha"}`),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				post := new(Post)
+				decode(t, response, post)
+				if post.Title != "Thank you, Asha" || post.Edited == nil || post.Edited.Before(post.PostTime.Time) {
+					t.Errorf("edited post = %s", response.Body)
+				}
+				if !strings.Contains(response.Body, `"posttime":"2020-09-07T10:00:00.000Z"`) {
+					t.Errorf("edited post = %s, want the post time in RFC 3339", response.Body)
+				}
+			},
+		},
+		{
+			name:    "edit post of someone else",
+			request: apiRequest("PATCH", "/posts/item/post-1", postParams("post-1"), nil, `{"userid": "user-1", "title": "Mine now"}`),
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "edit post without changes",
+			request: apiRequest("PATCH", "/posts/item/post-1", postParams("post-1"), nil, `{"userid": "user-2"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "edit post with empty title",
+			request: apiRequest("PATCH", "/posts/item/post-1", postParams("post-1"), nil, `{"userid": "user-2", "title": " "}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "delete post",
+			request: apiRequest("DELETE", "/posts/item/post-1", postParams("post-1"), map[string]string{"userid": "user-2"}, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				if post, _ := store.GetPost("post-1"); post != nil {
+					t.Error("the post was not deleted")
+				}
+			},
+		},
+		{
+			name:    "delete post of someone else",
+			request: apiRequest("DELETE", "/posts/item/post-1", postParams("post-1"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "replace post",
+			request: apiRequest("PUT", "/posts/item/post-1", postParams("post-1"), nil, `{}`),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "like post",
+			request: apiRequest("PUT", "/posts/item/post-1/like", postParams("post-1"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				if likes := storedPost(t, store, "post-1").LikeCount; likes != 1 {
+					t.Errorf("likes = %d, want 1", likes)
+				}
+			},
+		},
+		{
+			name:    "like post without user",
+			request: apiRequest("PUT", "/posts/item/post-1/like", postParams("post-1"), nil, ""),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "like missing post",
+			request: apiRequest("PUT", "/posts/item/missing/like", postParams("missing"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "unlike post",
+			request: apiRequest("DELETE", "/posts/item/post-1/like", postParams("post-1"), map[string]string{"userid": "user-1"}, ""),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "post a like",
+			request: apiRequest("POST", "/posts/item/post-1/like", postParams("post-1"), nil, `{"userid": "user-1"}`),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "comment on post",
+			request: apiRequest("POST", "/posts/item/post-1/comments", postParams("post-1"), nil, `{"userid": "user-2", "username": "Ravi", "comment": " Thanks! "}`),
+			status:  http.StatusCreated,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				comment := new(PostComment)
+				decode(t, response, comment)
+				if comment.Comment != "Thanks!" || comment.PostID != "post-1" || comment.ID == "" {
+					t.Errorf("comment = %s", response.Body)
+				}
+				if comments := storedPost(t, store, "post-1").CommentCount; comments != 2 {
+					t.Errorf("comments = %d, want 2", comments)
+				}
+			},
+		},
+		{
+			name:    "empty comment",
+			request: apiRequest("POST", "/posts/item/post-1/comments", postParams("post-1"), nil, `{"userid": "user-2", "comment": ""}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "comment on missing post",
+			request: apiRequest("POST", "/posts/item/missing/comments", postParams("missing"), nil, `{"userid": "user-2", "comment": "Hello"}`),
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "comments",
+			request: apiRequest("GET", "/posts/item/post-1/comments", postParams("post-1"), nil, ""),
+			status:  http.StatusOK,
+			check: func(t *testing.T, store *MemoryStore, response events.APIGatewayProxyResponse) {
+				page := new(PostCommentsPage)
+				decode(t, response, page)
+				if len(page.Comments) != 1 || page.Comments[0].Comment != "Well done" || page.Cursor != "" {
+					t.Errorf("comments = %s", response.Body)
+				}
+			},
+		},
+		{
+			name:    "edit comments",
+			request: apiRequest("PUT", "/posts/item/post-1/comments", postParams("post-1"), nil, `{}`),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "unknown path",
+			request: apiRequest("GET", "/friends", nil, nil, ""),
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "preflight",
+			request: apiRequest("OPTIONS", "/posts", nil, nil, ""),
+			status:  http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore()
+			response, err := shared.CORS(NewServer(store, store).router)(test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("status = %d %s, want %d", response.StatusCode, response.Body, test.status)
+			}
+			if response.Headers["Access-Control-Allow-Origin"] != "*" {
+				t.Errorf("missing CORS headers: %v", response.Headers)
+			}
+			if test.check != nil {
+				test.check(t, store, response)
+			}
+		})
+	}
+}
+
+func TestFeedPages(t *testing.T) {
+	store := newTestStore()
+	router := NewServer(store, store).router
+	seen := make([]*Post, 0)
+	cursor := ""
+	for page := 1; ; page++ {
+		response, _ := router(apiRequest("GET", "/posts", nil, map[string]string{"limit": "2", "cursor": cursor}, ""))
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("page %d = %d %s", page, response.StatusCode, response.Body)
+		}
+		posts := new(PostsPage)
+		decode(t, response, posts)
+		seen = append(seen, posts.Posts...)
+		if posts.Cursor == "" {
+			break
+		}
+		if page == 3 {
+			t.Fatal("the feed does not end")
+		}
+		cursor = posts.Cursor
+	}
+	if got := postIDs(seen); got != "post-3,post-2,post-1" {
+		t.Errorf("paged feed = %s", got)
+	}
 
-		DefaultHTTPGetAddress = ts.URL
+	// A cursor of the feed is not a cursor of the posts of a user.
+	response, _ := router(apiRequest("GET", "/posts/user-2", userParams("user-2"), map[string]string{"limit": "1"}, ""))
+	userPage := new(PostsPage)
+	decode(t, response, userPage)
+	response, _ = router(apiRequest("GET", "/posts/user-1", userParams("user-1"), map[string]string{"cursor": userPage.Cursor}, ""))
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("cursor of another user = %d, want 400", response.StatusCode)
+	}
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil && err.Error() != ErrNon200Response.Error() {
-			t.Fatalf("Error failed to trigger with an invalid HTTP response: %v", err)
+func TestStoryPointsAreAwardedOnce(t *testing.T) {
+	store := newTestStore()
+	router := NewServer(store, store).router
+	story := apiRequest("POST", "/posts", nil, nil, `{"title": "We found donors", "userid": "user-2", "issueid": "resolved"}`)
+	for i := 0; i < 2; i++ {
+		if response, _ := router(story); response.StatusCode != http.StatusCreated {
+			t.Fatalf("story %d = %d %s", i+1, response.StatusCode, response.Body)
 		}
-	})
+	}
+	if points := storedUser(t, store, "user-2").SamaritanPoints; points != 10+storyBonusPoints {
+		t.Errorf("author points = %d, want %d", points, 10+storyBonusPoints)
+	}
+}
 
-	t.Run("Unable decode IP", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(500)
-		}))
-		defer ts.Close()
+// racingStories is a post store whose HasStory runs before any story is
+// stored, as happens when an author posts two stories at once.
+type racingStories struct {
+	*MemoryStore
+}
 
-		DefaultHTTPGetAddress = ts.URL
+func (s racingStories) HasStory(issueID string, userID string) (bool, error) {
+	return false, nil
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err == nil {
-			t.Fatal("Error failed to trigger with an invalid HTTP response")
+func TestStoryPointsAreAwardedOnceToRacingStories(t *testing.T) {
+	store := newTestStore()
+	router := NewServer(store, racingStories{store}).router
+	story := apiRequest("POST", "/posts", nil, nil, `{"title": "We found donors", "userid": "user-2", "issueid": "resolved"}`)
+	for i := 0; i < 2; i++ {
+		if response, _ := router(story); response.StatusCode != http.StatusCreated {
+			t.Fatalf("story %d = %d %s", i+1, response.StatusCode, response.Body)
 		}
-	})
+	}
+	if points := storedUser(t, store, "user-2").SamaritanPoints; points != 10+storyBonusPoints {
+		t.Errorf("author points = %d, want %d", points, 10+storyBonusPoints)
+	}
+	if told, _ := store.HasStory("resolved", "user-2"); !told {
+		t.Error("the stories were not stored")
+	}
+}
 
-	t.Run("Successful Request", func(t *testing.T) {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(200)
-			fmt.Fprintf(w, "127.0.0.1")
-		}))
-		defer ts.Close()
+// failingIssues is a user store whose queries of the issues of a user fail.
+type failingIssues struct {
+	*MemoryStore
+	created bool
+}
+
+func (s failingIssues) GetIssuesCreatedBy(userID string) ([]*Issue, error) {
+	if s.created {
+		return nil, errors.New("query failed")
+	}
+	return s.MemoryStore.GetIssuesCreatedBy(userID)
+}
 
-		DefaultHTTPGetAddress = ts.URL
+func (s failingIssues) GetIssuesHelpedBy(userID string, userName string) ([]*Issue, error) {
+	if !s.created {
+		return nil, errors.New("query failed")
+	}
+	return s.MemoryStore.GetIssuesHelpedBy(userID, userName)
+}
 
-		_, err := handler(events.APIGatewayProxyRequest{})
-		if err != nil {
-			t.Fatal("Everything should be ok")
+func TestFetchUserFailsWithItsIssues(t *testing.T) {
+	store := newTestStore()
+	for _, created := range []bool{true, false} {
+		router := NewServer(failingIssues{store, created}, store).router
+		response, _ := router(apiRequest("GET", "/users/user-1", userParams("user-1"), nil, ""))
+		if response.StatusCode != http.StatusBadGateway {
+			t.Errorf("failed issues query (created %t) = %d %s, want 502", created, response.StatusCode, response.Body)
 		}
-	})
+	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// PostsPage is one page of a posts listing. Cursor is empty on the last page
+// and is otherwise passed back as ?cursor= to get the next page.
+type PostsPage struct {
+	Posts  []*Post `json:"posts"`
+	Cursor string  `json:"cursor,omitempty"`
+}
+
+// PostCommentsPage is one page of the comments on a post, oldest first.
+type PostCommentsPage struct {
+	Comments []*PostComment `json:"comments"`
+	Cursor   string         `json:"cursor,omitempty"`
+}
+
+// encodeCursor turns the last evaluated key of a query, whose attributes
+// are all strings, into an opaque cursor.
+func encodeCursor(key map[string]string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the key a cursor was made from, which must consist of
+// exactly the given attributes.
+func decodeCursor(cursor string, attributes ...string) (map[string]string, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	key := map[string]string{}
+	if err := json.Unmarshal(data, &key); err != nil || len(key) != len(attributes) {
+		return nil, errInvalidCursor
+	}
+	for _, attribute := range attributes {
+		if key[attribute] == "" {
+			return nil, errInvalidCursor
+		}
+	}
+	return key, nil
+}
+
+// parseLimit reads the ?limit= of a listing.
+func parseLimit(limit string) (int, error) {
+	if limit == "" {
+		return defaultPageLimit, nil
+	}
+	parsed, err := strconv.Atoi(limit)
+	if err != nil || parsed <= 0 || parsed > maxPageLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"errors"
+	"shared"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// UserStore reads users along with the issues and notifications that concern
+// them, and keeps their preferences.
+type UserStore interface {
+	GetUsers() ([]*User, error)
+	// GetUser returns nil when the user does not exist.
+	GetUser(userID string) (*User, error)
+	GetIssuesCreatedBy(userID string) ([]*Issue, error)
+	GetIssuesHelpedBy(userID string, userName string) ([]*Issue, error)
+	GetSubscribedIssues(userID string) ([]*Issue, error)
+	GetNotifications(userID string, unreadOnly bool) ([]*Notification, error)
+	MarkNotificationRead(userID string, notificationID string) error
+	UpdateEmailOptOut(userID string, optOut []string) error
+	UpdateDigestPreferences(userID string, digest *DigestRequest) error
+}
+
+// PostStore keeps posts with their likes and comments. Listings are paged
+// with the opaque cursors of pagination.go.
+type PostStore interface {
+	AddPost(post *Post, bonusPoints int) error
+	// GetPost returns nil when the post does not exist.
+	GetPost(postID string) (*Post, error)
+	UpdatePost(post *Post, title *string, description *string, edited shared.Time) (*Post, error)
+	DeletePost(post *Post) (bool, error)
+	Like(post *Post, userID string) (bool, error)
+	Unlike(post *Post, userID string) (bool, error)
+	AddComment(post *Post, comment *PostComment) error
+	GetComments(postID string, cursor string, limit int) (*PostCommentsPage, error)
+	GetFeed(cursor string, limit int) (*PostsPage, error)
+	GetPostsByUser(userID string, cursor string, limit int) (*PostsPage, error)
+	// GetStoryIssue returns nil when the issue does not exist.
+	GetStoryIssue(issueID string) (*Issue, error)
+	HasStory(issueID string, userID string) (bool, error)
+}
+
+var (
+	errNoUser = errors.New("the user does not exist")
+	errNoPost = errors.New("the post does not exist")
+)
+
+// Server handles the users and posts APIs.
+type Server struct {
+	Users UserStore
+	Posts PostStore
+}
+
+func NewServer(users UserStore, posts PostStore) *Server {
+	return &Server{Users: users, Posts: posts}
+}
+
+// memoryIssue is an issue as the users API sees it, with the fields it
+// filters issues by.
+type memoryIssue struct {
+	Issue
+	creatorID string
+	helpers   map[string]string
+}
+
+// MemoryStore is both the UserStore and the PostStore, kept in memory for
+// the local server and tests. Issues, subscriptions and notifications are
+// written by other functions, so they are only added to set up the store.
+type MemoryStore struct {
+	mu            sync.Mutex
+	users         map[string]*User
+	issues        map[string]*memoryIssue
+	subscriptions map[string]map[string]bool
+	notifications map[string][]*Notification
+	posts         map[string]*Post
+	likes         map[string]map[string]bool
+	comments      map[string][]*PostComment
+	// storyIssues are the issues each user earned story points for.
+	storyIssues map[string]map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[string]*User{},
+		issues:        map[string]*memoryIssue{},
+		subscriptions: map[string]map[string]bool{},
+		notifications: map[string][]*Notification{},
+		posts:         map[string]*Post{},
+		likes:         map[string]map[string]bool{},
+		comments:      map[string][]*PostComment{},
+		storyIssues:   map[string]map[string]bool{},
+	}
+}
+
+func copyUser(user *User) *User {
+	c := *user
+	c.EmailOptOut = append([]string(nil), user.EmailOptOut...)
+	c.Interests = append([]string(nil), user.Interests...)
+	c.UserIssues = nil
+	c.UserHelps = nil
+	return &c
+}
+
+func copyPost(post *Post) *Post {
+	c := *post
+	c.Tagged = append([]string(nil), post.Tagged...)
+	return &c
+}
+
+// summary returns the fields of an issue listed with a user.
+func (issue *memoryIssue) summary() *Issue {
+	return &Issue{ID: issue.ID, Title: issue.Title, StatusMsg: issue.StatusMsg}
+}
+
+// PutUser adds or replaces a user.
+func (s *MemoryStore) PutUser(user *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = copyUser(user)
+}
+
+// PutIssue adds or replaces an issue created by creatorID, with the helpers
+// that offered help on it by id and name.
+func (s *MemoryStore) PutIssue(issue *Issue, creatorID string, helpers map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := &memoryIssue{Issue: *issue, creatorID: creatorID, helpers: map[string]string{}}
+	stored.Accepted = append([]string(nil), issue.Accepted...)
+	for id, name := range helpers {
+		stored.helpers[id] = name
+	}
+	s.issues[issue.ID] = stored
+}
+
+func (s *MemoryStore) Subscribe(userID string, issueID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions[userID] == nil {
+		s.subscriptions[userID] = map[string]bool{}
+	}
+	s.subscriptions[userID][issueID] = true
+}
+
+func (s *MemoryStore) AddNotification(userID string, notification *Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *notification
+	s.notifications[userID] = append(s.notifications[userID], &c)
+}
+
+func (s *MemoryStore) GetUsers() ([]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like a scan of an empty table, no users are reported as nil.
+	if len(s.users) == 0 {
+		return nil, nil
+	}
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, copyUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *MemoryStore) GetUser(userID string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	return copyUser(user), nil
+}
+
+// issueSummaries lists the issues matching keep by id, nil if there are none
+// like the scans of DynamoUserStore.
+func (s *MemoryStore) issueSummaries(keep func(*memoryIssue) bool) []*Issue {
+	var issues []*Issue
+	for _, issue := range s.issues {
+		if keep(issue) {
+			issues = append(issues, issue.summary())
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].ID < issues[j].ID })
+	return issues
+}
+
+func (s *MemoryStore) GetIssuesCreatedBy(userID string) ([]*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueSummaries(func(issue *memoryIssue) bool { return issue.creatorID == userID }), nil
+}
+
+func (s *MemoryStore) GetIssuesHelpedBy(userID string, userName string) ([]*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueSummaries(func(issue *memoryIssue) bool {
+		name, ok := issue.helpers[userID]
+		return ok && strings.Contains(name, userName)
+	}), nil
+}
+
+func (s *MemoryStore) GetSubscribedIssues(userID string) ([]*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issues := s.issueSummaries(func(issue *memoryIssue) bool { return s.subscriptions[userID][issue.ID] })
+	if issues == nil {
+		issues = make([]*Issue, 0)
+	}
+	return issues, nil
+}
+
+func (s *MemoryStore) GetNotifications(userID string, unreadOnly bool) ([]*Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notifications := make([]*Notification, 0)
+	for _, notification := range s.notifications[userID] {
+		if !unreadOnly || !notification.Read {
+			c := *notification
+			notifications = append(notifications, &c)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].ID > notifications[j].ID })
+	return notifications, nil
+}
+
+func (s *MemoryStore) MarkNotificationRead(userID string, notificationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, notification := range s.notifications[userID] {
+		if notification.ID == notificationID {
+			notification.Read = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateEmailOptOut(userID string, optOut []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return errNoUser
+	}
+	user.EmailOptOut = nil
+	seen := map[string]bool{}
+	for _, category := range optOut {
+		if !seen[category] {
+			seen[category] = true
+			user.EmailOptOut = append(user.EmailOptOut, category)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateDigestPreferences(userID string, digest *DigestRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return errNoUser
+	}
+	user.DigestFrequency = digest.Frequency
+	user.Location = digest.Location
+	user.Interests = append([]string{}, digest.Interests...)
+	return nil
+}
+
+func (s *MemoryStore) AddPost(post *Post, bonusPoints int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bonusPoints > 0 && post.IssueID != "" {
+		user, ok := s.users[post.UserId]
+		if !ok {
+			return errNoUser
+		}
+		if !s.storyIssues[post.UserId][post.IssueID] {
+			if s.storyIssues[post.UserId] == nil {
+				s.storyIssues[post.UserId] = map[string]bool{}
+			}
+			s.storyIssues[post.UserId][post.IssueID] = true
+			user.SamaritanPoints += bonusPoints
+		}
+	}
+	stored := copyPost(post)
+	stored.Edited = nil
+	stored.LikeCount = 0
+	stored.CommentCount = 0
+	s.posts[post.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetPost(postID string) (*Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	post, ok := s.posts[postID]
+	if !ok {
+		return nil, nil
+	}
+	return copyPost(post), nil
+}
+
+func (s *MemoryStore) UpdatePost(post *Post, title *string, description *string, edited shared.Time) (*Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.posts[post.ID]
+	if !ok || stored.UserId != post.UserId {
+		return nil, nil
+	}
+	stored.Edited = &edited
+	if title != nil {
+		stored.Title = *title
+	}
+	if description != nil {
+		stored.Description = *description
+	}
+	return copyPost(stored), nil
+}
+
+func (s *MemoryStore) DeletePost(post *Post) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.posts[post.ID]
+	if !ok || stored.UserId != post.UserId {
+		return false, nil
+	}
+	delete(s.posts, post.ID)
+	return true, nil
+}
+
+func (s *MemoryStore) Like(post *Post, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.likes[post.ID][userID] {
+		return false, nil
+	}
+	stored, ok := s.posts[post.ID]
+	if !ok {
+		return false, errNoPost
+	}
+	if s.likes[post.ID] == nil {
+		s.likes[post.ID] = map[string]bool{}
+	}
+	s.likes[post.ID][userID] = true
+	stored.LikeCount++
+	return true, nil
+}
+
+func (s *MemoryStore) Unlike(post *Post, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.likes[post.ID][userID] {
+		return false, nil
+	}
+	stored, ok := s.posts[post.ID]
+	if !ok {
+		return false, errNoPost
+	}
+	delete(s.likes[post.ID], userID)
+	stored.LikeCount--
+	return true, nil
+}
+
+func (s *MemoryStore) AddComment(post *Post, comment *PostComment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.posts[post.ID]
+	if !ok {
+		return errNoPost
+	}
+	c := *comment
+	comments := append(s.comments[post.ID], &c)
+	sort.Slice(comments, func(i, j int) bool { return comments[i].ID < comments[j].ID })
+	s.comments[post.ID] = comments
+	stored.CommentCount++
+	return nil
+}
+
+func (s *MemoryStore) GetComments(postID string, cursor string, limit int) (*PostCommentsPage, error) {
+	start, err := decodeCursor(cursor, "PostId", "CommentId")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page := &PostCommentsPage{Comments: []*PostComment{}}
+	for _, comment := range s.comments[postID] {
+		if start != nil && comment.ID <= start["CommentId"] {
+			continue
+		}
+		if len(page.Comments) == limit {
+			last := page.Comments[limit-1]
+			page.Cursor = encodeCursor(map[string]string{"PostId": postID, "CommentId": last.ID})
+			break
+		}
+		c := *comment
+		page.Comments = append(page.Comments, &c)
+	}
+	return page, nil
+}
+
+// postsPage returns a page of the posts matching keep, newest first. key
+// returns the cursor key of a post.
+func (s *MemoryStore) postsPage(keep func(*Post) bool, start map[string]string, limit int, key func(*Post) map[string]string) *PostsPage {
+	posts := make([]*Post, 0)
+	for _, post := range s.posts {
+		if keep(post) {
+			posts = append(posts, post)
+		}
+	}
+	// Posts sort by their stored PostTime, as in the indexes.
+	newer := func(postTime string, id string, than *Post) bool {
+		thanTime := than.PostTime.String()
+		return postTime > thanTime || (postTime == thanTime && id > than.ID)
+	}
+	sort.Slice(posts, func(i, j int) bool { return newer(posts[i].PostTime.String(), posts[i].ID, posts[j]) })
+	page := &PostsPage{Posts: []*Post{}}
+	for _, post := range posts {
+		if start != nil && !newer(start["PostTime"], start["Id"], post) {
+			continue
+		}
+		if len(page.Posts) == limit {
+			page.Cursor = encodeCursor(key(page.Posts[limit-1]))
+			break
+		}
+		page.Posts = append(page.Posts, copyPost(post))
+	}
+	return page
+}
+
+func (s *MemoryStore) GetFeed(cursor string, limit int) (*PostsPage, error) {
+	start, err := decodeCursor(cursor, "Id", "FeedKey", "PostTime")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.postsPage(func(*Post) bool { return true }, start, limit, func(post *Post) map[string]string {
+		return map[string]string{"Id": post.ID, "FeedKey": feedKey, "PostTime": post.PostTime.String()}
+	}), nil
+}
+
+func (s *MemoryStore) GetPostsByUser(userID string, cursor string, limit int) (*PostsPage, error) {
+	start, err := decodeCursor(cursor, "Id", "UserId", "PostTime")
+	if err != nil {
+		return nil, err
+	}
+	if start != nil && start["UserId"] != userID {
+		return nil, errInvalidCursor
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.postsPage(func(post *Post) bool { return post.UserId == userID }, start, limit, func(post *Post) map[string]string {
+		return map[string]string{"Id": post.ID, "UserId": post.UserId, "PostTime": post.PostTime.String()}
+	}), nil
+}
+
+func (s *MemoryStore) GetStoryIssue(issueID string) (*Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issue, ok := s.issues[issueID]
+	if !ok {
+		return nil, nil
+	}
+	story := issue.summary()
+	story.Accepted = append([]string(nil), issue.Accepted...)
+	return story, nil
+}
+
+func (s *MemoryStore) HasStory(issueID string, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, post := range s.posts {
+		if post.IssueID == issueID && post.UserId == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"shared"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var db *dynamodb.DynamoDB
+
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
+
+func putWebhook(webhook *Webhook) error {
+	item, err := dynamodbattribute.MarshalMap(webhook)
+	if err != nil {
+		return err
+	}
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tables.Webhooks),
+		Item:      item,
+	})
+	return err
+}
+
+func getWebhooks() ([]*Webhook, error) {
+	webhooks := make([]*Webhook, 0)
+	var unmarshalErr error
+	err := db.ScanPages(&dynamodb.ScanInput{TableName: aws.String(tables.Webhooks)},
+		func(page *dynamodb.ScanOutput, lastPage bool) bool {
+			pageWebhooks := make([]*Webhook, 0, len(page.Items))
+			unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageWebhooks)
+			webhooks = append(webhooks, pageWebhooks...)
+			return unmarshalErr == nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, unmarshalErr
+}
+
+func getWebhookById(webhookId string) (*Webhook, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(tables.Webhooks),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(webhookId),
+			},
+		},
+	}
+	result, err := db.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	webhook := new(Webhook)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// deleteWebhook deletes a webhook, reporting false if it did not exist. Its
+// delivery log is left to expire.
+func deleteWebhook(webhookId string) (bool, error) {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(tables.Webhooks),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Id": {
+				S: aws.String(webhookId),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(Id)"),
+	}
+	_, err := db.DeleteItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// getDeliveries returns up to limit deliveries of a webhook, newest first,
+// optionally only those with the given status.
+func getDeliveries(webhookId string, status string, limit int) ([]*Delivery, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tables.WebhookDeliveries),
+		KeyConditionExpression: aws.String("WebhookId = :w"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":w": {
+				S: aws.String(webhookId),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	if status != "" {
+		input.FilterExpression = aws.String("#s = :s")
+		input.ExpressionAttributeNames = map[string]*string{"#s": aws.String("Status")}
+		input.ExpressionAttributeValues[":s"] = &dynamodb.AttributeValue{S: aws.String(status)}
+	}
+	deliveries := make([]*Delivery, 0)
+	var unmarshalErr error
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			delivery := new(Delivery)
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, delivery); unmarshalErr != nil {
+				return false
+			}
+			deliveries = append(deliveries, delivery)
+			if len(deliveries) == limit {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, unmarshalErr
+}
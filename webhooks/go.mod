@@ -0,0 +1,12 @@
+require (
+	github.com/aws/aws-lambda-go v1.13.3
+	github.com/aws/aws-sdk-go v1.34.13
+	github.com/google/uuid v1.1.1
+	shared v0.0.0
+)
+
+replace shared => ../shared
+
+module webhooks
+
+go 1.14
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"shared"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/google/uuid"
+)
+
+const (
+	apiKeyHeader         = "X-Api-Key"
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+// webhookEvents are the events partners can subscribe to; the event
+// processor delivers them.
+var webhookEvents = []string{"issue.created", "issue.updated", "issue.resolved"}
+
+var deliveryStatuses = []string{"pending", "delivered", "deadletter"}
+
+var adminAPIKey string
+
+// Webhook is an endpoint registered by a partner organization. The secret
+// signs every delivery and is only returned when the webhook is registered.
+type Webhook struct {
+	ID         string      `json:"id" dynamodbav:"Id"`
+	URL        string      `json:"url" dynamodbav:"Url"`
+	Secret     string      `json:"secret,omitempty" dynamodbav:"Secret"`
+	Categories []string    `json:"categories" dynamodbav:"Categories"`
+	Locations  []string    `json:"locations" dynamodbav:"Locations"`
+	Events     []string    `json:"events" dynamodbav:"Events"`
+	Created    shared.Time `json:"created" dynamodbav:"Created"`
+}
+
+type WebhookRequest struct {
+	URL        string   `json:"url"`
+	Categories []string `json:"categories"`
+	Locations  []string `json:"locations"`
+	Events     []string `json:"events"`
+}
+
+// Delivery is an entry of the delivery log written by the event processor.
+type Delivery struct {
+	WebhookID      string      `json:"webhookid" dynamodbav:"WebhookId"`
+	DeliveryID     string      `json:"deliveryid" dynamodbav:"DeliveryId"`
+	Event          string      `json:"event" dynamodbav:"Event"`
+	IssueID        string      `json:"issueid" dynamodbav:"IssueId"`
+	Status         string      `json:"status" dynamodbav:"Status"`
+	Attempts       int         `json:"attempts" dynamodbav:"Attempts"`
+	ResponseStatus int         `json:"responsestatus" dynamodbav:"ResponseStatus"`
+	LastError      string      `json:"lasterror" dynamodbav:"LastError"`
+	Payload        string      `json:"payload" dynamodbav:"Payload"`
+	Created        shared.Time `json:"created" dynamodbav:"Created"`
+	// NextAttempt is when a pending delivery is retried.
+	NextAttempt shared.Time `json:"nextattempt" dynamodbav:"NextAttempt"`
+}
+
+// authorized checks the admin API key. Without a configured key every
+// request is refused.
+func authorized(req events.APIGatewayProxyRequest) bool {
+	key := req.Headers[apiKeyHeader]
+	if key == "" {
+		key = req.Headers[strings.ToLower(apiKeyHeader)]
+	}
+	return adminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminAPIKey)) == 1
+}
+
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !authorized(req) {
+		return shared.Status(http.StatusForbidden), nil
+	}
+	webhookId := req.PathParameters["webhookId"]
+	switch req.HTTPMethod {
+	case "GET":
+		if strings.HasSuffix(req.Path, "/deliveries") {
+			return fetchDeliveries(req, webhookId)
+		}
+		if webhookId != "" {
+			return fetch(webhookId)
+		}
+		return fetchAll()
+	case "POST":
+		return register(req)
+	case "DELETE":
+		return remove(webhookId)
+	default:
+		return shared.Status(http.StatusMethodNotAllowed), nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize trims and lower-cases the values, dropping empty ones, so that
+// they compare like the categories stored with issues.
+func normalize(values []string) []string {
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			normalized = append(normalized, value)
+		}
+	}
+	return normalized
+}
+
+// validateWebhook returns why a webhook request is invalid, or "".
+func validateWebhook(webhookRequest *WebhookRequest) string {
+	endpoint, err := url.Parse(webhookRequest.URL)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+	for _, event := range webhookRequest.Events {
+		if !contains(webhookEvents, event) {
+			return fmt.Sprintf("Unknown event %s, must be one of %s", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return ""
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func register(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	webhookRequest := new(WebhookRequest)
+	if err := json.Unmarshal([]byte(request.Body), webhookRequest); err != nil {
+		return shared.Status(http.StatusBadRequest), nil
+	}
+	if reason := validateWebhook(webhookRequest); reason != "" {
+		return shared.Text(http.StatusBadRequest, reason), nil
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return shared.Status(http.StatusInternalServerError), nil
+	}
+	webhook := &Webhook{
+		ID:         uuid.New().String(),
+		URL:        webhookRequest.URL,
+		Secret:     secret,
+		Categories: normalize(webhookRequest.Categories),
+		Locations:  normalize(webhookRequest.Locations),
+		Events:     normalize(webhookRequest.Events),
+		Created:    shared.Now(),
+	}
+	if err := putWebhook(webhook); err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.JSON(http.StatusCreated, webhook), nil
+}
+
+func fetchAll() (events.APIGatewayProxyResponse, error) {
+	webhooks, err := getWebhooks()
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return shared.JSON(http.StatusOK, webhooks), nil
+}
+
+func fetch(webhookId string) (events.APIGatewayProxyResponse, error) {
+	webhook, err := getWebhookById(webhookId)
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	if webhook == nil {
+		return shared.Status(http.StatusNotFound), nil
+	}
+	webhook.Secret = ""
+	return shared.JSON(http.StatusOK, webhook), nil
+}
+
+func remove(webhookId string) (events.APIGatewayProxyResponse, error) {
+	if webhookId == "" {
+		return shared.Status(http.StatusMethodNotAllowed), nil
+	}
+	deleted, err := deleteWebhook(webhookId)
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	if !deleted {
+		return shared.Status(http.StatusNotFound), nil
+	}
+	return shared.Text(http.StatusOK, "Successfully deleted the webhook"), nil
+}
+
+// fetchDeliveries returns the delivery log of a webhook, newest first.
+// ?status=deadletter lists the deliveries that could not be made.
+func fetchDeliveries(request events.APIGatewayProxyRequest, webhookId string) (events.APIGatewayProxyResponse, error) {
+	status := request.QueryStringParameters["status"]
+	if status != "" && !contains(deliveryStatuses, status) {
+		return shared.Text(http.StatusBadRequest, fmt.Sprintf("status must be one of %s", strings.Join(deliveryStatuses, ", "))), nil
+	}
+	limit := defaultDeliveryLimit
+	if l, ok := request.QueryStringParameters["limit"]; ok {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 || parsed > maxDeliveryLimit {
+			return shared.Text(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLimit)), nil
+		}
+		limit = parsed
+	}
+	deliveries, err := getDeliveries(webhookId, status, limit)
+	if err != nil {
+		return shared.Error(http.StatusBadGateway, err), nil
+	}
+	return shared.JSON(http.StatusOK, deliveries), nil
+}
+
+func main() {
+	adminAPIKey = os.Getenv("ADMIN_API_KEY")
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	db = shared.MustNewDynamoDB(cfg)
+	lambda.Start(shared.Logged(shared.CORS(router)))
+}
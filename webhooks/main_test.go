@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"shared"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestAuthorized(t *testing.T) {
+	adminAPIKey = "s3cret"
+	defer func() { adminAPIKey = "" }()
+
+	tests := []struct {
+		headers map[string]string
+		want    bool
+	}{
+		{map[string]string{"X-Api-Key": "s3cret"}, true},
+		{map[string]string{"x-api-key": "s3cret"}, true},
+		{map[string]string{"X-Api-Key": "guess"}, false},
+		{map[string]string{}, false},
+	}
+	for _, test := range tests {
+		if got := authorized(events.APIGatewayProxyRequest{Headers: test.headers}); got != test.want {
+			t.Errorf("authorized(%v) = %v, want %v", test.headers, got, test.want)
+		}
+	}
+
+	adminAPIKey = ""
+	if authorized(events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": ""}}) {
+		t.Error("authorized a request while no admin key is configured")
+	}
+}
+
+func TestRouterRequiresAdminKey(t *testing.T) {
+	adminAPIKey = "s3cret"
+	defer func() { adminAPIKey = "" }()
+
+	response, _ := router(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/webhooks"})
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("status without key = %d, want 403", response.StatusCode)
+	}
+	response, _ = shared.CORS(router)(events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS", Path: "/webhooks"})
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("preflight status = %d, want 200", response.StatusCode)
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	adminAPIKey = "s3cret"
+	defer func() { adminAPIKey = "" }()
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`not json`, http.StatusBadRequest},
+		{`{"url": "ftp://ngo.example.org/hook"}`, http.StatusBadRequest},
+		{`{"url": "/relative"}`, http.StatusBadRequest},
+		{`{"url": "https://ngo.example.org/hook", "events": ["issue.deleted"]}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		request := events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/webhooks", Body: test.body,
+			Headers: map[string]string{"X-Api-Key": "s3cret"}}
+		if response, _ := router(request); response.StatusCode != test.want {
+			t.Errorf("register(%s) = %d %s, want %d", test.body, response.StatusCode, response.Body, test.want)
+		}
+	}
+}
+
+func TestFetchDeliveriesValidation(t *testing.T) {
+	for _, query := range []map[string]string{{"status": "failed"}, {"limit": "0"}, {"limit": "1000"}} {
+		response, _ := fetchDeliveries(events.APIGatewayProxyRequest{QueryStringParameters: query}, "webhook-1")
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("fetchDeliveries(%v) = %d, want 400", query, response.StatusCode)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	got := normalize([]string{" Streetlights", "", "WATER ", "  "})
+	if want := []string{"streetlights", "water"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("normalize = %v, want %v", got, want)
+	}
+}
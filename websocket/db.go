@@ -0,0 +1,13 @@
+package main
+
+import (
+	"shared"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var db *dynamodb.DynamoDB
+
+// tables are the names of the tables, set from the configuration at cold
+// start.
+var tables = shared.DefaultTables
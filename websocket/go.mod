@@ -0,0 +1,14 @@
+require (
+	github.com/aws/aws-lambda-go v1.13.3
+	github.com/aws/aws-sdk-go v1.34.13
+	realtime v0.0.0
+	shared v0.0.0
+)
+
+replace realtime => ../realtime
+
+replace shared => ../shared
+
+module websocket
+
+go 1.14
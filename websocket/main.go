@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"realtime"
+	"shared"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+const (
+	routeConnect    = "$connect"
+	routeDisconnect = "$disconnect"
+	routeSubscribe  = realtime.ActionSubscribe
+)
+
+var manager *realtime.Manager
+
+// handler serves the routes of the WebSocket API. Clients connect with an
+// optional ?userid= and then send {"action": "subscribe", "issueId": "..."}
+// for every issue they want to receive comment, helper and status updates of.
+func handler(request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	connectionID := request.RequestContext.ConnectionID
+	var err error
+	switch request.RequestContext.RouteKey {
+	case routeConnect:
+		err = manager.Connect(connectionID, request.QueryStringParameters["userid"])
+	case routeDisconnect:
+		err = manager.Disconnect(connectionID)
+	case routeSubscribe:
+		err = manager.Receive(connectionID, []byte(request.Body))
+	default:
+		err = realtime.ErrUnknownAction
+	}
+	switch {
+	case err == nil:
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	case err == realtime.ErrUnknownAction || err == realtime.ErrMissingIssue || errors.Is(err, realtime.ErrBadMessage):
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest,
+			Body: err.Error()}, nil
+	default:
+		shared.Log.WithError(err).With("connectionId", connectionID).Errorf("Failed to handle %s", request.RequestContext.RouteKey)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError,
+			Body: http.StatusText(http.StatusInternalServerError)}, nil
+	}
+}
+
+func main() {
+	localAddr := flag.String("local", "", "serve WebSockets on this address with net/http instead of running as a lambda")
+	flag.Parse()
+
+	cfg := shared.MustLoadConfig()
+	tables = cfg.Tables
+	db = shared.MustNewDynamoDB(cfg)
+	store := &realtime.DynamoConnectionStore{DB: db, Table: tables.Connections}
+	if *localAddr != "" {
+		shared.Log.Infof("Serving WebSockets on %s", *localAddr)
+		if err := http.ListenAndServe(*localAddr, realtime.NewLocalServer(store)); err != nil {
+			shared.Log.WithError(err).Errorf("Failed to serve WebSockets")
+			os.Exit(1)
+		}
+		return
+	}
+	manager = &realtime.Manager{Store: store}
+	lambda.Start(func(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+		defer shared.LogInvocation(ctx)()
+		return handler(request)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"realtime"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func websocketRequest(routeKey string, connectionID string, body string) events.APIGatewayWebsocketProxyRequest {
+	return events.APIGatewayWebsocketProxyRequest{
+		Body: body,
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			RouteKey:     routeKey,
+			ConnectionID: connectionID,
+		},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	store := realtime.NewMemoryConnectionStore()
+	manager = &realtime.Manager{Store: store}
+
+	tests := []struct {
+		name   string
+		route  string
+		body   string
+		status int
+	}{
+		{"connect", routeConnect, "", http.StatusOK},
+		{"subscribe", routeSubscribe, `{"action": "subscribe", "issueId": "issue-1"}`, http.StatusOK},
+		{"subscribe without issue", routeSubscribe, `{"action": "subscribe"}`, http.StatusBadRequest},
+		{"bad json", routeSubscribe, `{"action": "subscribe",`, http.StatusBadRequest},
+		{"unknown route", "$default", `{"action": "shout"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		response, err := handler(websocketRequest(test.route, "c1", test.body))
+		if err != nil || response.StatusCode != test.status {
+			t.Errorf("%s: status %d, %v, want %d", test.name, response.StatusCode, err, test.status)
+		}
+	}
+	if got, _ := store.Subscribers("issue-1"); !reflect.DeepEqual(got, []string{"c1"}) {
+		t.Errorf("subscribers = %v, want [c1]", got)
+	}
+
+	if response, _ := handler(websocketRequest(routeDisconnect, "c1", "")); response.StatusCode != http.StatusOK {
+		t.Errorf("disconnect status %d", response.StatusCode)
+	}
+	if got, _ := store.Subscribers("issue-1"); len(got) != 0 {
+		t.Errorf("subscribers after disconnect = %v", got)
+	}
+}